@@ -78,4 +78,14 @@ import (
 	_ "dubbo.apache.org/dubbo-go/v3/registry/zookeeper"
 	_ "dubbo.apache.org/dubbo-go/v3/xds/client/controller/version/v2"
 	_ "dubbo.apache.org/dubbo-go/v3/xds/client/controller/version/v3"
+	_ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/bandwidthlimit"
+	_ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/cors"
+	_ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/extauthz"
+	_ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/fault"
+	_ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/grpcstats"
+	_ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/grpcweb"
+	_ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/headertometadata"
+	_ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/localratelimit"
+	_ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/rbac"
+	_ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/statefulsession"
 )
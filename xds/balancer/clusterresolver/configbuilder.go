@@ -319,7 +319,7 @@ func localitiesToRingHash(localities []resource.Locality, priorityName string) [
 			// Filter out all "unhealthy" endpoints (unknown and healthy are
 			// both considered to be healthy:
 			// https://www.envoyproxy.io/docs/envoy/latest/api-v2/api/v2/core/health_check.proto#envoy-api-enum-core-healthstatus).
-			if endpoint.HealthStatus != resource.EndpointHealthStatusHealthy && endpoint.HealthStatus != resource.EndpointHealthStatusUnknown {
+			if !endpoint.IsUsable() {
 				continue
 			}
 
@@ -357,7 +357,7 @@ func localitiesToWeightedTarget(localities []resource.Locality, priorityName str
 			// Filter out all "unhealthy" endpoints (unknown and healthy are
 			// both considered to be healthy:
 			// https://www.envoyproxy.io/docs/envoy/latest/api-v2/api/v2/core/health_check.proto#envoy-api-enum-core-healthstatus).
-			if endpoint.HealthStatus != resource.EndpointHealthStatusHealthy && endpoint.HealthStatus != resource.EndpointHealthStatusUnknown {
+			if !endpoint.IsUsable() {
 				continue
 			}
 
@@ -241,12 +241,27 @@ func (t *Controller) handleResponse(resp proto.Message) (resource.ResourceType,
 	if err != nil {
 		return rType, version, nonce, err
 	}
+	t.mu.Lock()
+	ackedVersion := t.versionMap[rType]
+	// The watched names may include some added after the request this
+	// response answers was sent. The pubsub only treats the missing names it
+	// has cached as removed, the others are left to the watch expiry timer.
+	resourceNames := mapToSlice(t.watchMap[rType])
+	t.mu.Unlock()
 	opts := &resource.UnmarshalOptions{
 		Version:         version,
+		Nonce:           nonce,
+		AckedVersion:    ackedVersion,
+		ResourceNames:   resourceNames,
+		TransportAPI:    t.config.TransportAPI,
 		Resources:       resources,
 		Logger:          t.logger,
 		UpdateValidator: t.updateValidator,
 	}
+	// Both the v2 and the v3 Node have an id.
+	if node, ok := t.config.NodeProto.(interface{ GetId() string }); ok {
+		opts.NodeID = node.GetId()
+	}
 	var md resource.UpdateMetadata
 	switch rType {
 	case resource.ListenerResource:
@@ -270,6 +285,11 @@ func (t *Controller) handleResponse(resp proto.Message) (resource.ResourceType,
 			ErrStr: fmt.Sprintf("Resource type %v unknown in response from server", rType),
 		}
 	}
+	if err == nil && md.ErrState != nil {
+		// The valid resources were applied above, but the server must learn
+		// about the invalid ones, so the response is NACKed.
+		err = md.ErrState.Err
+	}
 	return rType, version, nonce, err
 }
 
@@ -0,0 +1,193 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+import (
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	v3routerpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	v3httppb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	v3discoverypb "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/grpc"
+
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+import (
+	dubboLogger "dubbo.apache.org/dubbo-go/v3/common/logger"
+	"dubbo.apache.org/dubbo-go/v3/xds/client/bootstrap"
+	controllerversion "dubbo.apache.org/dubbo-go/v3/xds/client/controller/version"
+	"dubbo.apache.org/dubbo-go/v3/xds/client/resource"
+	"dubbo.apache.org/dubbo-go/v3/xds/client/resource/version"
+	_ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/router"
+	"dubbo.apache.org/dubbo-go/v3/xds/utils/buffer"
+)
+
+const defaultTestTimeout = 5 * time.Second
+
+type fakeStream struct {
+	grpc.ClientStream
+}
+
+type sentRequest struct {
+	names          []string
+	rType          resource.ResourceType
+	version, nonce string
+	errMsg         string
+}
+
+// fakeVersionClient serves the LDS responses of responses on any stream, and
+// records the requests sent. Only the ADS methods are implemented.
+type fakeVersionClient struct {
+	controllerversion.MetadataWrappedVersionClient
+	responses chan *v3discoverypb.DiscoveryResponse
+	requests  chan sentRequest
+}
+
+func (c *fakeVersionClient) SendRequest(_ grpc.ClientStream, names []string, rType resource.ResourceType, version, nonce, errMsg string) error {
+	c.requests <- sentRequest{names: names, rType: rType, version: version, nonce: nonce, errMsg: errMsg}
+	return nil
+}
+
+func (c *fakeVersionClient) RecvResponse(grpc.ClientStream) (proto.Message, error) {
+	resp, ok := <-c.responses
+	if !ok {
+		return nil, errors.New("stream closed")
+	}
+	return resp, nil
+}
+
+func (c *fakeVersionClient) ParseResponse(r proto.Message) (resource.ResourceType, []*anypb.Any, string, string, error) {
+	resp := r.(*v3discoverypb.DiscoveryResponse)
+	return resource.ListenerResource, resp.GetResources(), resp.GetVersionInfo(), resp.GetNonce(), nil
+}
+
+// fakeUpdateHandler records the LDS updates.
+type fakeUpdateHandler struct {
+	listeners chan map[string]resource.ListenerUpdateErrTuple
+}
+
+func (h *fakeUpdateHandler) NewListeners(u map[string]resource.ListenerUpdateErrTuple, _ resource.UpdateMetadata) {
+	h.listeners <- u
+}
+
+func (h *fakeUpdateHandler) NewRouteConfigs(map[string]resource.RouteConfigUpdateErrTuple, resource.UpdateMetadata) {
+}
+
+func (h *fakeUpdateHandler) NewClusters(map[string]resource.ClusterUpdateErrTuple, resource.UpdateMetadata) {
+}
+
+func (h *fakeUpdateHandler) NewEndpoints(map[string]resource.EndpointsUpdateErrTuple, resource.UpdateMetadata) {
+}
+
+func (h *fakeUpdateHandler) NewConnectionError(error) {}
+
+func marshalAny(t *testing.T, m proto.Message) *anypb.Any {
+	t.Helper()
+	a, err := ptypes.MarshalAny(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestNACKResponseWithInvalidResource(t *testing.T) {
+	hcm := marshalAny(t, &v3httppb.HttpConnectionManager{
+		RouteSpecifier: &v3httppb.HttpConnectionManager_Rds{Rds: &v3httppb.Rds{
+			ConfigSource: &v3corepb.ConfigSource{
+				ConfigSourceSpecifier: &v3corepb.ConfigSource_Ads{Ads: &v3corepb.AggregatedConfigSource{}},
+			},
+			RouteConfigName: "route",
+		}},
+		HttpFilters: []*v3httppb.HttpFilter{{
+			Name:       "router",
+			ConfigType: &v3httppb.HttpFilter_TypedConfig{TypedConfig: marshalAny(t, &v3routerpb.Router{})},
+		}},
+	})
+	good := marshalAny(t, &v3listenerpb.Listener{Name: "good", ApiListener: &v3listenerpb.ApiListener{ApiListener: hcm}})
+	// A listener with neither an api_listener nor an address is invalid.
+	bad := marshalAny(t, &v3listenerpb.Listener{Name: "bad"})
+
+	vClient := &fakeVersionClient{
+		responses: make(chan *v3discoverypb.DiscoveryResponse, 1),
+		requests:  make(chan sentRequest, 10),
+	}
+	handler := &fakeUpdateHandler{listeners: make(chan map[string]resource.ListenerUpdateErrTuple, 1)}
+	c := &Controller{
+		config:        &bootstrap.ServerConfig{TransportAPI: version.TransportV3},
+		updateHandler: handler,
+		logger:        dubboLogger.GetLogger(),
+		vClient:       vClient,
+		streamCh:      make(chan grpc.ClientStream, 1),
+		sendCh:        buffer.NewUnbounded(),
+		watchMap: map[resource.ResourceType]map[string]bool{
+			resource.ListenerResource: {"good": true, "bad": true},
+		},
+		versionMap: make(map[resource.ResourceType]string),
+		nonceMap:   make(map[resource.ResourceType]string),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTestTimeout)
+	defer cancel()
+	go c.send(ctx)
+
+	stream := &fakeStream{}
+	c.streamCh <- stream
+	// The first request is the one for the existing watches.
+	select {
+	case <-vClient.requests:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for the initial request")
+	}
+
+	vClient.responses <- &v3discoverypb.DiscoveryResponse{
+		VersionInfo: "1",
+		Nonce:       "nonce-1",
+		Resources:   []*anypb.Any{good, bad},
+	}
+	close(vClient.responses)
+	c.recv(stream)
+
+	// The valid listener is still applied.
+	select {
+	case u := <-handler.listeners:
+		if u["good"].Err != nil || u["bad"].Err == nil {
+			t.Errorf("NewListeners() got good err %v, bad err %v, want only bad to fail", u["good"].Err, u["bad"].Err)
+		}
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for the LDS update")
+	}
+	select {
+	case req := <-vClient.requests:
+		if req.errMsg == "" || req.version != "" || req.nonce != "nonce-1" {
+			t.Errorf("sent request %+v, want a NACK of nonce %q", req, "nonce-1")
+		}
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for the NACK")
+	}
+}
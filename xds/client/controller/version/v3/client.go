@@ -168,6 +168,10 @@ func (v3c *client) ParseResponse(r proto.Message) (resource.ResourceType, []*any
 	case resource.IsEndpointsResource(url):
 		rType = resource.EndpointsResource
 	default:
+		if t, ok := resource.RegisteredResourceType(url); ok {
+			rType = t
+			break
+		}
 		return rType, nil, "", "", controllerversion.ErrResourceTypeUnsupported{
 			ErrStr: fmt.Sprintf("Resource type %v unknown in response from server", resp.GetTypeUrl()),
 		}
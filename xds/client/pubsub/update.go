@@ -23,6 +23,10 @@
 
 package pubsub
 
+import (
+	"errors"
+)
+
 import (
 	"google.golang.org/protobuf/proto"
 )
@@ -92,6 +96,12 @@ func (pb *Pubsub) NewListeners(updates map[string]resource.ListenerUpdateErrTupl
 	defer pb.mu.Unlock()
 
 	for name, uErr := range updates {
+		if isNotFound(uErr.Err) {
+			// Cached resources are removed below. The response may answer
+			// a request sent before an uncached name was watched, so those
+			// are left to the watch expiry timer.
+			continue
+		}
 		if s, ok := pb.ldsWatchers[name]; ok {
 			if uErr.Err != nil {
 				// On error, keep previous version for each resource. But update
@@ -131,7 +141,7 @@ func (pb *Pubsub) NewListeners(updates map[string]resource.ListenerUpdateErrTupl
 	// Resources not in the new update were removed by the server, so delete
 	// them.
 	for name := range pb.ldsCache {
-		if _, ok := updates[name]; !ok {
+		if u, ok := updates[name]; !ok || isNotFound(u.Err) {
 			// If resource exists in cache, but not in the new update, delete
 			// the resource from cache, and also send an resource not found
 			// error to indicate resource removed.
@@ -198,7 +208,7 @@ func (pb *Pubsub) NewClusters(updates map[string]resource.ClusterUpdateErrTuple,
 	defer pb.mu.Unlock()
 
 	for k, update := range pb.cdsCache {
-		if _, ok := updates[k]; !ok {
+		if u, ok := updates[k]; !ok || isNotFound(u.Err) {
 			// this is a delete event
 			s, ok := pb.cdsWatchers[k]
 			if !ok {
@@ -215,6 +225,12 @@ func (pb *Pubsub) NewClusters(updates map[string]resource.ClusterUpdateErrTuple,
 	}
 
 	for name, uErr := range updates {
+		if isNotFound(uErr.Err) {
+			// Cached resources are removed below. The response may answer
+			// a request sent before an uncached name was watched, so those
+			// are left to the watch expiry timer.
+			continue
+		}
 		s, ok := pb.cdsWatchers[name]
 		if !ok {
 			s, ok = pb.cdsWatchers["*"]
@@ -260,12 +276,12 @@ func (pb *Pubsub) NewClusters(updates map[string]resource.ClusterUpdateErrTuple,
 	// Resources not in the new update were removed by the server, so delete
 	// them.
 	for name := range pb.cdsCache {
-		if _, ok := updates[name]; !ok {
+		if u, ok := updates[name]; !ok || isNotFound(u.Err) {
 			// If resource exists in cache, but not in the new update, delete it
 			// from cache, and also send an resource not found error to indicate
 			// resource removed.
 			delete(pb.cdsCache, name)
-			pb.ldsMD[name] = resource.UpdateMetadata{Status: resource.ServiceStatusNotExist}
+			pb.cdsMD[name] = resource.UpdateMetadata{Status: resource.ServiceStatusNotExist}
 			for wi := range pb.cdsWatchers[name] {
 				wi.resourceNotFound()
 			}
@@ -349,3 +365,9 @@ func (pb *Pubsub) NewConnectionError(err error) {
 		}
 	}
 }
+
+// isNotFound reports whether err is the error of a requested resource missing
+// from a Listener or Cluster response, i.e. removed by the server.
+func isNotFound(err error) bool {
+	return err != nil && errors.Is(err, resource.ErrResourceNotFound)
+}
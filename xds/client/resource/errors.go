@@ -24,6 +24,7 @@
 package resource
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -43,6 +44,17 @@ const (
 	ErrorTypeResourceNotFound
 )
 
+var (
+	// ErrResourceNotFound is matched, with errors.Is, by the errors of
+	// resources which don't exist: those of ErrorTypeResourceNotFound, e.g.
+	// the errors of requested resources missing from a Listener or Cluster
+	// response.
+	ErrResourceNotFound = errors.New("xds: resource not found")
+	// ErrResourceNACKed is matched, with errors.Is, by the errors of
+	// resources which are in a response but are invalid, i.e. NACKErrors.
+	ErrResourceNACKed = errors.New("xds: resource NACKed")
+)
+
 type xdsClientError struct {
 	t    ErrorType
 	desc string
@@ -52,6 +64,12 @@ func (e *xdsClientError) Error() string {
 	return e.desc
 }
 
+// Is reports whether target is ErrResourceNotFound and e is of
+// ErrorTypeResourceNotFound.
+func (e *xdsClientError) Is(target error) bool {
+	return target == ErrResourceNotFound && e.t == ErrorTypeResourceNotFound
+}
+
 // NewErrorf creates an xds client error. The callbacks are called with this
 // error, to pass additional information about the error.
 func NewErrorf(t ErrorType, format string, args ...interface{}) error {
@@ -65,3 +83,158 @@ func ErrType(e error) ErrorType {
 	}
 	return ErrorTypeUnknown
 }
+
+// NACKReason is a machine readable reason for which a resource was NACKed.
+type NACKReason int
+
+const (
+	// ReasonUnknown indicates the NACK doesn't have a specific reason.
+	ReasonUnknown NACKReason = iota
+	// ReasonUnexpectedResourceType indicates the resource has an unexpected
+	// type URL.
+	ReasonUnexpectedResourceType
+	// ReasonUnmarshalFailed indicates the resource (or a message embedded in
+	// it) could not be unmarshaled.
+	ReasonUnmarshalFailed
+	// ReasonUnsupportedField indicates the resource sets a field which is not
+	// supported.
+	ReasonUnsupportedField
+	// ReasonMissingAddress indicates a server-side listener without a socket
+	// address.
+	ReasonMissingAddress
+	// ReasonInvalidHTTPConnManager indicates the HttpConnectionManager failed
+	// validation.
+	ReasonInvalidHTTPConnManager
+	// ReasonInvalidRouteSpecifier indicates the HttpConnectionManager carries
+	// an invalid or unsupported route specifier.
+	ReasonInvalidRouteSpecifier
+	// ReasonInvalidRouteConfig indicates the inline route configuration
+	// failed validation.
+	ReasonInvalidRouteConfig
+	// ReasonInvalidHTTPFilter indicates an HTTP filter failed validation.
+	ReasonInvalidHTTPFilter
+	// ReasonEmptyHTTPFilters indicates the list of HTTP filters is empty.
+	ReasonEmptyHTTPFilters
+	// ReasonTerminalFilterNotLast indicates a terminal HTTP filter is not the
+	// last filter in the filter chain.
+	ReasonTerminalFilterNotLast
+	// ReasonMissingTerminalFilter indicates the last HTTP filter in the filter
+	// chain is not a terminal filter.
+	ReasonMissingTerminalFilter
+	// ReasonInvalidFilterChain indicates a server-side filter chain failed
+	// validation.
+	ReasonInvalidFilterChain
+	// ReasonValidationFailed indicates the update was rejected by the
+	// UpdateValidator of the upper layer.
+	ReasonValidationFailed
+	// ReasonAmbiguousListener indicates a listener which is neither clearly
+	// client-side nor server-side, i.e. it sets both or none of api_listener
+	// and address.
+	ReasonAmbiguousListener
+	// ReasonInvalidAddress indicates a server-side listener with an invalid
+	// socket address.
+	ReasonInvalidAddress
+	// ReasonResourceTooLarge indicates the serialized resource is larger than
+	// UnmarshalOptions.MaxResourceBytes.
+	ReasonResourceTooLarge
+	// ReasonPanic indicates processing the resource panicked, e.g. in proto
+	// reflection on adversarial input.
+	ReasonPanic
+)
+
+func (r NACKReason) String() string {
+	switch r {
+	case ReasonUnexpectedResourceType:
+		return "UnexpectedResourceType"
+	case ReasonUnmarshalFailed:
+		return "UnmarshalFailed"
+	case ReasonUnsupportedField:
+		return "UnsupportedField"
+	case ReasonMissingAddress:
+		return "MissingAddress"
+	case ReasonInvalidHTTPConnManager:
+		return "InvalidHTTPConnManager"
+	case ReasonInvalidRouteSpecifier:
+		return "InvalidRouteSpecifier"
+	case ReasonInvalidRouteConfig:
+		return "InvalidRouteConfig"
+	case ReasonInvalidHTTPFilter:
+		return "InvalidHTTPFilter"
+	case ReasonEmptyHTTPFilters:
+		return "EmptyHTTPFilters"
+	case ReasonTerminalFilterNotLast:
+		return "TerminalFilterNotLast"
+	case ReasonMissingTerminalFilter:
+		return "MissingTerminalFilter"
+	case ReasonInvalidFilterChain:
+		return "InvalidFilterChain"
+	case ReasonValidationFailed:
+		return "ValidationFailed"
+	case ReasonAmbiguousListener:
+		return "AmbiguousListener"
+	case ReasonInvalidAddress:
+		return "InvalidAddress"
+	case ReasonResourceTooLarge:
+		return "ResourceTooLarge"
+	case ReasonPanic:
+		return "Panic"
+	default:
+		return "Unknown"
+	}
+}
+
+// NACKError is the error returned when a resource fails validation, and
+// causes the response containing it to be NACKed.
+type NACKError struct {
+	// ResourceName is the name of the NACKed resource. It is empty if the name
+	// could not be determined.
+	ResourceName string
+	// ResourceType is the type of the NACKed resource.
+	ResourceType ResourceType
+	// Reason is the machine readable reason of the NACK.
+	Reason NACKReason
+	// Err describes why the resource was NACKed.
+	Err error
+}
+
+func (e *NACKError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *NACKError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrResourceNACKed.
+func (e *NACKError) Is(target error) bool {
+	return target == ErrResourceNACKed
+}
+
+// NACKReasonOf returns the reason of the NACK if e is a NACKError, and
+// ReasonUnknown otherwise.
+func NACKReasonOf(e error) NACKReason {
+	var ne *NACKError
+	if errors.As(e, &ne) {
+		return ne.Reason
+	}
+	return ReasonUnknown
+}
+
+// nackErrorf creates a NACKError with the given reason. The resource name and
+// type are filled in by the unmarshal function of the resource.
+func nackErrorf(reason NACKReason, format string, args ...interface{}) error {
+	return &NACKError{Reason: reason, Err: fmt.Errorf(format, args...)}
+}
+
+// annotateNACKError sets the resource name and type on err, converting it to
+// a NACKError with ReasonUnknown if it isn't one already.
+func annotateNACKError(err error, rType ResourceType, name string) error {
+	ne, ok := err.(*NACKError)
+	if !ok {
+		ne = &NACKError{Reason: ReasonUnknown, Err: err}
+	}
+	ne.ResourceName = name
+	ne.ResourceType = rType
+	return ne
+}
@@ -27,22 +27,28 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 import (
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	v3listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	v3httppb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	v3tcpproxypb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
 	v3tlspb "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
 import (
 	dubboLogger "dubbo.apache.org/dubbo-go/v3/common/logger"
 	"dubbo.apache.org/dubbo-go/v3/xds/client/resource/version"
 	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
-	"dubbo.apache.org/dubbo-go/v3/xds/utils/envconfig"
 	"dubbo.apache.org/dubbo-go/v3/xds/utils/resolver"
 )
 
@@ -62,6 +68,18 @@ const (
 	// use a value of -1 for the latter.
 	noPrefixMatch          = -2
 	unspecifiedPrefixMatch = -1
+
+	// Server names are matched in the same way: an exact match is preferred,
+	// then the wildcard match with the longest suffix, where the suffix
+	// length is the match size, and then an unspecified server name.
+	noServerNameMatch          = -2
+	unspecifiedServerNameMatch = -1
+	exactServerNameMatch       = 1 << 16
+
+	// The transport protocols which filter chains can match on. A connection
+	// whose transport protocol isn't known is assumed to be raw_buffer.
+	transportProtocolRawBuffer = "raw_buffer"
+	transportProtocolTLS       = "tls"
 )
 
 // FilterChain captures information from within a FilterChain message in a
@@ -80,6 +98,60 @@ type FilterChain struct {
 	//
 	// Exactly one of RouteConfigName and InlineRouteConfig is set.
 	InlineRouteConfig *RouteConfigUpdate
+	// TCPProxy is set if the filter chain forwards connections with a
+	// tcp_proxy filter instead of handling them as HTTP. HTTPFilters,
+	// RouteConfigName and InlineRouteConfig are then all unset. The xDS
+	// server doesn't forward connections, so it closes those matching such a
+	// filter chain.
+	TCPProxy *TCPProxyConfig
+	// Match contains the match criteria specified for this FilterChain. It is
+	// left empty for the default filter chain.
+	Match FilterChainMatch
+}
+
+// TCPProxyConfig is the destination of a filter chain with a tcp_proxy
+// network filter.
+type TCPProxyConfig struct {
+	// Cluster is the cluster connections are forwarded to.
+	//
+	// Exactly one of Cluster and WeightedClusters is set.
+	Cluster string
+	// WeightedClusters are the clusters connections are forwarded to, by the
+	// cluster name. Only the weights are set.
+	//
+	// Exactly one of Cluster and WeightedClusters is set.
+	WeightedClusters map[string]WeightedCluster
+}
+
+// FilterChainMatch captures the match criteria from within a FilterChainMatch
+// message in a Listener resource.
+type FilterChainMatch struct {
+	// DestinationPort is the local port of an incoming connection which this
+	// filter chain matches. Zero indicates that the destination port was not
+	// specified and the filter chain matches any port.
+	DestinationPort uint32
+	// ServerNames are the server names (e.g. the SNI of a TLS connection)
+	// which this filter chain matches, lower cased. A name may be a wildcard
+	// of the form "*.example.com". Empty if the filter chain matches any
+	// server name.
+	ServerNames []string
+	// TransportProtocol is the transport protocol, "raw_buffer" or "tls",
+	// which this filter chain matches. Empty if it matches any.
+	TransportProtocol string
+	// ApplicationProtocols are the application protocols negotiated with
+	// ALPN, e.g. "h2" or "http/1.1", which this filter chain matches. Empty if
+	// it matches any. They are only set along with the tls transport
+	// protocol.
+	ApplicationProtocols []string
+	// SourceType is the type of the connection source which this filter
+	// chain matches.
+	SourceType SourceType
+	// SourcePrefixRanges are the ranges of remote addresses which this
+	// filter chain matches. Empty if it matches any address.
+	SourcePrefixRanges []*net.IPNet
+	// SourcePorts are the remote ports which this filter chain matches. Empty
+	// if it matches any port.
+	SourcePorts []uint32
 }
 
 // VirtualHostWithInterceptors captures information present in a VirtualHost
@@ -188,11 +260,43 @@ const (
 // 6. Source type (e.g. any, local or external network).
 // 7. Source IP address.
 // 8. Source port.
+//
+// At each stage, only the filter chains with the most specific match for the
+// connection go on to the next stage: an exact destination port over an
+// unspecified one, the longest matching destination and source prefixes, an
+// exact server name over the longest matching wildcard, the transport
+// protocol, application protocol and source type of the connection over an
+// unspecified one, and an exact source port over an unspecified one. If no
+// filter chain is left, the default filter chain is used.
 type FilterChainManager struct {
 	logger dubboLogger.Logger
-	// Destination prefix is the first match criteria that we support.
-	// Therefore, this multi-stage map is indexed on destination prefixes
+	// treatUnknownFiltersAsOptional is
+	// UnmarshalOptions.TreatUnknownFiltersAsOptional.
+	treatUnknownFiltersAsOptional bool
+	// filterConfigs caches the HTTP filter configs parsed while building the
+	// manager, as filter chains commonly share identical configs. It is
+	// released once the manager is built.
+	filterConfigs *filterConfigCache
+	// Destination port is the first match criteria that we support.
+	// Therefore, this multi-stage map is indexed on destination ports
 	// specified in the match criteria.
+	// Unspecified destination port matches end up as a wildcard entry here
+	// with a key of 0.
+	dstPortMap map[int]*destPortEntry
+
+	def *FilterChain // Default filter chain, if specified.
+
+	// RouteConfigNames are the route configuration names which need to be
+	// dynamically queried for RDS Configuration for any FilterChains which
+	// specify to load RDS Configuration dynamically.
+	RouteConfigNames map[string]bool
+}
+
+// destPortEntry is the value type of the map indexed on destination ports.
+type destPortEntry struct {
+	// Destination prefix is the second match criteria that we support.
+	// Therefore, this map is indexed on destination prefixes specified in the
+	// match criteria.
 	// Unspecified destination prefix matches end up as a wildcard entry here
 	// with a key of 0.0.0.0/0.
 	dstPrefixMap map[string]*destPrefixEntry
@@ -206,31 +310,37 @@ type FilterChainManager struct {
 	// TODO: Implement LC-trie to support logarithmic time lookups. If that
 	// involves too much time/effort, sort this slice based on the netmask size.
 	dstPrefixes []*destPrefixEntry
-
-	def *FilterChain // Default filter chain, if specified.
-
-	// RouteConfigNames are the route configuration names which need to be
-	// dynamically queried for RDS Configuration for any FilterChains which
-	// specify to load RDS Configuration dynamically.
-	RouteConfigNames map[string]bool
 }
 
 // destPrefixEntry is the value type of the map indexed on destination prefixes.
 type destPrefixEntry struct {
 	// The actual destination prefix. Set to nil for unspecified prefixes.
 	net *net.IPNet
-	// We need to keep track of the transport protocols seen as part of the
-	// config validation (and internal structure building) phase. The only two
-	// values that we support are empty string and "raw_buffer", with the latter
-	// taking preference. Once we have seen one filter chain with "raw_buffer",
-	// we can drop everything other filter chain with an empty transport
-	// protocol.
-	rawBufferSeen bool
-	// For each specified source type in the filter chain match criteria, this
-	// array points to the set of specified source prefixes.
-	// Unspecified source type matches end up as a wildcard entry here with an
-	// index of 0, which actually represents the source type `ANY`.
-	srcTypeArr sourceTypesArray
+	// Server name is the third match criteria that we support. Therefore,
+	// this map is indexed on the server names specified in the match
+	// criteria. Unspecified server name matches end up as an entry here with
+	// an empty key.
+	serverNameMap map[string]*serverNameEntry
+}
+
+// serverNameEntry is the value type of the map indexed on server names.
+type serverNameEntry struct {
+	// Transport protocol is the fourth match criteria that we support. This
+	// map is indexed on the transport protocols specified in the match
+	// criteria. Unspecified transport protocol matches end up as an entry
+	// here with an empty key.
+	transportProtocolMap map[string]*transportProtocolEntry
+}
+
+// transportProtocolEntry is the value type of the map indexed on transport
+// protocols.
+type transportProtocolEntry struct {
+	// Application protocol is the fifth match criteria that we support. This
+	// map is indexed on the application protocols specified in the match
+	// criteria, and points to the set of specified source types for each.
+	// Unspecified application protocol matches end up as an entry here with
+	// an empty key.
+	applicationProtocolMap map[string]*sourceTypesArray
 }
 
 // An array for the fixed number of source types that we have.
@@ -240,7 +350,7 @@ type sourceTypesArray [3]*sourcePrefixes
 // match criteria. These are pointed to by the array of source types.
 type sourcePrefixes struct {
 	// These are very similar to the 'dstPrefixMap' and 'dstPrefixes' field of
-	// FilterChainManager. Go there for more info.
+	// destPortEntry. Go there for more info.
 	srcPrefixMap map[string]*sourcePrefixEntry
 	srcPrefixes  []*sourcePrefixEntry
 }
@@ -261,39 +371,58 @@ type sourcePrefixEntry struct {
 // This function is only exported so that tests outside of this package can
 // create a FilterChainManager.
 func NewFilterChainManager(lis *v3listenerpb.Listener, logger dubboLogger.Logger) (*FilterChainManager, error) {
+	return newFilterChainManager(lis, &UnmarshalOptions{Logger: logger})
+}
+
+// newFilterChainManager is NewFilterChainManager, with the logger and the HTTP
+// filter options taken from opts.
+func newFilterChainManager(lis *v3listenerpb.Listener, opts *UnmarshalOptions) (*FilterChainManager, error) {
 	// Parse all the filter chains and build the internal data structures.
 	fci := &FilterChainManager{
-		logger:           logger,
-		dstPrefixMap:     make(map[string]*destPrefixEntry),
-		RouteConfigNames: make(map[string]bool),
+		logger:                        opts.Logger,
+		treatUnknownFiltersAsOptional: opts.TreatUnknownFiltersAsOptional,
+		filterConfigs:                 newFilterConfigCache(),
+		dstPortMap:                    make(map[int]*destPortEntry),
+		RouteConfigNames:              make(map[string]bool),
 	}
+	defer func() { fci.filterConfigs = nil }()
 	if err := fci.addFilterChains(lis.GetFilterChains()); err != nil {
 		return nil, err
 	}
 	// Build the source and dest prefix slices used by Lookup().
 	fcSeen := false
-	for _, dstPrefix := range fci.dstPrefixMap {
-		fci.dstPrefixes = append(fci.dstPrefixes, dstPrefix)
-		for _, st := range dstPrefix.srcTypeArr {
-			if st == nil {
-				continue
-			}
-			for _, srcPrefix := range st.srcPrefixMap {
-				st.srcPrefixes = append(st.srcPrefixes, srcPrefix)
-				for _, fc := range srcPrefix.srcPortMap {
-					if fc != nil {
-						fcSeen = true
+	for _, dstPort := range fci.dstPortMap {
+		for _, dstPrefix := range dstPort.dstPrefixMap {
+			dstPort.dstPrefixes = append(dstPort.dstPrefixes, dstPrefix)
+			for _, sn := range dstPrefix.serverNameMap {
+				for _, tp := range sn.transportProtocolMap {
+					for _, srcTypeArr := range tp.applicationProtocolMap {
+						for _, st := range srcTypeArr {
+							if st == nil {
+								continue
+							}
+							for _, srcPrefix := range st.srcPrefixMap {
+								st.srcPrefixes = append(st.srcPrefixes, srcPrefix)
+								for _, fc := range srcPrefix.srcPortMap {
+									if fc != nil {
+										fcSeen = true
+									}
+								}
+							}
+						}
 					}
 				}
 			}
 		}
 	}
 
-	// Retrieve the default filter chain. The match criteria specified on the
-	// default filter chain is never used. The default filter chain simply gets
-	// used when none of the other filter chains match.
+	// Retrieve the default filter chain. It gets used when none of the other
+	// filter chains match, so Envoy forbids match criteria on it.
 	var def *FilterChain
 	if dfc := lis.GetDefaultFilterChain(); dfc != nil {
+		if dfc.GetFilterChainMatch() != nil {
+			return nil, fmt.Errorf("default filter chain %q specifies a filter_chain_match", dfc.GetName())
+		}
 		var err error
 		if def, err = fci.filterChainFromProto(dfc); err != nil {
 			return nil, err
@@ -312,17 +441,33 @@ func NewFilterChainManager(lis *v3listenerpb.Listener, logger dubboLogger.Logger
 // addFilterChains parses the filter chains in fcs and adds the required
 // internal data structures corresponding to the match criteria.
 func (fci *FilterChainManager) addFilterChains(fcs []*v3listenerpb.FilterChain) error {
+	// Filter chains with identical match criteria are reported by their
+	// indices before any of them is added. Chains which only share some of
+	// the criteria, e.g. a source port, are rejected while being added.
+	seen := make(map[string]int, len(fcs))
+	for i, fc := range fcs {
+		key, err := filterChainMatchKey(fc.GetFilterChainMatch())
+		if err != nil {
+			return fmt.Errorf("filter chain %d (%q): %v", i, fc.GetName(), err)
+		}
+		if j, ok := seen[key]; ok {
+			return fmt.Errorf("filter chains %d (%q) and %d (%q) have identical match criteria", j, fcs[j].GetName(), i, fc.GetName())
+		}
+		seen[key] = i
+	}
+
 	for _, fc := range fcs {
-		fcm := fc.GetFilterChainMatch()
-		if fcm.GetDestinationPort().GetValue() != 0 {
-			// Destination port is the first match criteria and we do not
-			// support filter chains which contains this match criteria.
-			fci.logger.Warnf("Dropping filter chain %+v since it contains unsupported destination_port match field", fc)
-			continue
+		// Use the wildcard port '0', when destination port is unspecified.
+		dstPort := int(fc.GetFilterChainMatch().GetDestinationPort().GetValue())
+		if dstPort > 65535 {
+			return fmt.Errorf("filter chain %+v contains invalid destination_port %d", fc, dstPort)
+		}
+		if fci.dstPortMap[dstPort] == nil {
+			fci.dstPortMap[dstPort] = &destPortEntry{dstPrefixMap: make(map[string]*destPrefixEntry)}
 		}
 
 		// Build the internal representation of the filter chain match fields.
-		if err := fci.addFilterChainsForDestPrefixes(fc); err != nil {
+		if err := fci.addFilterChainsForDestPrefixes(fci.dstPortMap[dstPort], fc); err != nil {
 			return err
 		}
 	}
@@ -330,85 +475,186 @@ func (fci *FilterChainManager) addFilterChains(fcs []*v3listenerpb.FilterChain)
 	return nil
 }
 
-func (fci *FilterChainManager) addFilterChainsForDestPrefixes(fc *v3listenerpb.FilterChain) error {
-	ranges := fc.GetFilterChainMatch().GetPrefixRanges()
-	dstPrefixes := make([]*net.IPNet, 0, len(ranges))
+// filterChainMatchKey returns a canonical representation of all the criteria
+// of fcm, the same for two matches iff they match the same connections. The
+// order of the values of list criteria doesn't matter, and prefix ranges and
+// server names are normalized.
+func filterChainMatchKey(fcm *v3listenerpb.FilterChainMatch) (string, error) {
+	prefixes := func(ranges []*v3corepb.CidrRange) ([]string, error) {
+		nets, err := parsePrefixRanges(ranges)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse prefix range: %v", err)
+		}
+		ret := make([]string, 0, len(nets))
+		for _, n := range nets {
+			ret = append(ret, n.String())
+		}
+		return ret, nil
+	}
+	ports := func(ps []uint32) []string {
+		ret := make([]string, 0, len(ps))
+		for _, p := range ps {
+			ret = append(ret, strconv.FormatUint(uint64(p), 10))
+		}
+		return ret
+	}
+	dstPrefixes, err := prefixes(fcm.GetPrefixRanges())
+	if err != nil {
+		return "", err
+	}
+	srcPrefixes, err := prefixes(fcm.GetSourcePrefixRanges())
+	if err != nil {
+		return "", err
+	}
+	serverNames := make([]string, 0, len(fcm.GetServerNames()))
+	for _, sn := range fcm.GetServerNames() {
+		serverNames = append(serverNames, strings.ToLower(sn))
+	}
+	fields := [][]string{
+		{strconv.FormatUint(uint64(fcm.GetDestinationPort().GetValue()), 10)},
+		dstPrefixes,
+		serverNames,
+		{fcm.GetTransportProtocol()},
+		append([]string(nil), fcm.GetApplicationProtocols()...),
+		{fcm.GetSourceType().String()},
+		srcPrefixes,
+		ports(fcm.GetSourcePorts()),
+	}
+	var b strings.Builder
+	for _, f := range fields {
+		sort.Strings(f)
+		b.WriteString(strings.Join(f, ","))
+		b.WriteByte(';')
+	}
+	return b.String(), nil
+}
+
+// parsePrefixRanges parses the CIDR ranges of a filter chain match.
+func parsePrefixRanges(ranges []*v3corepb.CidrRange) ([]*net.IPNet, error) {
+	prefixes := make([]*net.IPNet, 0, len(ranges))
 	for _, pr := range ranges {
 		cidr := fmt.Sprintf("%s/%d", pr.GetAddressPrefix(), pr.GetPrefixLen().GetValue())
 		_, ipnet, err := net.ParseCIDR(cidr)
 		if err != nil {
-			return fmt.Errorf("failed to parse destination prefix range: %+v", pr)
+			return nil, fmt.Errorf("%+v", pr)
 		}
-		dstPrefixes = append(dstPrefixes, ipnet)
+		prefixes = append(prefixes, ipnet)
+	}
+	return prefixes, nil
+}
+
+func (fci *FilterChainManager) addFilterChainsForDestPrefixes(dstPortEntry *destPortEntry, fc *v3listenerpb.FilterChain) error {
+	dstPrefixes, err := parsePrefixRanges(fc.GetFilterChainMatch().GetPrefixRanges())
+	if err != nil {
+		return fmt.Errorf("failed to parse destination prefix range: %v", err)
 	}
 
 	if len(dstPrefixes) == 0 {
 		// Use the unspecified entry when destination prefix is unspecified, and
 		// set the `net` field to nil.
-		if fci.dstPrefixMap[unspecifiedPrefixMapKey] == nil {
-			fci.dstPrefixMap[unspecifiedPrefixMapKey] = &destPrefixEntry{}
+		if dstPortEntry.dstPrefixMap[unspecifiedPrefixMapKey] == nil {
+			dstPortEntry.dstPrefixMap[unspecifiedPrefixMapKey] = &destPrefixEntry{serverNameMap: make(map[string]*serverNameEntry)}
 		}
-		return fci.addFilterChainsForServerNames(fci.dstPrefixMap[unspecifiedPrefixMapKey], fc)
+		return fci.addFilterChainsForServerNames(dstPortEntry.dstPrefixMap[unspecifiedPrefixMapKey], fc)
 	}
 	for _, prefix := range dstPrefixes {
 		p := prefix.String()
-		if fci.dstPrefixMap[p] == nil {
-			fci.dstPrefixMap[p] = &destPrefixEntry{net: prefix}
+		if dstPortEntry.dstPrefixMap[p] == nil {
+			dstPortEntry.dstPrefixMap[p] = &destPrefixEntry{net: prefix, serverNameMap: make(map[string]*serverNameEntry)}
 		}
-		if err := fci.addFilterChainsForServerNames(fci.dstPrefixMap[p], fc); err != nil {
+		if err := fci.addFilterChainsForServerNames(dstPortEntry.dstPrefixMap[p], fc); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// addFilterChainsForServerNames adds server names to the internal data
+// structures and delegates control to addFilterChainsForTransportProtocols to
+// continue building the internal data structure.
 func (fci *FilterChainManager) addFilterChainsForServerNames(dstEntry *destPrefixEntry, fc *v3listenerpb.FilterChain) error {
-	// Filter chains specifying server names in their match criteria always fail
-	// a match at connection time. So, these filter chains can be dropped now.
-	if len(fc.GetFilterChainMatch().GetServerNames()) != 0 {
-		fci.logger.Warnf("Dropping filter chain %+v since it contains unsupported server_names match field", fc)
-		return nil
+	serverNames := fc.GetFilterChainMatch().GetServerNames()
+	// Server names are only known for TLS connections, so Envoy requires a
+	// filter chain matching on them to match on the tls transport protocol.
+	if tp := fc.GetFilterChainMatch().GetTransportProtocol(); len(serverNames) != 0 && tp != transportProtocolTLS {
+		return fmt.Errorf("filter chain %+v specifies server_names with transport_protocol %q, want %q", fc, tp, transportProtocolTLS)
+	}
+	if len(serverNames) == 0 {
+		serverNames = []string{""}
+	}
+	for _, sn := range serverNames {
+		if sn != "" && !isValidServerName(sn) {
+			return fmt.Errorf("filter chain %+v contains invalid server name %q", fc, sn)
+		}
+		sn = strings.ToLower(sn)
+		if dstEntry.serverNameMap[sn] == nil {
+			dstEntry.serverNameMap[sn] = &serverNameEntry{transportProtocolMap: make(map[string]*transportProtocolEntry)}
+		}
+		if err := fci.addFilterChainsForTransportProtocols(dstEntry.serverNameMap[sn], fc); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	return fci.addFilterChainsForTransportProtocols(dstEntry, fc)
+// isValidServerName reports whether sn is a server name, or a wildcard of
+// the form "*.example.com".
+func isValidServerName(sn string) bool {
+	if strings.HasPrefix(sn, "*.") {
+		sn = sn[2:]
+	}
+	return sn != "" && !strings.Contains(sn, "*")
 }
 
-func (fci *FilterChainManager) addFilterChainsForTransportProtocols(dstEntry *destPrefixEntry, fc *v3listenerpb.FilterChain) error {
+func (fci *FilterChainManager) addFilterChainsForTransportProtocols(snEntry *serverNameEntry, fc *v3listenerpb.FilterChain) error {
 	tp := fc.GetFilterChainMatch().GetTransportProtocol()
-	switch {
-	case tp != "" && tp != "raw_buffer":
-		// Only allow filter chains with transport protocol set to empty string
-		// or "raw_buffer".
-		fci.logger.Warnf("Dropping filter chain %+v since it contains unsupported value for transport_protocols match field", fc)
-		return nil
-	case tp == "" && dstEntry.rawBufferSeen:
-		// If we have already seen filter chains with transport protocol set to
-		// "raw_buffer", we can drop filter chains with transport protocol set
-		// to empty string, since the former takes precedence.
+	if tp != "" && tp != transportProtocolRawBuffer && tp != transportProtocolTLS {
+		// Only allow filter chains with transport protocol set to empty
+		// string, "raw_buffer" or "tls".
 		fci.logger.Warnf("Dropping filter chain %+v since it contains unsupported value for transport_protocols match field", fc)
 		return nil
-	case tp != "" && !dstEntry.rawBufferSeen:
-		// This is the first "raw_buffer" that we are seeing. Set the bit and
-		// reset the source types array which might contain entries for filter
-		// chains with transport protocol set to empty string.
-		dstEntry.rawBufferSeen = true
-		dstEntry.srcTypeArr = sourceTypesArray{}
 	}
-	return fci.addFilterChainsForApplicationProtocols(dstEntry, fc)
+	if snEntry.transportProtocolMap[tp] == nil {
+		snEntry.transportProtocolMap[tp] = &transportProtocolEntry{applicationProtocolMap: make(map[string]*sourceTypesArray)}
+	}
+	return fci.addFilterChainsForApplicationProtocols(snEntry.transportProtocolMap[tp], fc)
 }
 
-func (fci *FilterChainManager) addFilterChainsForApplicationProtocols(dstEntry *destPrefixEntry, fc *v3listenerpb.FilterChain) error {
-	if len(fc.GetFilterChainMatch().GetApplicationProtocols()) != 0 {
-		fci.logger.Warnf("Dropping filter chain %+v since it contains unsupported application_protocols match field", fc)
-		return nil
+// addFilterChainsForApplicationProtocols adds application protocols to the
+// internal data structures and delegates control to
+// addFilterChainsForSourceType to continue building the internal data
+// structure.
+func (fci *FilterChainManager) addFilterChainsForApplicationProtocols(tpEntry *transportProtocolEntry, fc *v3listenerpb.FilterChain) error {
+	aps := fc.GetFilterChainMatch().GetApplicationProtocols()
+	// The application protocols are negotiated with ALPN, which is part of
+	// the TLS handshake.
+	if tp := fc.GetFilterChainMatch().GetTransportProtocol(); len(aps) != 0 && tp != transportProtocolTLS {
+		return fmt.Errorf("filter chain %+v specifies application_protocols with transport_protocol %q, want %q", fc, tp, transportProtocolTLS)
 	}
-	return fci.addFilterChainsForSourceType(dstEntry, fc)
+	for _, ap := range aps {
+		// The empty key is the one of the unspecified application protocol.
+		if ap == "" {
+			return fmt.Errorf("filter chain %+v contains an empty application protocol", fc)
+		}
+	}
+	if len(aps) == 0 {
+		aps = []string{""}
+	}
+	for _, ap := range aps {
+		if tpEntry.applicationProtocolMap[ap] == nil {
+			tpEntry.applicationProtocolMap[ap] = &sourceTypesArray{}
+		}
+		if err := fci.addFilterChainsForSourceType(tpEntry.applicationProtocolMap[ap], fc); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // addFilterChainsForSourceType adds source types to the internal data
 // structures and delegates control to addFilterChainsForSourcePrefixes to
 // continue building the internal data structure.
-func (fci *FilterChainManager) addFilterChainsForSourceType(dstEntry *destPrefixEntry, fc *v3listenerpb.FilterChain) error {
+func (fci *FilterChainManager) addFilterChainsForSourceType(srcTypeArr *sourceTypesArray, fc *v3listenerpb.FilterChain) error {
 	var srcType SourceType
 	switch st := fc.GetFilterChainMatch().GetSourceType(); st {
 	case v3listenerpb.FilterChainMatch_ANY:
@@ -422,25 +668,19 @@ func (fci *FilterChainManager) addFilterChainsForSourceType(dstEntry *destPrefix
 	}
 
 	st := int(srcType)
-	if dstEntry.srcTypeArr[st] == nil {
-		dstEntry.srcTypeArr[st] = &sourcePrefixes{srcPrefixMap: make(map[string]*sourcePrefixEntry)}
+	if srcTypeArr[st] == nil {
+		srcTypeArr[st] = &sourcePrefixes{srcPrefixMap: make(map[string]*sourcePrefixEntry)}
 	}
-	return fci.addFilterChainsForSourcePrefixes(dstEntry.srcTypeArr[st].srcPrefixMap, fc)
+	return fci.addFilterChainsForSourcePrefixes(srcTypeArr[st].srcPrefixMap, fc)
 }
 
 // addFilterChainsForSourcePrefixes adds source prefixes to the internal data
 // structures and delegates control to addFilterChainsForSourcePorts to continue
 // building the internal data structure.
 func (fci *FilterChainManager) addFilterChainsForSourcePrefixes(srcPrefixMap map[string]*sourcePrefixEntry, fc *v3listenerpb.FilterChain) error {
-	ranges := fc.GetFilterChainMatch().GetSourcePrefixRanges()
-	srcPrefixes := make([]*net.IPNet, 0, len(ranges))
-	for _, pr := range fc.GetFilterChainMatch().GetSourcePrefixRanges() {
-		cidr := fmt.Sprintf("%s/%d", pr.GetAddressPrefix(), pr.GetPrefixLen().GetValue())
-		_, ipnet, err := net.ParseCIDR(cidr)
-		if err != nil {
-			return fmt.Errorf("failed to parse source prefix range: %+v", pr)
-		}
-		srcPrefixes = append(srcPrefixes, ipnet)
+	srcPrefixes, err := parsePrefixRanges(fc.GetFilterChainMatch().GetSourcePrefixRanges())
+	if err != nil {
+		return fmt.Errorf("failed to parse source prefix range: %v", err)
 	}
 
 	if len(srcPrefixes) == 0 {
@@ -476,6 +716,10 @@ func (fci *FilterChainManager) addFilterChainsForSourcePorts(srcEntry *sourcePre
 	ports := fcProto.GetFilterChainMatch().GetSourcePorts()
 	srcPorts := make([]int, 0, len(ports))
 	for _, port := range ports {
+		// Zero is the key of the unspecified source port.
+		if port == 0 || port > 65535 {
+			return fmt.Errorf("filter chain %+v contains invalid source port %d", fcProto, port)
+		}
 		srcPorts = append(srcPorts, int(port))
 	}
 
@@ -505,10 +749,33 @@ func (fci *FilterChainManager) addFilterChainsForSourcePorts(srcEntry *sourcePre
 // proto and stores it in our internal representation. It also persists any
 // RouteNames which need to be queried dynamically via RDS.
 func (fci *FilterChainManager) filterChainFromProto(fc *v3listenerpb.FilterChain) (*FilterChain, error) {
-	filterChain, err := processNetworkFilters(fc.GetFilters())
+	filterChain, err := processNetworkFilters(fc.GetFilters(), fci.logger, fci.filterConfigs, fci.treatUnknownFiltersAsOptional)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("filter chain %q: %v", fc.GetName(), err)
+	}
+	filterChain.Match = FilterChainMatch{
+		DestinationPort:   fc.GetFilterChainMatch().GetDestinationPort().GetValue(),
+		TransportProtocol: fc.GetFilterChainMatch().GetTransportProtocol(),
+	}
+	for _, sn := range fc.GetFilterChainMatch().GetServerNames() {
+		filterChain.Match.ServerNames = append(filterChain.Match.ServerNames, strings.ToLower(sn))
+	}
+	if aps := fc.GetFilterChainMatch().GetApplicationProtocols(); len(aps) != 0 {
+		filterChain.Match.ApplicationProtocols = append([]string(nil), aps...)
+	}
+	switch fc.GetFilterChainMatch().GetSourceType() {
+	case v3listenerpb.FilterChainMatch_SAME_IP_OR_LOOPBACK:
+		filterChain.Match.SourceType = SourceTypeSameOrLoopback
+	case v3listenerpb.FilterChainMatch_EXTERNAL:
+		filterChain.Match.SourceType = SourceTypeExternal
+	}
+	if filterChain.Match.SourcePrefixRanges, err = parsePrefixRanges(fc.GetFilterChainMatch().GetSourcePrefixRanges()); err != nil {
+		return nil, fmt.Errorf("failed to parse source prefix range: %v", err)
 	}
+	if len(filterChain.Match.SourcePrefixRanges) == 0 {
+		filterChain.Match.SourcePrefixRanges = nil
+	}
+	filterChain.Match.SourcePorts = fc.GetFilterChainMatch().GetSourcePorts()
 	// These route names will be dynamically queried via RDS in the wrapped
 	// listener, which receives the LDS response, if specified for the filter
 	// chain.
@@ -548,6 +815,9 @@ func (fci *FilterChainManager) filterChainFromProto(fc *v3listenerpb.FilterChain
 	if downstreamCtx.GetCommonTlsContext() == nil {
 		return nil, errors.New("DownstreamTlsContext in LDS response does not contain a CommonTlsContext")
 	}
+	if err := validateCertProviderInstanceNames(downstreamCtx.GetCommonTlsContext()); err != nil {
+		return nil, err
+	}
 	sc, err := securityConfigFromCommonTLSContext(downstreamCtx.GetCommonTlsContext(), true)
 	if err != nil {
 		return nil, err
@@ -565,17 +835,63 @@ func (fci *FilterChainManager) filterChainFromProto(fc *v3listenerpb.FilterChain
 	return filterChain, nil
 }
 
+// validateCertProviderInstanceNames makes sure that every certificate provider
+// instance referenced from the CommonTlsContext carries a non-empty instance
+// name, since an empty name can never be resolved against the bootstrap
+// configuration.
+func validateCertProviderInstanceNames(common *v3tlspb.CommonTlsContext) error {
+	if pi := common.GetTlsCertificateProviderInstance(); pi != nil && pi.GetInstanceName() == "" {
+		return fmt.Errorf("tls_certificate_provider_instance contains an empty instance_name in CommonTlsContext message: %+v", common)
+	}
+	if pi := common.GetTlsCertificateCertificateProviderInstance(); pi != nil && pi.GetInstanceName() == "" {
+		return fmt.Errorf("tls_certificate_certificate_provider_instance contains an empty instance_name in CommonTlsContext message: %+v", common)
+	}
+	var validationCtx *v3tlspb.CertificateValidationContext
+	switch common.GetValidationContextType().(type) {
+	case *v3tlspb.CommonTlsContext_ValidationContext:
+		validationCtx = common.GetValidationContext()
+	case *v3tlspb.CommonTlsContext_CombinedValidationContext:
+		combined := common.GetCombinedValidationContext()
+		if pi := combined.GetValidationContextCertificateProviderInstance(); pi != nil && pi.GetInstanceName() == "" {
+			return fmt.Errorf("validation_context_certificate_provider_instance contains an empty instance_name in CommonTlsContext message: %+v", common)
+		}
+		validationCtx = combined.GetDefaultValidationContext()
+	case *v3tlspb.CommonTlsContext_ValidationContextCertificateProviderInstance:
+		if common.GetValidationContextCertificateProviderInstance().GetInstanceName() == "" {
+			return fmt.Errorf("validation_context_certificate_provider_instance contains an empty instance_name in CommonTlsContext message: %+v", common)
+		}
+	}
+	if pi := validationCtx.GetCaCertificateProviderInstance(); pi != nil && pi.GetInstanceName() == "" {
+		return fmt.Errorf("ca_certificate_provider_instance contains an empty instance_name in CommonTlsContext message: %+v", common)
+	}
+	return nil
+}
+
+// DefaultFilterChain returns the default filter chain of the listener, nil if
+// it has none.
+func (fci *FilterChainManager) DefaultFilterChain() *FilterChain {
+	return fci.def
+}
+
 // Validate takes a function to validate the FilterChains in this manager.
 func (fci *FilterChainManager) Validate(f func(fc *FilterChain) error) error {
-	for _, dst := range fci.dstPrefixMap {
-		for _, srcType := range dst.srcTypeArr {
-			if srcType == nil {
-				continue
-			}
-			for _, src := range srcType.srcPrefixMap {
-				for _, fc := range src.srcPortMap {
-					if err := f(fc); err != nil {
-						return err
+	for _, dstPort := range fci.dstPortMap {
+		for _, dst := range dstPort.dstPrefixMap {
+			for _, sn := range dst.serverNameMap {
+				for _, tp := range sn.transportProtocolMap {
+					for _, srcTypeArr := range tp.applicationProtocolMap {
+						for _, srcType := range srcTypeArr {
+							if srcType == nil {
+								continue
+							}
+							for _, src := range srcType.srcPrefixMap {
+								for _, fc := range src.srcPortMap {
+									if err := f(fc); err != nil {
+										return err
+									}
+								}
+							}
+						}
 					}
 				}
 			}
@@ -584,10 +900,12 @@ func (fci *FilterChainManager) Validate(f func(fc *FilterChain) error) error {
 	return f(fci.def)
 }
 
-func processNetworkFilters(filters []*v3listenerpb.Filter) (*FilterChain, error) {
+func processNetworkFilters(filters []*v3listenerpb.Filter, logger dubboLogger.Logger, cache *filterConfigCache, treatUnknownAsOptional bool) (*FilterChain, error) {
 	filterChain := &FilterChain{}
 	seenNames := make(map[string]bool, len(filters))
-	seenHCM := false
+	// The first HttpConnectionManager or tcp_proxy filter terminates the
+	// chain.
+	seenTerminal := false
 	for _, filter := range filters {
 		name := filter.GetName()
 		if name == "" {
@@ -608,12 +926,23 @@ func processNetworkFilters(filters []*v3listenerpb.Filter) (*FilterChain, error)
 			// TODO: Add support for `TypedStruct`.
 			tc := filter.GetTypedConfig()
 
-			// The only network filter that we currently support is the v3
-			// HttpConnectionManager. So, we can directly check the type_url
-			// and unmarshal the config.
+			// The only network filters that we currently support are the v3
+			// HttpConnectionManager and tcp_proxy. So, we can directly check
+			// the type_url and unmarshal the config.
 			// TODO: Implement a registry of supported network filters (like
-			// we have for HTTP filters), when we have to support network
-			// filters other than HttpConnectionManager.
+			// we have for HTTP filters), when we have to support more network
+			// filters.
+			if tc.GetTypeUrl() == version.V3TCPProxyURL {
+				tcpProxy, err := processTCPProxy(tc)
+				if err != nil {
+					return nil, fmt.Errorf("network filters {%+v} had invalid tcp_proxy filter {%+v}: %v", filters, filter, err)
+				}
+				if !seenTerminal {
+					filterChain.TCPProxy = tcpProxy
+					seenTerminal = true
+				}
+				continue
+			}
 			if tc.GetTypeUrl() != version.V3HTTPConnManagerURL {
 				return nil, fmt.Errorf("network filters {%+v} has unsupported network filter %q in filter {%+v}", filters, tc.GetTypeUrl(), filter)
 			}
@@ -624,29 +953,29 @@ func processNetworkFilters(filters []*v3listenerpb.Filter) (*FilterChain, error)
 			// "Any filters after HttpConnectionManager should be ignored during
 			// connection processing but still be considered for validity.
 			// HTTPConnectionManager must have valid http_filters." - A36
-			filters, err := processHTTPFilters(hcm.GetHttpFilters(), true)
+			filters, err := processHTTPFilters(hcm.GetHttpFilters(), true, false, cache, treatUnknownAsOptional, logger)
 			if err != nil {
 				return nil, fmt.Errorf("network filters {%+v} had invalid server side HTTP Filters {%+v}: %v", filters, hcm.GetHttpFilters(), err)
 			}
-			if !seenHCM {
+			if !seenTerminal {
 				// Validate for RBAC in only the HCM that will be used, since this isn't a logical validation failure,
 				// it's simply a validation to support RBAC HTTP Filter.
 				// "HttpConnectionManager.xff_num_trusted_hops must be unset or zero and
 				// HttpConnectionManager.original_ip_detection_extensions must be empty. If
 				// either field has an incorrect value, the Listener must be NACKed." - A41
 				if hcm.XffNumTrustedHops != 0 {
-					return nil, fmt.Errorf("xff_num_trusted_hops must be unset or zero %+v", hcm)
+					return nil, fmt.Errorf("network filter %q: xff_num_trusted_hops must be unset or zero, got %d", name, hcm.XffNumTrustedHops)
 				}
-				if len(hcm.OriginalIpDetectionExtensions) != 0 {
-					return nil, fmt.Errorf("original_ip_detection_extensions must be empty %+v", hcm)
+				if n := len(hcm.OriginalIpDetectionExtensions); n != 0 {
+					return nil, fmt.Errorf("network filter %q: original_ip_detection_extensions must be empty, got %d entries", name, n)
 				}
 
 				// TODO: Implement terminal filter logic, as per A36.
 				filterChain.HTTPFilters = filters
-				seenHCM = true
-				if !envconfig.XDSRBAC {
-					continue
-				}
+				seenTerminal = true
+				// The route configuration is extracted regardless of whether
+				// RBAC is enabled, so inbound routing rules are always
+				// available on the filter chain.
 				switch hcm.RouteSpecifier.(type) {
 				case *v3httppb.HttpConnectionManager_Rds:
 					if hcm.GetRds().GetConfigSource().GetAds() == nil {
@@ -664,7 +993,7 @@ func processNetworkFilters(filters []*v3listenerpb.Filter) (*FilterChain, error)
 					// server-side." - A36
 					// Can specify v3 here, as will never get to this function
 					// if v2.
-					routeU, err := generateRDSUpdateFromRouteConfiguration(hcm.GetRouteConfig(), nil, false)
+					routeU, err := generateRDSUpdateFromRouteConfiguration(hcm.GetRouteConfig(), logger, false)
 					if err != nil {
 						return nil, fmt.Errorf("failed to parse inline RDS resp: %v", err)
 					}
@@ -679,12 +1008,48 @@ func processNetworkFilters(filters []*v3listenerpb.Filter) (*FilterChain, error)
 			return nil, fmt.Errorf("network filters {%+v} has unsupported config_type %T in filter %s", filters, typ, filter.GetName())
 		}
 	}
-	if !seenHCM {
-		return nil, fmt.Errorf("network filters {%+v} missing HttpConnectionManager filter", filters)
+	if !seenTerminal {
+		return nil, fmt.Errorf("network filters {%+v} missing HttpConnectionManager or tcp_proxy filter", filters)
 	}
 	return filterChain, nil
 }
 
+// processTCPProxy parses the destination of a tcp_proxy network filter.
+func processTCPProxy(tc *anypb.Any) (*TCPProxyConfig, error) {
+	tp := &v3tcpproxypb.TcpProxy{}
+	if err := ptypes.UnmarshalAny(tc, tp); err != nil {
+		return nil, fmt.Errorf("failed unmarshaling: %v", err)
+	}
+	switch cs := tp.GetClusterSpecifier().(type) {
+	case *v3tcpproxypb.TcpProxy_Cluster:
+		if cs.Cluster == "" {
+			return nil, fmt.Errorf("empty cluster")
+		}
+		return &TCPProxyConfig{Cluster: cs.Cluster}, nil
+	case *v3tcpproxypb.TcpProxy_WeightedClusters:
+		wcs := make(map[string]WeightedCluster)
+		for _, c := range cs.WeightedClusters.GetClusters() {
+			if c.GetName() == "" {
+				return nil, fmt.Errorf("weighted cluster with an empty name")
+			}
+			if c.GetWeight() == 0 {
+				// Clusters with zero weight never get any traffic.
+				continue
+			}
+			if _, ok := wcs[c.GetName()]; ok {
+				return nil, fmt.Errorf("duplicate weighted cluster %q", c.GetName())
+			}
+			wcs[c.GetName()] = WeightedCluster{Weight: c.GetWeight()}
+		}
+		if len(wcs) == 0 {
+			return nil, fmt.Errorf("no weighted cluster with a non-zero weight")
+		}
+		return &TCPProxyConfig{WeightedClusters: wcs}, nil
+	default:
+		return nil, fmt.Errorf("unsupported cluster specifier %T", cs)
+	}
+}
+
 // FilterChainLookupParams wraps parameters to be passed to Lookup.
 type FilterChainLookupParams struct {
 	// IsUnspecified indicates whether the server is listening on a wildcard
@@ -694,10 +1059,25 @@ type FilterChainLookupParams struct {
 	IsUnspecifiedListener bool
 	// DestAddr is the local address of an incoming connection.
 	DestAddr net.IP
+	// DestPort is the local port of an incoming connection.
+	DestPort int
 	// SourceAddr is the remote address of an incoming connection.
 	SourceAddr net.IP
 	// SourcePort is the remote port of an incoming connection.
 	SourcePort int
+	// ServerName is the server name requested by an incoming connection, e.g.
+	// its TLS SNI. Empty if unknown, in which case only filter chains which
+	// don't specify server names match.
+	ServerName string
+	// TransportProtocol is the transport protocol of an incoming connection,
+	// "raw_buffer" or "tls". Empty if unknown, which is treated as
+	// "raw_buffer".
+	TransportProtocol string
+	// ApplicationProtocols are the application protocols offered with ALPN
+	// by an incoming TLS connection, in its order of preference. The first
+	// one specified by a filter chain is matched; if there is none, only
+	// filter chains which don't specify application protocols match.
+	ApplicationProtocols []string
 }
 
 // Lookup returns the most specific matching filter chain to be used for an
@@ -707,7 +1087,15 @@ type FilterChainLookupParams struct {
 // multiple matching filter chains were found, and in both cases, the incoming
 // connection must be dropped.
 func (fci *FilterChainManager) Lookup(params FilterChainLookupParams) (*FilterChain, error) {
-	dstPrefixes := filterByDestinationPrefixes(fci.dstPrefixes, params.IsUnspecifiedListener, params.DestAddr)
+	dstPortEntry := filterByDestinationPort(fci.dstPortMap, params.DestPort)
+	if dstPortEntry == nil {
+		if fci.def != nil {
+			return fci.def, nil
+		}
+		return nil, fmt.Errorf("no matching filter chain based on destination port match for %+v", params)
+	}
+
+	dstPrefixes := filterByDestinationPrefixes(dstPortEntry.dstPrefixes, params.IsUnspecifiedListener, params.DestAddr)
 	if len(dstPrefixes) == 0 {
 		if fci.def != nil {
 			return fci.def, nil
@@ -715,11 +1103,39 @@ func (fci *FilterChainManager) Lookup(params FilterChainLookupParams) (*FilterCh
 		return nil, fmt.Errorf("no matching filter chain based on destination prefix match for %+v", params)
 	}
 
+	serverNames := filterByServerName(dstPrefixes, strings.ToLower(params.ServerName))
+	if len(serverNames) == 0 {
+		if fci.def != nil {
+			return fci.def, nil
+		}
+		return nil, fmt.Errorf("no matching filter chain based on server name match for %+v", params)
+	}
+
+	tp := params.TransportProtocol
+	if tp == "" {
+		tp = transportProtocolRawBuffer
+	}
+	tps := filterByTransportProtocol(serverNames, tp)
+	if len(tps) == 0 {
+		if fci.def != nil {
+			return fci.def, nil
+		}
+		return nil, fmt.Errorf("no matching filter chain based on transport protocol match for %+v", params)
+	}
+
+	srcTypeArrs := filterByApplicationProtocol(tps, params.ApplicationProtocols)
+	if len(srcTypeArrs) == 0 {
+		if fci.def != nil {
+			return fci.def, nil
+		}
+		return nil, fmt.Errorf("no matching filter chain based on application protocol match for %+v", params)
+	}
+
 	srcType := SourceTypeExternal
 	if params.SourceAddr.Equal(params.DestAddr) || params.SourceAddr.IsLoopback() {
 		srcType = SourceTypeSameOrLoopback
 	}
-	srcPrefixes := filterBySourceType(dstPrefixes, srcType)
+	srcPrefixes := filterBySourceType(srcTypeArrs, srcType)
 	if len(srcPrefixes) == 0 {
 		if fci.def != nil {
 			return fci.def, nil
@@ -739,7 +1155,20 @@ func (fci *FilterChainManager) Lookup(params FilterChainLookupParams) (*FilterCh
 	return nil, fmt.Errorf("no matching filter chain after all match criteria for %+v", params)
 }
 
-// filterByDestinationPrefixes is the first stage of the filter chain
+// filterByDestinationPort is the first stage of the filter chain matching
+// algorithm. Filter chains which specify the exact destination port of the
+// incoming connection are preferred, and filter chains which do not specify a
+// destination port are only considered when there is no such exact match.
+func filterByDestinationPort(dstPortMap map[int]*destPortEntry, dstPort int) *destPortEntry {
+	if dstPort != 0 {
+		if entry := dstPortMap[dstPort]; entry != nil {
+			return entry
+		}
+	}
+	return dstPortMap[0]
+}
+
+// filterByDestinationPrefixes is the second stage of the filter chain
 // matching algorithm. It takes the complete set of configured filter chain
 // matchers and returns the most specific matchers based on the destination
 // prefix match criteria (the prefixes which match the most number of bits).
@@ -777,14 +1206,125 @@ func filterByDestinationPrefixes(dstPrefixes []*destPrefixEntry, isUnspecified b
 	return matchingDstPrefixes
 }
 
-// filterBySourceType is the second stage of the matching algorithm. It
+// filterByServerName is the third stage of the filter chain matching
+// algorithm. It returns the most specific server name matches of the
+// destination prefixes for the server name sn: an exact match, then the
+// wildcard with the longest suffix, and then an unspecified server name.
+func filterByServerName(dstPrefixes []*destPrefixEntry, sn string) []*serverNameEntry {
+	var matchingServerNames []*serverNameEntry
+	maxMatch := noServerNameMatch
+	for _, prefix := range dstPrefixes {
+		entry, match := prefix.matchServerName(sn)
+		if entry == nil || match < maxMatch {
+			continue
+		}
+		if match > maxMatch {
+			maxMatch = match
+			matchingServerNames = make([]*serverNameEntry, 0, 1)
+		}
+		matchingServerNames = append(matchingServerNames, entry)
+	}
+	return matchingServerNames
+}
+
+// matchServerName returns the entry of dpe best matching the server name sn,
+// and the size of the match.
+func (dpe *destPrefixEntry) matchServerName(sn string) (*serverNameEntry, int) {
+	if sn != "" {
+		if entry := dpe.serverNameMap[sn]; entry != nil {
+			return entry, exactServerNameMatch
+		}
+		// Try the wildcards from the longest suffix of sn to the shortest.
+		for i := strings.IndexByte(sn, '.'); i >= 0; {
+			if entry := dpe.serverNameMap["*"+sn[i:]]; entry != nil {
+				return entry, len(sn) - i
+			}
+			next := strings.IndexByte(sn[i+1:], '.')
+			if next < 0 {
+				break
+			}
+			i += next + 1
+		}
+	}
+	if entry := dpe.serverNameMap[""]; entry != nil {
+		return entry, unspecifiedServerNameMatch
+	}
+	return nil, noServerNameMatch
+}
+
+// filterByTransportProtocol is the fourth stage of the filter chain matching
+// algorithm. Filter chains which specify the transport protocol tp of the
+// incoming connection are preferred over those which don't specify one.
+func filterByTransportProtocol(serverNames []*serverNameEntry, tp string) []*transportProtocolEntry {
+	var (
+		tps       []*transportProtocolEntry
+		exactSeen bool
+	)
+	for _, sn := range serverNames {
+		if entry := sn.transportProtocolMap[tp]; entry != nil {
+			if !exactSeen {
+				exactSeen = true
+				tps = nil
+			}
+			tps = append(tps, entry)
+			continue
+		}
+		if entry := sn.transportProtocolMap[""]; entry != nil && !exactSeen {
+			tps = append(tps, entry)
+		}
+	}
+	return tps
+}
+
+// filterByApplicationProtocol is the fifth stage of the filter chain matching
+// algorithm. Filter chains which specify the preferred application protocol
+// of aps are preferred over those which don't specify one.
+func filterByApplicationProtocol(tps []*transportProtocolEntry, aps []string) []*sourceTypesArray {
+	ap := preferredApplicationProtocol(tps, aps)
+	var (
+		srcTypeArrs []*sourceTypesArray
+		exactSeen   bool
+	)
+	for _, tp := range tps {
+		if ap != "" {
+			if arr := tp.applicationProtocolMap[ap]; arr != nil {
+				if !exactSeen {
+					exactSeen = true
+					srcTypeArrs = nil
+				}
+				srcTypeArrs = append(srcTypeArrs, arr)
+				continue
+			}
+		}
+		if arr := tp.applicationProtocolMap[""]; arr != nil && !exactSeen {
+			srcTypeArrs = append(srcTypeArrs, arr)
+		}
+	}
+	return srcTypeArrs
+}
+
+// preferredApplicationProtocol returns the first of the application protocols
+// aps offered by the incoming connection which a filter chain of tps
+// specifies, or "" if there is none.
+func preferredApplicationProtocol(tps []*transportProtocolEntry, aps []string) string {
+	for _, ap := range aps {
+		for _, tp := range tps {
+			if tp.applicationProtocolMap[ap] != nil {
+				return ap
+			}
+		}
+	}
+	return ""
+}
+
+// filterBySourceType is the sixth stage of the matching algorithm. It
 // trims the filter chains based on the most specific source type match.
-func filterBySourceType(dstPrefixes []*destPrefixEntry, srcType SourceType) []*sourcePrefixes {
+func filterBySourceType(srcTypeArrs []*sourceTypesArray, srcType SourceType) []*sourcePrefixes {
 	var (
 		srcPrefixes      []*sourcePrefixes
 		bestSrcTypeMatch int
 	)
-	for _, prefix := range dstPrefixes {
+	for _, prefix := range srcTypeArrs {
 		var (
 			srcPrefix *sourcePrefixes
 			match     int
@@ -792,14 +1332,14 @@ func filterBySourceType(dstPrefixes []*destPrefixEntry, srcType SourceType) []*s
 		switch srcType {
 		case SourceTypeExternal:
 			match = int(SourceTypeExternal)
-			srcPrefix = prefix.srcTypeArr[match]
+			srcPrefix = prefix[match]
 		case SourceTypeSameOrLoopback:
 			match = int(SourceTypeSameOrLoopback)
-			srcPrefix = prefix.srcTypeArr[match]
+			srcPrefix = prefix[match]
 		}
 		if srcPrefix == nil {
 			match = int(SourceTypeAny)
-			srcPrefix = prefix.srcTypeArr[match]
+			srcPrefix = prefix[match]
 		}
 		if match < bestSrcTypeMatch {
 			continue
@@ -817,7 +1357,7 @@ func filterBySourceType(dstPrefixes []*destPrefixEntry, srcType SourceType) []*s
 	return srcPrefixes
 }
 
-// filterBySourcePrefixes is the third stage of the filter chain matching
+// filterBySourcePrefixes is the seventh stage of the filter chain matching
 // algorithm. It trims the filter chains based on the source prefix. At most one
 // filter chain with the most specific match progress to the next stage.
 func filterBySourcePrefixes(srcPrefixes []*sourcePrefixes, srcAddr net.IP) (*sourcePrefixEntry, error) {
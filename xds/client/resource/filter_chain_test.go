@@ -0,0 +1,282 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+import (
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	v3routerpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	v3httppb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+import (
+	_ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/router"
+)
+
+const benchmarkFilterChains = 100
+
+// benchmarkNetworkFilters returns an HttpConnectionManager network filter
+// with a router HTTP filter, as shared by all the filter chains of a listener.
+func benchmarkNetworkFilters(b testing.TB) []*v3listenerpb.Filter {
+	routerCfg, err := ptypes.MarshalAny(&v3routerpb.Router{SuppressEnvoyHeaders: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+	hcm, err := ptypes.MarshalAny(&v3httppb.HttpConnectionManager{
+		RouteSpecifier: &v3httppb.HttpConnectionManager_Rds{
+			Rds: &v3httppb.Rds{
+				ConfigSource: &v3corepb.ConfigSource{
+					ConfigSourceSpecifier: &v3corepb.ConfigSource_Ads{Ads: &v3corepb.AggregatedConfigSource{}},
+				},
+				RouteConfigName: "route",
+			},
+		},
+		HttpFilters: []*v3httppb.HttpFilter{{
+			Name:       "router",
+			ConfigType: &v3httppb.HttpFilter_TypedConfig{TypedConfig: routerCfg},
+		}},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return []*v3listenerpb.Filter{{
+		Name:       "hcm",
+		ConfigType: &v3listenerpb.Filter_TypedConfig{TypedConfig: hcm},
+	}}
+}
+
+func BenchmarkProcessNetworkFilters(b *testing.B) {
+	filters := benchmarkNetworkFilters(b)
+	for _, bm := range []struct {
+		name     string
+		newCache func() *filterConfigCache
+	}{
+		{name: "uncached", newCache: func() *filterConfigCache { return nil }},
+		{name: "cached", newCache: newFilterConfigCache},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				cache := bm.newCache()
+				for j := 0; j < benchmarkFilterChains; j++ {
+					if _, err := processNetworkFilters(filters, nil, cache, false); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkNewFilterChainManager(b *testing.B) {
+	filters := benchmarkNetworkFilters(b)
+	lis := &v3listenerpb.Listener{}
+	for i := 0; i < benchmarkFilterChains; i++ {
+		lis.FilterChains = append(lis.FilterChains, &v3listenerpb.FilterChain{
+			Name:             fmt.Sprintf("chain-%d", i),
+			FilterChainMatch: &v3listenerpb.FilterChainMatch{DestinationPort: wrapperspb.UInt32(uint32(i + 1))},
+			Filters:          filters,
+		})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewFilterChainManager(lis, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestNewFilterChainManagerDefaultFilterChain(t *testing.T) {
+	filters := benchmarkNetworkFilters(t)
+	tests := []struct {
+		name    string
+		dfc     *v3listenerpb.FilterChain
+		wantErr bool
+	}{
+		{
+			name: "no match",
+			dfc:  &v3listenerpb.FilterChain{Name: "default", Filters: filters},
+		},
+		{
+			name: "match",
+			dfc: &v3listenerpb.FilterChain{
+				Name:             "default",
+				FilterChainMatch: &v3listenerpb.FilterChainMatch{DestinationPort: wrapperspb.UInt32(8080)},
+				Filters:          filters,
+			},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fci, err := NewFilterChainManager(&v3listenerpb.Listener{DefaultFilterChain: test.dfc}, &capturingLogger{})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("NewFilterChainManager() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			def := fci.DefaultFilterChain()
+			if def == nil || def.RouteConfigName != "route" {
+				t.Fatalf("DefaultFilterChain() = %+v, want the default filter chain", def)
+			}
+			fc, err := fci.Lookup(FilterChainLookupParams{})
+			if err != nil || fc != def {
+				t.Errorf("Lookup() = (%p, %v), want the default filter chain", fc, err)
+			}
+		})
+	}
+}
+
+func TestNewFilterChainManagerDuplicateMatches(t *testing.T) {
+	filters := benchmarkNetworkFilters(t)
+	chain := func(name string, fcm *v3listenerpb.FilterChainMatch) *v3listenerpb.FilterChain {
+		return &v3listenerpb.FilterChain{Name: name, FilterChainMatch: fcm, Filters: filters}
+	}
+	cidr := func(prefix string, n uint32) *v3corepb.CidrRange {
+		return &v3corepb.CidrRange{AddressPrefix: prefix, PrefixLen: wrapperspb.UInt32(n)}
+	}
+	const identical = "filter chains 0 (\"a\") and 1 (\"b\") have identical match criteria"
+	tests := []struct {
+		name       string
+		chains     []*v3listenerpb.FilterChain
+		wantErr    bool
+		wantErrMsg string
+	}{
+		{
+			name: "exact duplicate",
+			chains: []*v3listenerpb.FilterChain{
+				chain("a", &v3listenerpb.FilterChainMatch{
+					ServerNames:       []string{"foo.example.com", "bar.example.com"},
+					TransportProtocol: "tls",
+				}),
+				chain("b", &v3listenerpb.FilterChainMatch{
+					ServerNames:       []string{"BAR.example.com", "foo.example.com"},
+					TransportProtocol: "tls",
+				}),
+			},
+			wantErr:    true,
+			wantErrMsg: identical,
+		},
+		{
+			name: "exact duplicate with equivalent prefix ranges",
+			chains: []*v3listenerpb.FilterChain{
+				chain("a", &v3listenerpb.FilterChainMatch{PrefixRanges: []*v3corepb.CidrRange{cidr("10.1.2.3", 16)}}),
+				chain("b", &v3listenerpb.FilterChainMatch{PrefixRanges: []*v3corepb.CidrRange{cidr("10.1.0.0", 16)}}),
+			},
+			wantErr:    true,
+			wantErrMsg: identical,
+		},
+		{
+			name: "partial overlap",
+			chains: []*v3listenerpb.FilterChain{
+				chain("a", &v3listenerpb.FilterChainMatch{SourcePorts: []uint32{1, 2}}),
+				chain("b", &v3listenerpb.FilterChainMatch{SourcePorts: []uint32{2, 3}}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "overlapping prefix ranges",
+			chains: []*v3listenerpb.FilterChain{
+				chain("a", &v3listenerpb.FilterChainMatch{PrefixRanges: []*v3corepb.CidrRange{cidr("10.0.0.0", 8)}}),
+				chain("b", &v3listenerpb.FilterChainMatch{PrefixRanges: []*v3corepb.CidrRange{cidr("10.1.0.0", 16)}}),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewFilterChainManager(&v3listenerpb.Listener{FilterChains: test.chains}, &capturingLogger{})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("NewFilterChainManager() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if err == nil {
+				return
+			}
+			if got, want := strings.Contains(err.Error(), "identical match criteria"), test.wantErrMsg != ""; got != want {
+				t.Fatalf("NewFilterChainManager() returned err %v, reporting identical match criteria: %v, want %v", err, got, want)
+			}
+			if test.wantErrMsg != "" && err.Error() != test.wantErrMsg {
+				t.Errorf("NewFilterChainManager() returned err %q, want %q", err, test.wantErrMsg)
+			}
+		})
+	}
+}
+
+func TestFilterChainManagerApplicationProtocols(t *testing.T) {
+	filters := benchmarkNetworkFilters(t)
+	chain := func(name, tp string, aps ...string) *v3listenerpb.FilterChain {
+		return &v3listenerpb.FilterChain{
+			Name:             name,
+			FilterChainMatch: &v3listenerpb.FilterChainMatch{TransportProtocol: tp, ApplicationProtocols: aps},
+			Filters:          filters,
+		}
+	}
+
+	if _, err := NewFilterChainManager(&v3listenerpb.Listener{
+		FilterChains: []*v3listenerpb.FilterChain{chain("h2", "raw_buffer", "h2")},
+	}, &capturingLogger{}); err == nil {
+		t.Error("NewFilterChainManager() succeeded for application_protocols without the tls transport protocol, want an error")
+	}
+
+	fci, err := NewFilterChainManager(&v3listenerpb.Listener{
+		FilterChains: []*v3listenerpb.FilterChain{
+			chain("h2", "tls", "h2"),
+			chain("http1", "tls", "http/1.1"),
+			chain("tls", "tls"),
+		},
+	}, &capturingLogger{})
+	if err != nil {
+		t.Fatalf("NewFilterChainManager() failed: %v", err)
+	}
+	tests := []struct {
+		alpn     []string
+		wantALPN []string
+	}{
+		{alpn: []string{"h2"}, wantALPN: []string{"h2"}},
+		{alpn: []string{"http/1.1"}, wantALPN: []string{"http/1.1"}},
+		{alpn: []string{"h3", "http/1.1", "h2"}, wantALPN: []string{"http/1.1"}},
+		{alpn: []string{"h3"}},
+		{},
+	}
+	for _, test := range tests {
+		fc, err := fci.Lookup(FilterChainLookupParams{
+			SourceAddr:           net.IPv4(192, 168, 0, 1),
+			TransportProtocol:    "tls",
+			ApplicationProtocols: test.alpn,
+		})
+		if err != nil {
+			t.Fatalf("Lookup(%q) failed: %v", test.alpn, err)
+		}
+		if !reflect.DeepEqual(fc.Match.ApplicationProtocols, test.wantALPN) {
+			t.Errorf("Lookup(%q) returned a filter chain matching application protocols %q, want %q", test.alpn, fc.Match.ApplicationProtocols, test.wantALPN)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+//go:build gofuzz
+// +build gofuzz
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+import (
+	"dubbo.apache.org/dubbo-go/v3/xds/client/resource/version"
+)
+
+// FuzzUnmarshalListener is a go-fuzz entry point for the LDS unmarshal path,
+// fed with serialized Listeners. It returns 1 for listeners which are
+// accepted, so that the fuzzer favors inputs reaching deeper validation:
+//
+//	go-fuzz-build -func FuzzUnmarshalListener dubbo.apache.org/dubbo-go/v3/xds/client/resource
+func FuzzUnmarshalListener(data []byte) int {
+	update, _, _ := UnmarshalListener(&UnmarshalOptions{
+		Resources: []*anypb.Any{{TypeUrl: version.V3ListenerURL, Value: data}},
+		Logger:    discardLogger{},
+	})
+	for _, u := range update {
+		if u.Err != nil {
+			return 0
+		}
+	}
+	if len(update) == 0 {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,280 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"errors"
+	"fmt"
+)
+
+import (
+	v3accesslogpb "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	v3httppb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+
+	"google.golang.org/grpc/codes"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+import (
+	dubboLogger "dubbo.apache.org/dubbo-go/v3/common/logger"
+)
+
+// LocalReplyConfig is the local_reply_config of an HTTP connection manager,
+// which customizes the responses generated locally, e.g. on errors.
+type LocalReplyConfig struct {
+	// Mappers are the response mappers, in order. The first whose Filter
+	// matches a local reply applies to it.
+	Mappers []LocalReplyMapper
+	// BodyFormat is the format of the bodies of local replies, nil for the
+	// default.
+	BodyFormat *BodyFormat
+}
+
+// LocalReplyMapper rewrites the local replies matching Filter. Its
+// headers_to_add are not supported and ignored.
+type LocalReplyMapper struct {
+	// Filter is the predicate on the local replies to rewrite. It is nil if
+	// the predicate uses a filter type which can't be evaluated; the mapper
+	// then never matches, and may not remap the status code.
+	Filter *LocalReplyFilter
+	// StatusCode is the HTTP status code of the rewritten reply, zero to keep
+	// the original one.
+	StatusCode uint32
+	// Body is the body of the rewritten reply, nil to keep the original one.
+	Body *string
+	// BodyFormatOverride is the format of the body of the rewritten reply,
+	// nil to use LocalReplyConfig.BodyFormat.
+	BodyFormatOverride *BodyFormat
+}
+
+// BodyFormat is the format of the body of a local reply, from a
+// SubstitutionFormatString. At most one of Text and JSON is set.
+type BodyFormat struct {
+	// Supported reports whether the format is supported. Formats using
+	// formatter extensions, or a text format not given inline, aren't; the
+	// default body is then used.
+	Supported   bool
+	Text        string
+	JSON        *structpb.Struct
+	ContentType string
+	// OmitEmptyValues is set if empty values are omitted from the body.
+	OmitEmptyValues bool
+}
+
+// ComparisonOp is the operator of a StatusCodeFilter.
+type ComparisonOp int
+
+const (
+	// ComparisonOpEQ matches a status code equal to the value.
+	ComparisonOpEQ ComparisonOp = iota
+	// ComparisonOpGE matches a status code greater than or equal to the
+	// value.
+	ComparisonOpGE
+	// ComparisonOpLE matches a status code less than or equal to the value.
+	ComparisonOpLE
+)
+
+// LocalReplyFilter is the predicate of a LocalReplyMapper. Exactly one field
+// is set.
+type LocalReplyFilter struct {
+	// StatusCode matches on the HTTP status code of the reply.
+	StatusCode *StatusCodeFilter
+	// GRPCStatus matches on the gRPC status of the reply.
+	GRPCStatus *GRPCStatusFilter
+	// Header matches on a request header.
+	Header *HeaderMatcher
+	// And matches if all of its filters match.
+	And []*LocalReplyFilter
+	// Or matches if any of its filters matches.
+	Or []*LocalReplyFilter
+}
+
+// StatusCodeFilter matches the HTTP status codes which compare by Op to
+// Value. Runtime overrides of the value are not supported.
+type StatusCodeFilter struct {
+	Op    ComparisonOp
+	Value uint32
+}
+
+// GRPCStatusFilter matches the gRPC statuses in Statuses, or those not in
+// them if Exclude is set.
+type GRPCStatusFilter struct {
+	Statuses map[codes.Code]bool
+	Exclude  bool
+}
+
+// errUnsupportedPredicate is returned, wrapped, by localReplyFilterFromProto
+// for a filter type which can't be evaluated.
+var errUnsupportedPredicate = errors.New("unsupported filter type")
+
+// processLocalReplyConfig converts the local_reply_config of an
+// HttpConnectionManager, see ListenerUpdate.LocalReplyConfig. A mapper whose
+// filter can't be evaluated is kept without a filter, unless it remaps the
+// status code, which NACKs the resource.
+func processLocalReplyConfig(lrc *v3httppb.LocalReplyConfig, logger dubboLogger.Logger) (*LocalReplyConfig, error) {
+	if lrc == nil {
+		return nil, nil
+	}
+	ret := &LocalReplyConfig{}
+	var err error
+	if ret.BodyFormat, err = bodyFormatFromProto(lrc.GetBodyFormat(), logger); err != nil {
+		return nil, nackErrorf(ReasonInvalidHTTPConnManager, "local_reply_config: %v", err)
+	}
+	for i, m := range lrc.GetMappers() {
+		if m.GetFilter() == nil {
+			return nil, nackErrorf(ReasonInvalidHTTPConnManager, "local_reply_config: mapper %d has no filter", i)
+		}
+		mapper := LocalReplyMapper{StatusCode: m.GetStatusCode().GetValue()}
+		if m.GetStatusCode() != nil && (mapper.StatusCode < 200 || mapper.StatusCode > 599) {
+			return nil, nackErrorf(ReasonInvalidHTTPConnManager, "local_reply_config: mapper %d has status_code %d outside [200, 599]", i, mapper.StatusCode)
+		}
+		f, err := localReplyFilterFromProto(m.GetFilter())
+		switch {
+		case errors.Is(err, errUnsupportedPredicate) && m.GetStatusCode() == nil:
+			logger.Warnf("Local reply mapper %d will never match: %v", i, err)
+		case err != nil:
+			return nil, nackErrorf(ReasonUnsupportedField, "local_reply_config: mapper %d: %v", i, err)
+		default:
+			mapper.Filter = f
+		}
+		if b := m.GetBody(); b != nil {
+			body, err := inlineDataSource(b)
+			if err != nil {
+				return nil, nackErrorf(ReasonUnsupportedField, "local_reply_config: mapper %d body: %v", i, err)
+			}
+			mapper.Body = &body
+		}
+		if mapper.BodyFormatOverride, err = bodyFormatFromProto(m.GetBodyFormatOverride(), logger); err != nil {
+			return nil, nackErrorf(ReasonInvalidHTTPConnManager, "local_reply_config: mapper %d: %v", i, err)
+		}
+		ret.Mappers = append(ret.Mappers, mapper)
+	}
+	return ret, nil
+}
+
+// localReplyFilterFromProto converts the predicate of a response mapper.
+func localReplyFilterFromProto(f *v3accesslogpb.AccessLogFilter) (*LocalReplyFilter, error) {
+	switch fs := f.GetFilterSpecifier().(type) {
+	case *v3accesslogpb.AccessLogFilter_StatusCodeFilter:
+		c := fs.StatusCodeFilter.GetComparison()
+		scf := &StatusCodeFilter{Value: c.GetValue().GetDefaultValue()}
+		switch op := c.GetOp(); op {
+		case v3accesslogpb.ComparisonFilter_EQ:
+			scf.Op = ComparisonOpEQ
+		case v3accesslogpb.ComparisonFilter_GE:
+			scf.Op = ComparisonOpGE
+		case v3accesslogpb.ComparisonFilter_LE:
+			scf.Op = ComparisonOpLE
+		default:
+			return nil, fmt.Errorf("%w: status_code_filter with op %v", errUnsupportedPredicate, op)
+		}
+		return &LocalReplyFilter{StatusCode: scf}, nil
+	case *v3accesslogpb.AccessLogFilter_GrpcStatusFilter:
+		gsf := &GRPCStatusFilter{Statuses: make(map[codes.Code]bool), Exclude: fs.GrpcStatusFilter.GetExclude()}
+		for _, s := range fs.GrpcStatusFilter.GetStatuses() {
+			// The statuses have the values of the gRPC codes.
+			gsf.Statuses[codes.Code(s)] = true
+		}
+		return &LocalReplyFilter{GRPCStatus: gsf}, nil
+	case *v3accesslogpb.AccessLogFilter_HeaderFilter:
+		// A missing header matcher is rejected by the conversion.
+		hms, err := headerMatchersProtoToSlice([]*v3routepb.HeaderMatcher{fs.HeaderFilter.GetHeader()})
+		if err != nil {
+			return nil, fmt.Errorf("header_filter: %v", err)
+		}
+		return &LocalReplyFilter{Header: hms[0]}, nil
+	case *v3accesslogpb.AccessLogFilter_AndFilter:
+		and, err := localReplyFiltersFromProto(fs.AndFilter.GetFilters())
+		if err != nil {
+			return nil, err
+		}
+		return &LocalReplyFilter{And: and}, nil
+	case *v3accesslogpb.AccessLogFilter_OrFilter:
+		or, err := localReplyFiltersFromProto(fs.OrFilter.GetFilters())
+		if err != nil {
+			return nil, err
+		}
+		return &LocalReplyFilter{Or: or}, nil
+	default:
+		return nil, fmt.Errorf("%w %T", errUnsupportedPredicate, fs)
+	}
+}
+
+func localReplyFiltersFromProto(fs []*v3accesslogpb.AccessLogFilter) ([]*LocalReplyFilter, error) {
+	if len(fs) < 2 {
+		return nil, fmt.Errorf("logical filter with %d filters, want at least 2", len(fs))
+	}
+	ret := make([]*LocalReplyFilter, 0, len(fs))
+	for _, f := range fs {
+		lrf, err := localReplyFilterFromProto(f)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, lrf)
+	}
+	return ret, nil
+}
+
+// bodyFormatFromProto converts a body format, nil if sfs is unset.
+// Unsupported formats are recorded as such rather than rejected.
+func bodyFormatFromProto(sfs *v3corepb.SubstitutionFormatString, logger dubboLogger.Logger) (*BodyFormat, error) {
+	if sfs == nil {
+		return nil, nil
+	}
+	ret := &BodyFormat{ContentType: sfs.GetContentType(), OmitEmptyValues: sfs.GetOmitEmptyValues()}
+	if len(sfs.GetFormatters()) != 0 {
+		logger.Warnf("Local reply body format with unsupported formatters, the default body will be used")
+		return ret, nil
+	}
+	switch f := sfs.GetFormat().(type) {
+	case *v3corepb.SubstitutionFormatString_TextFormat:
+		ret.Text = f.TextFormat
+	case *v3corepb.SubstitutionFormatString_JsonFormat:
+		ret.JSON = f.JsonFormat
+	case *v3corepb.SubstitutionFormatString_TextFormatSource:
+		text, err := inlineDataSource(f.TextFormatSource)
+		if err != nil {
+			logger.Warnf("Local reply body format with unsupported text_format_source, the default body will be used: %v", err)
+			return ret, nil
+		}
+		ret.Text = text
+	case nil:
+		return nil, fmt.Errorf("body format without a format")
+	default:
+		logger.Warnf("Local reply body format of unsupported type %T, the default body will be used", f)
+		return ret, nil
+	}
+	ret.Supported = true
+	return ret, nil
+}
+
+// inlineDataSource returns the contents of ds, which must be inline: the
+// files of the workload aren't read.
+func inlineDataSource(ds *v3corepb.DataSource) (string, error) {
+	switch s := ds.GetSpecifier().(type) {
+	case *v3corepb.DataSource_InlineString:
+		return s.InlineString, nil
+	case *v3corepb.DataSource_InlineBytes:
+		return string(s.InlineBytes), nil
+	default:
+		return "", fmt.Errorf("data source %T is not inline", s)
+	}
+}
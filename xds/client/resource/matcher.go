@@ -25,6 +25,8 @@ package resource
 
 import (
 	"fmt"
+	"net/url"
+	"sort"
 	"strings"
 )
 
@@ -66,6 +68,8 @@ func RouteToMatcher(r *Route) (*CompositeMatcher, error) {
 			matcherT = matcher.NewHeaderPrefixMatcher(h.Name, *h.PrefixMatch, invert)
 		case h.SuffixMatch != nil && *h.SuffixMatch != "":
 			matcherT = matcher.NewHeaderSuffixMatcher(h.Name, *h.SuffixMatch, invert)
+		case h.ContainsMatch != nil && *h.ContainsMatch != "":
+			matcherT = matcher.NewHeaderContainsMatcher(h.Name, *h.ContainsMatch, invert)
 		case h.RangeMatch != nil:
 			matcherT = matcher.NewHeaderRangeMatcher(h.Name, h.RangeMatch.Start, h.RangeMatch.End, invert)
 		case h.PresentMatch != nil:
@@ -76,11 +80,16 @@ func RouteToMatcher(r *Route) (*CompositeMatcher, error) {
 		headerMatchers = append(headerMatchers, matcherT)
 	}
 
+	queryParamMatchers := make([]queryParamMatcher, 0, len(r.QueryParams))
+	for _, q := range r.QueryParams {
+		queryParamMatchers = append(queryParamMatchers, queryParamMatcher{name: q.Name, sm: q.StringMatch, present: q.PresentMatch})
+	}
+
 	var fractionMatcher *fractionMatcher
 	if r.Fraction != nil {
 		fractionMatcher = newFractionMatcher(*r.Fraction)
 	}
-	return newCompositeMatcher(pm, headerMatchers, fractionMatcher), nil
+	return newCompositeMatcher(pm, headerMatchers, queryParamMatchers, fractionMatcher), nil
 }
 
 // CompositeMatcher is a matcher that holds onto many matchers and aggregates
@@ -88,19 +97,42 @@ func RouteToMatcher(r *Route) (*CompositeMatcher, error) {
 type CompositeMatcher struct {
 	pm  pathMatcher
 	hms []matcher.HeaderMatcher
+	qms []queryParamMatcher
 	fm  *fractionMatcher
 }
 
-func newCompositeMatcher(pm pathMatcher, hms []matcher.HeaderMatcher, fm *fractionMatcher) *CompositeMatcher {
-	return &CompositeMatcher{pm: pm, hms: hms, fm: fm}
+func newCompositeMatcher(pm pathMatcher, hms []matcher.HeaderMatcher, qms []queryParamMatcher, fm *fractionMatcher) *CompositeMatcher {
+	return &CompositeMatcher{pm: pm, hms: hms, qms: qms, fm: fm}
 }
 
 // Match returns true if all matchers return true.
+//
+// Query parameters are taken from the part of info.Method after a '?'. gRPC
+// method names never have one, so for gRPC RPCs a query parameter matcher
+// only matches if it requires the parameter to be absent, and routes
+// requiring a query parameter never match.
 func (a *CompositeMatcher) Match(info iresolver.RPCInfo) bool {
-	if a.pm != nil && !a.pm.match(info.Method) {
+	// The query string, if any, is not part of the path being matched.
+	path, rawQuery := info.Method, ""
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path, rawQuery = path[:i], path[i+1:]
+	}
+	if a.pm != nil && !a.pm.match(path) {
 		return false
 	}
 
+	if len(a.qms) != 0 {
+		query, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return false
+		}
+		for _, m := range a.qms {
+			if !m.match(query) {
+				return false
+			}
+		}
+	}
+
 	// Call headerMatchers even if md is nil, because routes may match
 	// non-presence of some headers.
 	var md metadata.MD
@@ -137,12 +169,43 @@ func (a *CompositeMatcher) String() string {
 	for _, m := range a.hms {
 		ret += m.String()
 	}
+	for _, m := range a.qms {
+		ret += m.String()
+	}
 	if a.fm != nil {
 		ret += a.fm.String()
 	}
 	return ret
 }
 
+type queryParamMatcher struct {
+	name    string
+	sm      *matcher.StringMatcher
+	present *bool
+}
+
+// match returns true if the query carries the parameter and, for a string
+// matcher, one of its values matches.
+func (qm queryParamMatcher) match(query url.Values) bool {
+	values, ok := query[qm.name]
+	if qm.present != nil {
+		return ok == *qm.present
+	}
+	for _, v := range values {
+		if qm.sm.Match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (qm queryParamMatcher) String() string {
+	if qm.present != nil {
+		return fmt.Sprintf("queryParamPresent:%v:%v", qm.name, *qm.present)
+	}
+	return fmt.Sprintf("queryParam:%v", qm.name)
+}
+
 type fractionMatcher struct {
 	fraction int64 // real fraction is fraction/1,000,000.
 }
@@ -257,6 +320,52 @@ func FindBestMatchingVirtualHost(host string, vHosts []*VirtualHost) *VirtualHos
 	return matchVh
 }
 
+// vhDomain is one domain of a virtual host, with its match type.
+type vhDomain struct {
+	domain string
+	typ    domainMatchType
+	vh     *VirtualHost
+}
+
+// domainMatchOrder returns the domains of vHosts in the order
+// FindBestMatchingVirtualHost prefers them: by match type, then by length, and
+// then by position. The first domain in the order matching a host is its best
+// match. It returns nil if a domain is invalid.
+func domainMatchOrder(vHosts []*VirtualHost) []vhDomain {
+	var order []vhDomain
+	for _, vh := range vHosts {
+		for _, domain := range vh.Domains {
+			typ := matchTypeForDomain(domain)
+			if typ == domainMatchTypeInvalid {
+				return nil
+			}
+			order = append(order, vhDomain{domain: domain, typ: typ, vh: vh})
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		if order[i].typ != order[j].typ {
+			return order[i].typ.betterThan(order[j].typ)
+		}
+		return len(order[i].domain) > len(order[j].domain)
+	})
+	return order
+}
+
+// FindBestMatchingVirtualHost returns the virtual host of u whose domains field
+// best matches host. Unlike the function of the same name, it walks the domain
+// order computed when the update was parsed instead of comparing all domains.
+func (u RouteConfigUpdate) FindBestMatchingVirtualHost(host string) *VirtualHost {
+	if u.domainOrder == nil {
+		return FindBestMatchingVirtualHost(host, u.VirtualHosts)
+	}
+	for _, d := range u.domainOrder {
+		if _, matched := match(d.domain, host); matched {
+			return d.vh
+		}
+	}
+	return nil
+}
+
 // FindBestMatchingVirtualHostServer returns the virtual host whose domains field best
 // matches authority.
 func FindBestMatchingVirtualHostServer(authority string, vHosts []VirtualHostWithInterceptors) *VirtualHostWithInterceptors {
@@ -0,0 +1,101 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"testing"
+)
+
+import (
+	v3matcherpb "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+)
+
+import (
+	"dubbo.apache.org/dubbo-go/v3/xds/utils/matcher"
+	iresolver "dubbo.apache.org/dubbo-go/v3/xds/utils/resolver"
+)
+
+func TestCompositeMatcherQueryParams(t *testing.T) {
+	present, absent := true, false
+	tests := []struct {
+		name   string
+		qm     QueryParamMatcher
+		method string
+		want   bool
+	}{
+		{
+			name:   "present match on a gRPC method",
+			qm:     QueryParamMatcher{Name: "variant", PresentMatch: &present},
+			method: "/svc/Method",
+			want:   false,
+		},
+		{
+			name:   "absent match on a gRPC method",
+			qm:     QueryParamMatcher{Name: "variant", PresentMatch: &absent},
+			method: "/svc/Method",
+			want:   true,
+		},
+		{
+			name:   "string match on a gRPC method",
+			qm:     QueryParamMatcher{Name: "variant", StringMatch: newStringMatcher(t, "b")},
+			method: "/svc/Method",
+			want:   false,
+		},
+		{
+			name:   "present match on a method with a query",
+			qm:     QueryParamMatcher{Name: "variant", PresentMatch: &present},
+			method: "/svc/Method?variant=b",
+			want:   true,
+		},
+		{
+			name:   "string match on a method with a query",
+			qm:     QueryParamMatcher{Name: "variant", StringMatch: newStringMatcher(t, "b")},
+			method: "/svc/Method?variant=a&variant=b",
+			want:   true,
+		},
+		{
+			name:   "string mismatch on a method with a query",
+			qm:     QueryParamMatcher{Name: "variant", StringMatch: newStringMatcher(t, "b")},
+			method: "/svc/Method?variant=a",
+			want:   false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prefix := "/svc/"
+			m, err := RouteToMatcher(&Route{Prefix: &prefix, QueryParams: []QueryParamMatcher{test.qm}})
+			if err != nil {
+				t.Fatalf("RouteToMatcher() failed: %v", err)
+			}
+			if got := m.Match(iresolver.RPCInfo{Method: test.method}); got != test.want {
+				t.Errorf("Match(%q) = %v, want %v", test.method, got, test.want)
+			}
+		})
+	}
+}
+
+func newStringMatcher(t *testing.T, exact string) *matcher.StringMatcher {
+	t.Helper()
+	sm, err := matcher.StringMatcherFromProto(&v3matcherpb.StringMatcher{
+		MatchPattern: &v3matcherpb.StringMatcher_Exact{Exact: exact},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sm
+}
@@ -0,0 +1,115 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"fmt"
+)
+
+import (
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// ResourceUnmarshalFunc unmarshals a resource of a type URL registered with
+// RegisterResourceType. It returns the name of the resource and its update,
+// which must be of the update type of the registered resource type, e.g. a
+// ListenerUpdate for ListenerResource. The name is to be returned with the
+// error if it is known.
+type ResourceUnmarshalFunc func(r *anypb.Any, opts *UnmarshalOptions) (string, interface{}, error)
+
+type registeredResourceType struct {
+	rType     ResourceType
+	unmarshal ResourceUnmarshalFunc
+}
+
+var (
+	// registeredTypes is a map from type URL to registered resource type.
+	registeredTypes = make(map[string]registeredResourceType)
+)
+
+// RegisterResourceType registers typeURL as an additional type URL of the
+// resource type rType, e.g. a vendor specific Listener. Responses with
+// typeURL are handled as responses for rType, with f unmarshaling their
+// resources.
+//
+// The type URLs of the built-in resources can't be registered, and rType must
+// be one of ListenerResource, RouteConfigResource, ClusterResource and
+// EndpointsResource; RegisterResourceType panics otherwise.
+//
+// NOTE: this function must only be called during initialization time (i.e. in
+// an init() function), and is not thread-safe. If multiple functions are
+// registered with the same type URL, the one registered last will take effect.
+func RegisterResourceType(typeURL string, rType ResourceType, f ResourceUnmarshalFunc) {
+	switch rType {
+	case ListenerResource, RouteConfigResource, ClusterResource, EndpointsResource:
+	default:
+		panic(fmt.Sprintf("xds: cannot register type URL %q for resource type %v", typeURL, rType))
+	}
+	if IsListenerResource(typeURL) || IsHTTPConnManagerResource(typeURL) || IsRouteConfigResource(typeURL) ||
+		IsClusterResource(typeURL) || IsEndpointsResource(typeURL) {
+		panic(fmt.Sprintf("xds: cannot register built-in type URL %q", typeURL))
+	}
+	registeredTypes[typeURL] = registeredResourceType{rType: rType, unmarshal: f}
+}
+
+// UnregisterResourceTypeForTesting unregisters typeURL for testing purposes.
+func UnregisterResourceTypeForTesting(typeURL string) {
+	delete(registeredTypes, typeURL)
+}
+
+// RegisteredResourceType returns the resource type typeURL is registered for
+// with RegisterResourceType, if any.
+func RegisteredResourceType(typeURL string) (ResourceType, bool) {
+	rt, ok := registeredTypes[typeURL]
+	return rt.rType, ok
+}
+
+// withRegisteredTypes returns an unmarshal function for resources of type
+// rType which unmarshals the resources of registered type URLs with their
+// registered functions, and the others with unmarshal.
+func withRegisteredTypes(rType ResourceType, opts *UnmarshalOptions, unmarshal func(*anypb.Any) (string, interface{}, error)) func(*anypb.Any) (string, interface{}, error) {
+	return func(r *anypb.Any) (string, interface{}, error) {
+		rt, ok := registeredTypes[r.GetTypeUrl()]
+		if !ok {
+			return unmarshal(r)
+		}
+		if rt.rType != rType {
+			err := fmt.Errorf("unexpected resource type %q, want a %v", r.GetTypeUrl(), rType)
+			return "", nil, annotateNACKError(&NACKError{Reason: ReasonUnexpectedResourceType, Err: err}, rType, "")
+		}
+		name, update, err := rt.unmarshal(r, opts)
+		if err != nil {
+			return name, nil, annotateNACKError(err, rType, name)
+		}
+		var typeOK bool
+		switch rType {
+		case ListenerResource:
+			_, typeOK = update.(ListenerUpdate)
+		case RouteConfigResource:
+			_, typeOK = update.(RouteConfigUpdate)
+		case ClusterResource:
+			_, typeOK = update.(ClusterUpdate)
+		case EndpointsResource:
+			_, typeOK = update.(EndpointsUpdate)
+		}
+		if !typeOK {
+			return name, nil, annotateNACKError(fmt.Errorf("unmarshal function of type URL %q returned a %T, not the update of a %v", r.GetTypeUrl(), update, rType), rType, name)
+		}
+		return name, update, nil
+	}
+}
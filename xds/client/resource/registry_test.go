@@ -0,0 +1,79 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"testing"
+)
+
+import (
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestRegisterResourceType(t *testing.T) {
+	const (
+		goodURL = "type.googleapis.com/test.VendorListener"
+		badURL  = "type.googleapis.com/test.BadVendorListener"
+	)
+	RegisterResourceType(goodURL, ListenerResource, func(r *anypb.Any, _ *UnmarshalOptions) (string, interface{}, error) {
+		return string(r.GetValue()), ListenerUpdate{RouteConfigName: "route"}, nil
+	})
+	defer UnregisterResourceTypeForTesting(goodURL)
+	RegisterResourceType(badURL, ListenerResource, func(r *anypb.Any, _ *UnmarshalOptions) (string, interface{}, error) {
+		return string(r.GetValue()), ClusterUpdate{}, nil
+	})
+	defer UnregisterResourceTypeForTesting(badURL)
+
+	if rType, ok := RegisteredResourceType(goodURL); !ok || rType != ListenerResource {
+		t.Errorf("RegisteredResourceType(%q) = (%v, %v), want (%v, true)", goodURL, rType, ok, ListenerResource)
+	}
+
+	update, _, err := UnmarshalListener(&UnmarshalOptions{
+		Resources: []*anypb.Any{
+			{TypeUrl: goodURL, Value: []byte("good-listener")},
+			{TypeUrl: badURL, Value: []byte("bad-listener")},
+		},
+		Logger: &capturingLogger{},
+	})
+	if err != nil {
+		t.Fatalf("UnmarshalListener() failed: %v", err)
+	}
+	if got := update["good-listener"]; got.Err != nil || got.Update.RouteConfigName != "route" {
+		t.Errorf("good-listener = %+v, want a valid update", got)
+	}
+	if got := update["bad-listener"]; got.Err == nil {
+		t.Errorf("bad-listener = %+v, want an error", got)
+	}
+
+	// Registered type URLs of other resource types are rejected.
+	if _, _, err := UnmarshalCluster(&UnmarshalOptions{
+		Resources: []*anypb.Any{{TypeUrl: goodURL, Value: []byte("good-listener")}},
+		Logger:    &capturingLogger{},
+	}); err == nil {
+		t.Error("UnmarshalCluster() succeeded for a registered Listener type URL, want an error")
+	}
+}
+
+func TestRegisterResourceTypeBuiltinURL(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterResourceType() didn't panic for a built-in type URL")
+		}
+	}()
+	RegisterResourceType("type.googleapis.com/envoy.config.listener.v3.Listener", ListenerResource, nil)
+}
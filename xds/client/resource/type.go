@@ -24,6 +24,7 @@
 package resource
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -39,6 +40,9 @@ import (
 // context/logic available at the xdsClient layer. Since these validation are
 // performed on internal update structs, they can be shared between different
 // API clients.
+//
+// Resources of a response are unmarshaled concurrently, so the function must
+// be safe to call from multiple goroutines.
 type UpdateValidatorFunc func(interface{}) error
 
 // UpdateMetadata contains the metadata for each update, including timestamp,
@@ -53,10 +57,24 @@ type UpdateMetadata struct {
 	Version string
 	// Timestamp is when the response is received.
 	Timestamp time.Time
+	// Nonce is the nonce of the xds response.
+	Nonce string
+	// Stale is set when the response carries the version which was already
+	// ACKed, i.e. the management server resent an unchanged configuration
+	// with a new nonce.
+	Stale bool
+	// Warnings are problems found across the resources of the response which
+	// do not cause a NACK, e.g. conflicting inline route configurations.
+	Warnings []string
 	// ErrState is set when the update is NACKed.
 	ErrState *UpdateErrorMetadata
 }
 
+// AgeSince returns how long before now the response was received.
+func (md UpdateMetadata) AgeSince(now time.Time) time.Duration {
+	return now.Sub(md.Timestamp)
+}
+
 // IsListenerResource returns true if the provider URL corresponds to an xDS
 // Listener resource.
 func IsListenerResource(url string) bool {
@@ -159,3 +177,25 @@ func (r ResourceType) String() string {
 		return "UnknownResource"
 	}
 }
+
+// ValidateResourceType returns an error if typeURL, a v2 or v3 resource type
+// URL, is not of the expected resource type.
+func ValidateResourceType(typeURL string, expected ResourceType) error {
+	var ok bool
+	switch expected {
+	case ListenerResource:
+		ok = IsListenerResource(typeURL)
+	case HTTPConnManagerResource:
+		ok = IsHTTPConnManagerResource(typeURL)
+	case RouteConfigResource:
+		ok = IsRouteConfigResource(typeURL)
+	case ClusterResource:
+		ok = IsClusterResource(typeURL)
+	case EndpointsResource:
+		ok = IsEndpointsResource(typeURL)
+	}
+	if !ok {
+		return fmt.Errorf("unexpected resource type %q, want a %v", typeURL, expected)
+	}
+	return nil
+}
@@ -23,8 +23,13 @@
 
 package resource
 
+import (
+	"time"
+)
+
 import (
 	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 // ClusterType is the type of cluster from a received CDS response.
@@ -50,6 +55,134 @@ type ClusterLBPolicyRingHash struct {
 	MaximumRingSize uint64
 }
 
+// RoutingPriority is the routing priority a set of circuit breaking thresholds
+// applies to.
+type RoutingPriority int
+
+const (
+	// RoutingPriorityDefault represents the DEFAULT routing priority.
+	RoutingPriorityDefault RoutingPriority = iota
+	// RoutingPriorityHigh represents the HIGH routing priority.
+	RoutingPriorityHigh
+)
+
+// CircuitBreakerThresholds contains the circuit breaking thresholds for one
+// routing priority. Unset fields carry the Envoy default of 1024.
+type CircuitBreakerThresholds struct {
+	MaxRequests        uint32
+	MaxPendingRequests uint32
+}
+
+// TransportSocketMatch is one of the transport_socket_matches of a cluster.
+type TransportSocketMatch struct {
+	// Name is the name of the match, used in stats.
+	Name string
+	// Match contains the criteria an endpoint must satisfy: each field must
+	// be present, with an equal value, in the endpoint's
+	// "envoy.transport_socket_match" filter metadata. An empty Match matches
+	// every endpoint.
+	Match *structpb.Struct
+	// SecurityCfg is the security configuration of the connections to the
+	// matching endpoints, nil for plaintext.
+	SecurityCfg *SecurityConfig
+	// UseClusterDefault is set if the match's transport socket isn't
+	// supported. Its endpoints fall back to the cluster's SecurityCfg.
+	UseClusterDefault bool
+}
+
+// HTTP2Config contains the HTTP/2 protocol options of a cluster, from the
+// envoy.extensions.upstreams.http.v3.HttpProtocolOptions entry of its
+// typed_extension_protocol_options. Zero fields are unset.
+type HTTP2Config struct {
+	MaxConcurrentStreams        uint32
+	InitialStreamWindowSize     uint32
+	InitialConnectionWindowSize uint32
+}
+
+// DNSLookupFamily is the address family a LOGICAL_DNS cluster resolves its
+// host name to.
+type DNSLookupFamily int
+
+const (
+	// DNSLookupFamilyAuto resolves IPv6 addresses, falling back to IPv4 if
+	// there are none.
+	DNSLookupFamilyAuto DNSLookupFamily = iota
+	// DNSLookupFamilyV4Only only resolves IPv4 addresses.
+	DNSLookupFamilyV4Only
+	// DNSLookupFamilyV6Only only resolves IPv6 addresses.
+	DNSLookupFamilyV6Only
+	// DNSLookupFamilyV4Preferred resolves IPv4 addresses, falling back to
+	// IPv6 if there are none.
+	DNSLookupFamilyV4Preferred
+	// DNSLookupFamilyAll resolves both IPv4 and IPv6 addresses.
+	DNSLookupFamilyAll
+)
+
+// defaultDNSRefreshRate is the Envoy default of dns_refresh_rate.
+const defaultDNSRefreshRate = 5 * time.Second
+
+// SubsetFallbackPolicy is what subset load balancing does when no subset
+// matches a request.
+type SubsetFallbackPolicy int
+
+const (
+	// SubsetFallbackNone fails the request.
+	SubsetFallbackNone SubsetFallbackPolicy = iota
+	// SubsetFallbackAnyEndpoint picks from all the endpoints.
+	SubsetFallbackAnyEndpoint
+	// SubsetFallbackDefaultSubset picks from the endpoints matching the
+	// default subset.
+	SubsetFallbackDefaultSubset
+	// SubsetFallbackKeysSubset retries with the selector's
+	// FallbackKeysSubset. It is only valid on a selector.
+	SubsetFallbackKeysSubset
+)
+
+// SubsetConfig contains the subset load balancing configuration of a cluster,
+// from its lb_subset_config.
+type SubsetConfig struct {
+	// FallbackPolicy is the cluster's fallback_policy, SubsetFallbackNone if
+	// unset.
+	FallbackPolicy SubsetFallbackPolicy
+	// DefaultSubset is the metadata the endpoints of the default subset
+	// match, or nil if unset.
+	DefaultSubset *structpb.Struct
+	// Selectors are the subset_selectors, in order.
+	Selectors []SubsetSelector
+}
+
+// SubsetSelector is a subset selector of a SubsetConfig. Subsets are made of
+// the endpoints with the same values for Keys in their LB metadata.
+type SubsetSelector struct {
+	// Keys are the metadata keys of the subsets, never empty.
+	Keys []string
+	// SingleHostPerSubset is set if each subset has a single endpoint.
+	SingleHostPerSubset bool
+	// FallbackPolicy is the selector's fallback_policy, or the cluster's if
+	// the selector doesn't define one.
+	FallbackPolicy SubsetFallbackPolicy
+	// FallbackKeysSubset are the keys to retry with, only set if
+	// FallbackPolicy is SubsetFallbackKeysSubset. They are a subset of Keys.
+	FallbackKeysSubset []string
+}
+
+// OutlierDetection contains the outlier detection configuration of a
+// cluster. Unset fields carry the Envoy defaults.
+type OutlierDetection struct {
+	// Interval is the time interval between ejection analysis sweeps.
+	Interval time.Duration
+	// BaseEjectionTime is the base time that a host is ejected for.
+	BaseEjectionTime time.Duration
+	// MaxEjectionPercent is the maximum % of hosts that can be ejected.
+	MaxEjectionPercent uint32
+	// Consecutive5xx is the number of consecutive 5xx responses before a
+	// host is ejected.
+	Consecutive5xx uint32
+	// ConsecutiveGatewayFailure is the number of consecutive gateway failures
+	// before a host is ejected.
+	ConsecutiveGatewayFailure uint32
+}
+
 // ClusterUpdate contains information from a received CDS response, which is of
 // interest to the registered CDS watcher.
 type ClusterUpdate struct {
@@ -63,11 +196,31 @@ type ClusterUpdate struct {
 	EnableLRS bool
 	// SecurityCfg contains security configuration sent by the control plane.
 	SecurityCfg *SecurityConfig
+	// TransportSocketMatches are the cluster's transport_socket_matches, in
+	// order. Endpoints use the security configuration of the first match
+	// whose criteria their metadata satisfy, and SecurityCfg if none does.
+	TransportSocketMatches []TransportSocketMatch
 	// MaxRequests for circuit breaking, if any (otherwise nil).
 	MaxRequests *uint32
+	// CircuitBreakers contains the circuit breaking thresholds keyed by
+	// routing priority. It is nil if the cluster has no thresholds.
+	CircuitBreakers map[RoutingPriority]CircuitBreakerThresholds
+	// OutlierDetection is the outlier detection configuration, if any
+	// (otherwise nil).
+	OutlierDetection *OutlierDetection
 	// DNSHostName is used only for cluster type DNS. It's the DNS name to
 	// resolve in "host:port" form
 	DNSHostName string
+	// DNSRefreshRate is used only for cluster type DNS. It's the interval
+	// between resolutions of DNSHostName, 5s if unset.
+	DNSRefreshRate time.Duration
+	// RespectDNSTTL is used only for cluster type DNS. If set, DNSHostName is
+	// re-resolved when the TTL of the records expires, instead of every
+	// DNSRefreshRate.
+	RespectDNSTTL bool
+	// DNSLookupFamily is used only for cluster type DNS. It's the address
+	// family DNSHostName is resolved to.
+	DNSLookupFamily DNSLookupFamily
 	// PrioritizedClusterNames is used only for cluster type aggregate. It represents
 	// a prioritized list of cluster names.
 	PrioritizedClusterNames []string
@@ -82,6 +235,14 @@ type ClusterUpdate struct {
 	// will be set to different types based on the policy type.
 	LBPolicy *ClusterLBPolicyRingHash
 
+	// HTTP2Config is the HTTP/2 configuration of the upstream connections, nil
+	// if the cluster doesn't configure HTTP/2.
+	HTTP2Config *HTTP2Config
+
+	// SubsetConfig is the subset load balancing configuration, nil if the
+	// cluster doesn't configure subsets.
+	SubsetConfig *SubsetConfig
+
 	// Raw is the resource from the xds response.
 	Raw *anypb.Any
 }
@@ -25,8 +25,13 @@ package resource
 
 import (
 	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
+// LBMetadataKey is the filter_metadata key of the endpoint metadata used by
+// subset load balancing.
+const LBMetadataKey = "envoy.lb"
+
 // OverloadDropConfig contains the config to drop overloads.
 type OverloadDropConfig struct {
 	Category    string
@@ -56,7 +61,23 @@ const (
 type Endpoint struct {
 	Address      string
 	HealthStatus EndpointHealthStatus
-	Weight       uint32
+	// Weight is the endpoint's load_balancing_weight, or 1 if unset.
+	Weight uint32
+	// FilterMetadata is the endpoint's metadata.filter_metadata, keyed by
+	// filter name, or nil if unset. It is passed through as is.
+	FilterMetadata map[string]*structpb.Struct
+}
+
+// LBMetadata returns the endpoint's metadata under LBMetadataKey, which
+// subset load balancing filters endpoints by, or nil if there is none.
+func (e *Endpoint) LBMetadata() *structpb.Struct {
+	return e.FilterMetadata[LBMetadataKey]
+}
+
+// IsUsable returns true if traffic may be sent to the endpoint, i.e. its
+// health status is HEALTHY or UNKNOWN.
+func (e *Endpoint) IsUsable() bool {
+	return e.HealthStatus == EndpointHealthStatusHealthy || e.HealthStatus == EndpointHealthStatusUnknown
 }
 
 // Locality contains information of a locality.
@@ -64,7 +85,8 @@ type Locality struct {
 	Endpoints []Endpoint
 	ID        LocalityID
 	Priority  uint32
-	Weight    uint32
+	// Weight is the locality's load_balancing_weight, or 1 if unset.
+	Weight uint32
 }
 
 // EndpointsUpdate contains an EDS update.
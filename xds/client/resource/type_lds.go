@@ -24,11 +24,17 @@
 package resource
 
 import (
+	"net"
+	"reflect"
+	"strings"
 	"time"
 )
 
 import (
+	"github.com/golang/protobuf/proto"
+
 	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 import (
@@ -48,21 +54,192 @@ type ListenerUpdate struct {
 	//
 	// Exactly one of RouteConfigName and InlineRouteConfig is set.
 	InlineRouteConfig *RouteConfigUpdate
+	// InlineRouteConfigName is the name of the inline route configuration,
+	// empty if InlineRouteConfig is not set.
+	InlineRouteConfigName string
 
 	// MaxStreamDuration contains the HTTP connection manager's
 	// common_http_protocol_options.max_stream_duration field, or zero if
 	// unset.
 	MaxStreamDuration time.Duration
+	// IdleTimeout contains the HTTP connection manager's
+	// common_http_protocol_options.idle_timeout field, or 0 if it is unset.
+	// It is never larger than a non-zero MaxStreamDuration.
+	IdleTimeout time.Duration
 	// HTTPFilters is a list of HTTP filters (name, config) from the LDS
 	// response.
 	HTTPFilters []HTTPFilter
+	// UpgradeConfigs are the HTTP connection manager's upgrade_configs, the
+	// connection upgrades, e.g. "websocket", which are allowed, keyed by the
+	// lower cased upgrade type. The value reports whether the upgrade is
+	// enabled by default; routes may override it, see UpgradeEnabled.
+	UpgradeConfigs map[string]bool
+	// AccessLogs are the HTTP connection manager's access_log entries, in
+	// order.
+	AccessLogs []AccessLogConfig
+	// LocalReplyConfig is the HTTP connection manager's local_reply_config,
+	// nil if unset.
+	LocalReplyConfig *LocalReplyConfig
 	// InboundListenerCfg contains inbound listener configuration.
 	InboundListenerCfg *InboundListenerConfig
+	// FilterMetadata is the listener's metadata.filter_metadata, keyed by
+	// filter name, or nil if unset. It is passed through as is; the keys are
+	// not interpreted.
+	FilterMetadata map[string]*structpb.Struct
+	// TrafficDirection is the listener's traffic_direction, set on both
+	// client- and server-side listeners, e.g. by Istio to tell sidecar
+	// inbound listeners from outbound ones.
+	TrafficDirection TrafficDirection
 
 	// Raw is the resource from the xds response.
 	Raw *anypb.Any
+	// RawHash is the 64-bit FNV-1a hash of the serialized bytes of Raw. Equal
+	// hashes mean, barring collisions, that the listener is unchanged, even
+	// if the response version changed. Serialization isn't canonical, so
+	// different hashes don't imply the listener changed.
+	RawHash uint64
+
+	// httpFilterIndex maps the names of HTTPFilters to their index, see
+	// HTTPFilter.
+	httpFilterIndex map[string]int
+}
+
+// HTTPFilter returns the HTTP filter of lu named name, if any.
+func (lu ListenerUpdate) HTTPFilter(name string) (HTTPFilter, bool) {
+	// The index is built when the update is parsed. It is checked before use
+	// since HTTPFilters may have been modified since.
+	if i, ok := lu.httpFilterIndex[name]; ok && i < len(lu.HTTPFilters) && lu.HTTPFilters[i].Name == name {
+		return lu.HTTPFilters[i], true
+	}
+	for _, f := range lu.HTTPFilters {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return HTTPFilter{}, false
+}
+
+// Equal reports whether lu and other are equivalent, i.e. a watcher which
+// applied one has nothing to reconfigure for the other.
+//
+// The route config name, the inline route config, compared by its Raw
+// resource, the HTTP filters, in order, see httpfilter.ConfigEqualer, the
+// timeouts, upgrade configs, access logs, local reply config and traffic
+// direction are compared.
+// Raw, RawHash and FilterMetadata are ignored, except that server-side
+// listeners are compared by Raw: their filter chains can't be compared
+// otherwise.
+func (lu ListenerUpdate) Equal(other ListenerUpdate) bool {
+	if lu.RouteConfigName != other.RouteConfigName ||
+		lu.InlineRouteConfigName != other.InlineRouteConfigName ||
+		lu.MaxStreamDuration != other.MaxStreamDuration ||
+		lu.IdleTimeout != other.IdleTimeout ||
+		lu.TrafficDirection != other.TrafficDirection {
+		return false
+	}
+	if (lu.InlineRouteConfig == nil) != (other.InlineRouteConfig == nil) {
+		return false
+	}
+	if lu.InlineRouteConfig != nil && !proto.Equal(lu.InlineRouteConfig.Raw, other.InlineRouteConfig.Raw) {
+		return false
+	}
+	if len(lu.HTTPFilters) != len(other.HTTPFilters) {
+		return false
+	}
+	for i, f := range lu.HTTPFilters {
+		if !f.equal(other.HTTPFilters[i]) {
+			return false
+		}
+	}
+	if len(lu.UpgradeConfigs) != len(other.UpgradeConfigs) {
+		return false
+	}
+	for t, enabled := range lu.UpgradeConfigs {
+		if e, ok := other.UpgradeConfigs[t]; !ok || e != enabled {
+			return false
+		}
+	}
+	if len(lu.AccessLogs) != len(other.AccessLogs) {
+		return false
+	}
+	for i, al := range lu.AccessLogs {
+		o := other.AccessLogs[i]
+		if al.Name != o.Name || al.TypeURL != o.TypeURL || al.Supported != o.Supported ||
+			al.Path != o.Path || al.Format != o.Format || !proto.Equal(al.JSONFormat, o.JSONFormat) {
+			return false
+		}
+	}
+	if !reflect.DeepEqual(lu.LocalReplyConfig, other.LocalReplyConfig) {
+		return false
+	}
+	if (lu.InboundListenerCfg == nil) != (other.InboundListenerCfg == nil) {
+		return false
+	}
+	return lu.InboundListenerCfg == nil || proto.Equal(lu.Raw, other.Raw)
 }
 
+// UpgradeEnabled reports whether the connection upgrade upgradeType, e.g.
+// "websocket", is enabled on route r. Upgrades not in lu.UpgradeConfigs are
+// never enabled; the others are enabled as set by r's upgrade_configs, or by
+// default if r doesn't configure them. r may be nil.
+func (lu ListenerUpdate) UpgradeEnabled(r *Route, upgradeType string) bool {
+	upgradeType = strings.ToLower(upgradeType)
+	enabled, ok := lu.UpgradeConfigs[upgradeType]
+	if !ok {
+		return false
+	}
+	if r != nil {
+		if e, ok := r.UpgradeConfigs[upgradeType]; ok {
+			return e
+		}
+	}
+	return enabled
+}
+
+// HTTPFilterConfig returns the config of the HTTP filter of lu named name, if
+// any.
+func (lu ListenerUpdate) HTTPFilterConfig(name string) (httpfilter.FilterConfig, bool) {
+	f, ok := lu.HTTPFilter(name)
+	return f.Config, ok
+}
+
+func indexHTTPFilters(fs []HTTPFilter) map[string]int {
+	if len(fs) == 0 {
+		return nil
+	}
+	m := make(map[string]int, len(fs))
+	for i, f := range fs {
+		m[f.Name] = i
+	}
+	return m
+}
+
+// AccessLogConfig is an access log of an HTTP connection manager.
+type AccessLogConfig struct {
+	// Name is the name of the access log.
+	Name string
+	// TypeURL is the type URL of the access log's typed_config.
+	TypeURL string
+	// Supported reports whether the type of the access log is supported.
+	// Only file access logs are; nothing is logged for the others.
+	Supported bool
+	// Path is the path of the file of a file access log.
+	Path string
+	// Format is the text format of the entries of a file access log, empty
+	// for the default format or if JSONFormat is set.
+	Format string
+	// JSONFormat is the JSON format of the entries of a file access log, if
+	// any.
+	JSONFormat *structpb.Struct
+}
+
+// UpdateTransformFunc rewrites a Listener update in place, e.g. to drop
+// configuration the runtime can't honor, before it is handed to watchers.
+// Returning an error NACKs the resource.
+//
+// Like UpdateValidatorFunc, it must be safe to call from multiple goroutines.
+type UpdateTransformFunc func(*ListenerUpdate) error
+
 // HTTPFilter represents one HTTP filter from an LDS response's HTTP connection
 // manager field.
 type HTTPFilter struct {
@@ -74,19 +251,197 @@ type HTTPFilter struct {
 	Filter httpfilter.Filter
 	// Config contains the filter's configuration
 	Config httpfilter.FilterConfig
+	// RawConfig is the typed_config Config was parsed from, unwrapped from
+	// any FilterConfig wrapper.
+	RawConfig *anypb.Any
+}
+
+// equal reports whether f and other are the same filter with equivalent
+// configs, using the config's Equal if it implements
+// httpfilter.ConfigEqualer and comparing RawConfig otherwise.
+func (f HTTPFilter) equal(other HTTPFilter) bool {
+	if f.Name != other.Name {
+		return false
+	}
+	if eq, ok := f.Config.(httpfilter.ConfigEqualer); ok {
+		return eq.Equal(other.Config)
+	}
+	if f.RawConfig == nil && other.RawConfig == nil {
+		// Filters built by hand have no raw config.
+		return reflect.DeepEqual(f.Config, other.Config)
+	}
+	return proto.Equal(f.RawConfig, other.RawConfig)
 }
 
 // InboundListenerConfig contains information about the inbound listener, i.e
 // the server-side listener.
 type InboundListenerConfig struct {
 	// Address is the local address on which the inbound listener is expected to
-	// accept incoming connections.
+	// accept incoming connections. It is either a host name or an IP in its
+	// canonical form, without brackets for IPv6; use HostPort to combine it
+	// with Port.
 	Address string
 	// Port is the local port on which the inbound listener is expected to
 	// accept incoming connections.
 	Port string
+	// AdditionalAddresses are the listener's additional_addresses, the
+	// addresses it accepts connections on besides Address and Port, e.g. the
+	// IPv6 address of a dual-stack listener. They are validated like the
+	// primary address and are all distinct from it.
+	AdditionalAddresses []ListenerAddress
 	// FilterChains is the list of filter chains associated with this listener.
 	FilterChains *FilterChainManager
+	// DefaultFilterChain is the listener's default_filter_chain, nil if
+	// unset. FilterChains.Lookup falls back to it when no filter chain
+	// matches a connection.
+	DefaultFilterChain *FilterChain
+	// UseOriginalDst is the listener's use_original_dst. If set, connections
+	// were redirected to the listener and the filter chains are to be matched
+	// against their original destination. It is only ever set if
+	// UnmarshalOptions.AllowOriginalDst is.
+	UseOriginalDst bool
+	// TLSInspector reports whether the listener has the TLS inspector listener
+	// filter. The server then reads the TLS ClientHello of connections to
+	// match the filter chains on their server name, transport protocol and
+	// application protocols. The HTTP inspector is accepted but not recorded:
+	// the application protocols it detects only matter to raw_buffer
+	// connections, which can't match on them.
+	TLSInspector bool
+	// DrainType is the listener's drain_type.
+	DrainType DrainType
+	// PerConnectionBufferLimitBytes is the listener's
+	// per_connection_buffer_limit_bytes, the soft limit on the size of the
+	// read and write buffers of connections. Zero means the default limit.
+	PerConnectionBufferLimitBytes uint32
+}
+
+// DrainType is the way a listener is drained.
+type DrainType int
+
+const (
+	// DrainTypeDefault drains the listener on hot restarts, modifications
+	// and removals of the listener, and health check failures.
+	DrainTypeDefault DrainType = iota
+	// DrainTypeModifyOnly drains the listener only when it is modified or
+	// removed.
+	DrainTypeModifyOnly
+)
+
+func (d DrainType) String() string {
+	switch d {
+	case DrainTypeModifyOnly:
+		return "ModifyOnly"
+	default:
+		return "Default"
+	}
+}
+
+// TrafficDirection is the direction of the traffic of a listener relative to
+// the local workload.
+type TrafficDirection int
+
+const (
+	// TrafficDirectionUnspecified is the default, when the direction is not
+	// set.
+	TrafficDirectionUnspecified TrafficDirection = iota
+	// TrafficDirectionInbound is for listeners of traffic to the local
+	// workload.
+	TrafficDirectionInbound
+	// TrafficDirectionOutbound is for listeners of traffic from the local
+	// workload.
+	TrafficDirectionOutbound
+)
+
+func (d TrafficDirection) String() string {
+	switch d {
+	case TrafficDirectionInbound:
+		return "Inbound"
+	case TrafficDirectionOutbound:
+		return "Outbound"
+	default:
+		return "Unspecified"
+	}
+}
+
+// ListenerAddress is an additional address of an InboundListenerConfig. The
+// fields have the same format as the primary address.
+type ListenerAddress struct {
+	Address string
+	Port    string
+}
+
+// HostPort returns the address and port of the listener as a "host:port"
+// string, with IPv6 addresses in brackets.
+func (ilc *InboundListenerConfig) HostPort() string {
+	return net.JoinHostPort(ilc.Address, ilc.Port)
+}
+
+// Clone returns a copy of lu which can be modified without affecting lu.
+//
+// Raw, FilterMetadata, UpgradeConfigs, AccessLogs, the HTTP filter slices and
+// InlineRouteConfig, see RouteConfigUpdate.Clone, are deep copied. The filters
+// and their parsed and raw configs, LocalReplyConfig, and the filter chains of
+// InboundListenerCfg, are shared: they are never modified once parsed.
+func (lu ListenerUpdate) Clone() ListenerUpdate {
+	ret := lu
+	if lu.InlineRouteConfig != nil {
+		rc := lu.InlineRouteConfig.Clone()
+		ret.InlineRouteConfig = &rc
+	}
+	ret.HTTPFilters = cloneHTTPFilters(lu.HTTPFilters)
+	ret.UpgradeConfigs = cloneUpgradeConfigs(lu.UpgradeConfigs)
+	if lu.AccessLogs != nil {
+		ret.AccessLogs = make([]AccessLogConfig, len(lu.AccessLogs))
+		for i, al := range lu.AccessLogs {
+			if al.JSONFormat != nil {
+				al.JSONFormat = proto.Clone(al.JSONFormat).(*structpb.Struct)
+			}
+			ret.AccessLogs[i] = al
+		}
+	}
+	if lu.InboundListenerCfg != nil {
+		ret.InboundListenerCfg = lu.InboundListenerCfg.Clone()
+	}
+	if lu.FilterMetadata != nil {
+		ret.FilterMetadata = make(map[string]*structpb.Struct, len(lu.FilterMetadata))
+		for k, v := range lu.FilterMetadata {
+			ret.FilterMetadata[k] = proto.Clone(v).(*structpb.Struct)
+		}
+	}
+	if lu.Raw != nil {
+		ret.Raw = proto.Clone(lu.Raw).(*anypb.Any)
+	}
+	return ret
+}
+
+// cloneHTTPFilters returns a copy of the slice fs. The filters and their
+// configs are shared.
+func cloneHTTPFilters(fs []HTTPFilter) []HTTPFilter {
+	if fs == nil {
+		return nil
+	}
+	return append(make([]HTTPFilter, 0, len(fs)), fs...)
+}
+
+func cloneUpgradeConfigs(ucs map[string]bool) map[string]bool {
+	if ucs == nil {
+		return nil
+	}
+	ret := make(map[string]bool, len(ucs))
+	for k, v := range ucs {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Clone returns a copy of ilc. The filter chain manager is shared, as it is
+// immutable once built.
+func (ilc *InboundListenerConfig) Clone() *InboundListenerConfig {
+	ret := *ilc
+	if ilc.AdditionalAddresses != nil {
+		ret.AdditionalAddresses = append([]ListenerAddress(nil), ilc.AdditionalAddresses...)
+	}
+	return &ret
 }
 
 // ListenerUpdateErrTuple is a tuple with the update and error. It contains the
@@ -25,16 +25,20 @@ package resource
 
 import (
 	"regexp"
+	"strings"
 	"time"
 )
 
 import (
+	"github.com/golang/protobuf/proto"
+
 	"google.golang.org/grpc/codes"
 
 	"google.golang.org/protobuf/types/known/anypb"
 )
 
 import (
+	"dubbo.apache.org/dubbo-go/v3/xds/client/resource/version"
 	"dubbo.apache.org/dubbo-go/v3/xds/clusterspecifier"
 	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
 	"dubbo.apache.org/dubbo-go/v3/xds/utils/matcher"
@@ -47,8 +51,108 @@ type RouteConfigUpdate struct {
 	// ClusterSpecifierPlugins are the LB Configurations for any
 	// ClusterSpecifierPlugins referenced by the Route Table.
 	ClusterSpecifierPlugins map[string]clusterspecifier.BalancerConfig
+	// VHDS is set if the route configuration has a vhds field, meaning virtual
+	// hosts beyond VirtualHosts are fetched on demand. VirtualHosts may be
+	// empty in this case.
+	VHDS *VHDSConfig
 	// Raw is the resource from the xds response.
 	Raw *anypb.Any
+
+	// domainOrder is the domains of VirtualHosts sorted for matching, see
+	// domainMatchOrder.
+	domainOrder []vhDomain
+}
+
+// VHDSConfig is the on demand virtual host discovery config of a route
+// configuration.
+type VHDSConfig struct {
+	// ResourceAPIVersion is the API version of the virtual host resources. The
+	// config source is always ADS, the only one supported.
+	ResourceAPIVersion version.TransportAPI
+}
+
+// Clone returns a copy of u which can be modified without affecting u.
+//
+// The virtual hosts and routes are copied along with their slices and maps,
+// such as the header mutations and filter config overrides, and Raw is deep
+// copied. Parsed values which are never modified, such as matchers, compiled
+// regexes, filter and cluster specifier configs and the pointed to retry, CORS
+// and hash policies, are shared.
+func (u RouteConfigUpdate) Clone() RouteConfigUpdate {
+	ret := u
+	if u.VirtualHosts != nil {
+		ret.VirtualHosts = make([]*VirtualHost, len(u.VirtualHosts))
+		for i, vh := range u.VirtualHosts {
+			ret.VirtualHosts[i] = vh.clone()
+		}
+	}
+	if u.ClusterSpecifierPlugins != nil {
+		ret.ClusterSpecifierPlugins = make(map[string]clusterspecifier.BalancerConfig, len(u.ClusterSpecifierPlugins))
+		for k, v := range u.ClusterSpecifierPlugins {
+			ret.ClusterSpecifierPlugins[k] = v
+		}
+	}
+	if u.Raw != nil {
+		ret.Raw = proto.Clone(u.Raw).(*anypb.Any)
+	}
+	// The domain order points to the virtual hosts, so it is rebuilt for the
+	// copies.
+	if u.domainOrder != nil {
+		ret.domainOrder = domainMatchOrder(ret.VirtualHosts)
+	}
+	return ret
+}
+
+func (vh *VirtualHost) clone() *VirtualHost {
+	ret := *vh
+	ret.Domains = append([]string(nil), vh.Domains...)
+	if vh.Routes != nil {
+		ret.Routes = make([]*Route, len(vh.Routes))
+		for i, r := range vh.Routes {
+			ret.Routes[i] = r.clone()
+		}
+	}
+	ret.HTTPFilterConfigOverride = cloneFilterConfigs(vh.HTTPFilterConfigOverride)
+	ret.HeaderMutations = vh.HeaderMutations.clone()
+	return &ret
+}
+
+// FilterConfigs returns the listener config of f and the override of it which
+// applies to r, to be passed to the filter's interceptor builders. override
+// is nil if neither r nor its virtual host override f.
+func (r *Route) FilterConfigs(f HTTPFilter) (config, override httpfilter.FilterConfig) {
+	return f.Config, r.EffectiveHTTPFilterConfigOverride[f.Name]
+}
+
+func (r *Route) clone() *Route {
+	ret := *r
+	ret.Headers = append([]*HeaderMatcher(nil), r.Headers...)
+	ret.QueryParams = append([]QueryParamMatcher(nil), r.QueryParams...)
+	ret.HashPolicies = append([]*HashPolicy(nil), r.HashPolicies...)
+	ret.MirrorPolicies = append([]MirrorPolicy(nil), r.MirrorPolicies...)
+	ret.UpgradeConfigs = cloneUpgradeConfigs(r.UpgradeConfigs)
+	ret.HTTPFilterConfigOverride = cloneFilterConfigs(r.HTTPFilterConfigOverride)
+	ret.EffectiveHTTPFilterConfigOverride = cloneFilterConfigs(r.EffectiveHTTPFilterConfigOverride)
+	ret.HeaderMutations = r.HeaderMutations.clone()
+	if r.WeightedClusters != nil {
+		ret.WeightedClusters = make(map[string]WeightedCluster, len(r.WeightedClusters))
+		for k, wc := range r.WeightedClusters {
+			wc.HTTPFilterConfigOverride = cloneFilterConfigs(wc.HTTPFilterConfigOverride)
+			ret.WeightedClusters[k] = wc
+		}
+	}
+	return &ret
+}
+
+func cloneFilterConfigs(m map[string]httpfilter.FilterConfig) map[string]httpfilter.FilterConfig {
+	if m == nil {
+		return nil
+	}
+	ret := make(map[string]httpfilter.FilterConfig, len(m))
+	for k, v := range m {
+		ret[k] = v
+	}
+	return ret
 }
 
 // VirtualHost contains the routes for a list of Domains.
@@ -65,7 +169,98 @@ type VirtualHost struct {
 	// may be unused if the matching Route contains an override for that
 	// filter.
 	HTTPFilterConfigOverride map[string]httpfilter.FilterConfig
-	RetryConfig              *RetryConfig
+	// RetryConfig is the virtual host's retry policy. It is already applied
+	// to the routes without a retry policy of their own.
+	RetryConfig *RetryConfig
+	// IncludeRequestAttemptCount and IncludeAttemptCountInResponse report
+	// whether the x-envoy-attempt-count header is added to requests, and to
+	// responses, respectively.
+	IncludeRequestAttemptCount    bool
+	IncludeAttemptCountInResponse bool
+	// CORSPolicy is the CORS policy from the virtual host's
+	// typed_per_filter_config, nil if there is none.
+	CORSPolicy *CORSPolicy
+	// HeaderMutations are the header mutations of the virtual host merged
+	// over those of the route configuration.
+	HeaderMutations HeaderMutations
+}
+
+// HeaderMutations contains the headers to add to and remove from requests and
+// responses, in the order they are configured.
+type HeaderMutations struct {
+	RequestHeadersToAdd     []HeaderValueOption
+	RequestHeadersToRemove  []string
+	ResponseHeadersToAdd    []HeaderValueOption
+	ResponseHeadersToRemove []string
+}
+
+// HeaderValueOption is a header to add. If Append is false, the value
+// replaces any existing value of the header.
+type HeaderValueOption struct {
+	Key    string
+	Value  string
+	Append bool
+}
+
+// merge returns the mutations of m with the more specific mutations of over
+// applied on top: an entry of over with Append unset replaces the entries of
+// m for the same header.
+func (m HeaderMutations) merge(over HeaderMutations) HeaderMutations {
+	return HeaderMutations{
+		RequestHeadersToAdd:     mergeHeaderValueOptions(m.RequestHeadersToAdd, over.RequestHeadersToAdd),
+		RequestHeadersToRemove:  mergeHeaderNames(m.RequestHeadersToRemove, over.RequestHeadersToRemove),
+		ResponseHeadersToAdd:    mergeHeaderValueOptions(m.ResponseHeadersToAdd, over.ResponseHeadersToAdd),
+		ResponseHeadersToRemove: mergeHeaderNames(m.ResponseHeadersToRemove, over.ResponseHeadersToRemove),
+	}
+}
+
+func (m HeaderMutations) clone() HeaderMutations {
+	return HeaderMutations{
+		RequestHeadersToAdd:     append([]HeaderValueOption(nil), m.RequestHeadersToAdd...),
+		RequestHeadersToRemove:  append([]string(nil), m.RequestHeadersToRemove...),
+		ResponseHeadersToAdd:    append([]HeaderValueOption(nil), m.ResponseHeadersToAdd...),
+		ResponseHeadersToRemove: append([]string(nil), m.ResponseHeadersToRemove...),
+	}
+}
+
+func mergeHeaderValueOptions(base, over []HeaderValueOption) []HeaderValueOption {
+	replaced := make(map[string]bool)
+	for _, o := range over {
+		if !o.Append {
+			replaced[strings.ToLower(o.Key)] = true
+		}
+	}
+	var ret []HeaderValueOption
+	for _, o := range base {
+		if !replaced[strings.ToLower(o.Key)] {
+			ret = append(ret, o)
+		}
+	}
+	return append(ret, over...)
+}
+
+func mergeHeaderNames(base, over []string) []string {
+	seen := make(map[string]bool)
+	var ret []string
+	for _, names := range [][]string{base, over} {
+		for _, n := range names {
+			if key := strings.ToLower(n); !seen[key] {
+				seen[key] = true
+				ret = append(ret, n)
+			}
+		}
+	}
+	return ret
+}
+
+// CORSPolicy contains the CORS configuration of a VirtualHost or Route.
+type CORSPolicy struct {
+	AllowOrigins     []matcher.StringMatcher
+	AllowMethods     []string
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	MaxAge           *time.Duration // nil if max_age is unset
+	AllowCredentials bool
 }
 
 // RetryConfig contains all retry-related configuration in either a VirtualHost
@@ -74,9 +269,22 @@ type RetryConfig struct {
 	// RetryOn is a set of status codes on which to retry.  Only Canceled,
 	// DeadlineExceeded, Internal, ResourceExhausted, and Unavailable are
 	// supported; any other values will be omitted.
-	RetryOn      map[codes.Code]bool
-	NumRetries   uint32       // maximum number of retry attempts
-	RetryBackoff RetryBackoff // retry backoff policy
+	RetryOn       map[codes.Code]bool
+	NumRetries    uint32        // maximum number of retry attempts
+	RetryBackoff  RetryBackoff  // retry backoff policy
+	PerTryTimeout time.Duration // timeout per attempt, zero if unset
+
+	// RetriableStatusCodes are the HTTP status codes on which to retry, from
+	// retriable_status_codes. They are only set if retry_on contains
+	// "retriable-status-codes"; codes outside [100, 599] are omitted.
+	RetriableStatusCodes []uint32
+	// RetriableHeaders match the response headers on which to retry, from
+	// retriable_headers. They are only set if retry_on contains
+	// "retriable-headers".
+	RetriableHeaders []*HeaderMatcher
+	// RetriableRequestHeaders, if set, must match the request headers for the
+	// request to be retried at all.
+	RetriableRequestHeaders []*HeaderMatcher
 }
 
 // RetryBackoff describes the backoff policy for retries.
@@ -94,6 +302,11 @@ const (
 	// HashPolicyTypeChannelID specifies to hash a unique Identifier of the
 	// Channel. In grpc-go, this will be done using the ClientConn pointer.
 	HashPolicyTypeChannelID
+	// HashPolicyTypeCookie specifies to hash a Cookie in the incoming request.
+	HashPolicyTypeCookie
+	// HashPolicyTypeSourceIP specifies to hash the source IP address of the
+	// incoming connection.
+	HashPolicyTypeSourceIP
 )
 
 // HashPolicy specifies the HashPolicy if the upstream cluster uses a hashing
@@ -105,6 +318,10 @@ type HashPolicy struct {
 	HeaderName        string
 	Regex             *regexp.Regexp
 	RegexSubstitution string
+	// Fields used for type COOKIE. CookieTTL is nil if the ttl was not set.
+	CookieName string
+	CookieTTL  *time.Duration
+	CookiePath string
 }
 
 // RouteActionType is the action of the route from a received RDS response.
@@ -124,6 +341,13 @@ const (
 	// side. NonForwardingAction represents when a route will generate a
 	// response directly, without forwarding to an upstream host.
 	RouteActionNonForwardingAction
+	// RouteActionDirectResponse represents when a route responds with a fixed
+	// status and body, set in the route's DirectResponse, without forwarding
+	// the request.
+	RouteActionDirectResponse
+	// RouteActionRedirect represents when a route responds with a redirect,
+	// described by the route's Redirect.
+	RouteActionRedirect
 )
 
 // Route is both a specification of how to match a request as well as an
@@ -132,11 +356,20 @@ type Route struct {
 	Path   *string
 	Prefix *string
 	Regex  *regexp.Regexp
-	// Indicates if prefix/path matching should be case insensitive. The default
-	// is false (case sensitive).
+	// Indicates if prefix/path matching should be case insensitive. It is
+	// true only when RouteMatch.case_sensitive is explicitly false; the
+	// default when unset is case sensitive matching. It has no effect on
+	// Regex.
 	CaseInsensitive bool
 	Headers         []*HeaderMatcher
-	Fraction        *uint32
+	QueryParams     []QueryParamMatcher
+	// Fraction is the default value of the match's runtime_fraction, in parts
+	// per million: the route matches this fraction of the requests. nil if
+	// the match has no runtime_fraction.
+	Fraction *uint32
+	// FractionRuntimeKey is the runtime key of the runtime_fraction. It is
+	// only kept for reference; runtime overrides are not supported.
+	FractionRuntimeKey string
 
 	HashPolicies []*HashPolicy
 
@@ -147,21 +380,112 @@ type Route struct {
 	// MaxStreamDuration field should be used.  If MaxStreamDuration is set to
 	// an explicit zero duration, the application's deadline should be used.
 	MaxStreamDuration *time.Duration
+	// PrefixRewrite replaces the matched prefix of the path when forwarding
+	// the request. At most one of PrefixRewrite and RegexRewrite is set.
+	PrefixRewrite string
+	RegexRewrite  *RegexRewrite
+	// Timeout is the route action's overall request timeout, which is
+	// independent of MaxStreamDuration. If nil, the timeout is inherited; an
+	// explicit zero disables it.
+	Timeout *time.Duration
 	// HTTPFilterConfigOverride contains any HTTP filter config overrides for
 	// the route which may be present.  An individual filter's override may be
 	// unused if the matching WeightedCluster contains an override for that
 	// filter.
 	HTTPFilterConfigOverride map[string]httpfilter.FilterConfig
-	RetryConfig              *RetryConfig
+	// EffectiveHTTPFilterConfigOverride is HTTPFilterConfigOverride merged
+	// over the overrides of the route's virtual host, i.e. the override which
+	// applies to the route for each filter. Filters without an entry use
+	// their listener config. Like HTTPFilterConfigOverride, an entry may be
+	// shadowed by the matching WeightedCluster.
+	EffectiveHTTPFilterConfigOverride map[string]httpfilter.FilterConfig
+	// RetryConfig is the retry policy of the route action, or of the virtual
+	// host if the route action has none.
+	RetryConfig *RetryConfig
+	// MirrorPolicies are the route action's request_mirror_policies, in
+	// order. Requests are mirrored to each of them independently.
+	MirrorPolicies []MirrorPolicy
+	// UpgradeConfigs are the route action's upgrade_configs, whether each
+	// connection upgrade is enabled on the route, keyed by the lower cased
+	// upgrade type. They override ListenerUpdate.UpgradeConfigs, see
+	// ListenerUpdate.UpgradeEnabled.
+	UpgradeConfigs map[string]bool
+	// CORSPolicy is the CORS policy from the route's typed_per_filter_config.
+	// If nil, the virtual host's policy applies.
+	CORSPolicy *CORSPolicy
+	// HeaderMutations are the header mutations of the route merged over those
+	// of its virtual host and route configuration.
+	HeaderMutations HeaderMutations
 
 	ActionType RouteActionType
 
 	// Only one of the following fields (WeightedClusters or
-	// ClusterSpecifierPlugin) will be set for a route.
+	// ClusterSpecifierPlugin) will be set for a route. Routes selecting their
+	// cluster with a cluster_header are skipped, as the cluster can't be
+	// resolved when the route is parsed.
 	WeightedClusters map[string]WeightedCluster
 	// ClusterSpecifierPlugin is the name of the Cluster Specifier Plugin that
 	// this Route is linked to, if specified by xDS.
 	ClusterSpecifierPlugin string
+	// DirectResponse is set if ActionType is RouteActionDirectResponse.
+	DirectResponse *DirectResponse
+	// Redirect is set if ActionType is RouteActionRedirect.
+	Redirect *Redirect
+}
+
+// MirrorPolicy is a request mirror policy of a route: a fraction of the
+// requests is also sent to a shadow cluster, whose responses are discarded.
+type MirrorPolicy struct {
+	// Exactly one of Cluster and ClusterHeader is set. ClusterHeader is the
+	// name of the request header holding the cluster.
+	Cluster       string
+	ClusterHeader string
+	// Fraction is the fraction of requests mirrored, in parts per million.
+	// It is 1000000 if the policy doesn't set a runtime_fraction.
+	Fraction uint32
+}
+
+// Redirect is the redirect response of a redirect route. Empty fields keep
+// the corresponding part of the request URL.
+type Redirect struct {
+	// Scheme is the scheme to redirect to. https_redirect is converted to
+	// "https".
+	Scheme string
+	Host   string
+	// Port is the port to redirect to, zero to keep it.
+	Port uint32
+	// At most one of PathRedirect, PrefixRewrite and RegexRewrite is set.
+	// PathRedirect replaces the whole path, PrefixRewrite the matched prefix
+	// of it.
+	PathRedirect  string
+	PrefixRewrite string
+	RegexRewrite  *RegexRewrite
+	// ResponseCode is the HTTP status of the redirect, 301 by default.
+	ResponseCode uint32
+	// StripQuery removes the query string from the redirect URL.
+	StripQuery bool
+}
+
+// DirectResponse is the fixed response of a direct_response route.
+type DirectResponse struct {
+	// Status is the HTTP status code of the response.
+	Status uint32
+	// Body is the response body, empty if none.
+	Body string
+}
+
+// RegexRewrite is a path rewrite which replaces the matches of Regex with
+// Substitution.
+type RegexRewrite struct {
+	Regex *regexp.Regexp
+	// Substitution is a regexp.Expand template; Envoy's \N capture group
+	// references are converted to ${N}.
+	Substitution string
+}
+
+// Rewrite returns path with every match of the regex replaced.
+func (rr *RegexRewrite) Rewrite(path string) string {
+	return rr.Regex.ReplaceAllString(path, rr.Substitution)
 }
 
 // WeightedCluster contains settings for an xds ActionType.WeightedCluster.
@@ -175,13 +499,22 @@ type WeightedCluster struct {
 
 // HeaderMatcher represents header matchers.
 type HeaderMatcher struct {
+	Name          string
+	InvertMatch   *bool
+	ExactMatch    *string
+	RegexMatch    *regexp.Regexp
+	PrefixMatch   *string
+	SuffixMatch   *string
+	ContainsMatch *string
+	RangeMatch    *Int64Range
+	PresentMatch  *bool
+}
+
+// QueryParamMatcher represents a query parameter matcher. Exactly one of
+// StringMatch and PresentMatch is set.
+type QueryParamMatcher struct {
 	Name         string
-	InvertMatch  *bool
-	ExactMatch   *string
-	RegexMatch   *regexp.Regexp
-	PrefixMatch  *string
-	SuffixMatch  *string
-	RangeMatch   *Int64Range
+	StringMatch  *matcher.StringMatcher
 	PresentMatch *bool
 }
 
@@ -224,6 +557,9 @@ type SecurityConfig struct {
 	// client to present a certificate. Set to true when performing mTLS. Used
 	// only on the server-side.
 	RequireClientCert bool
+	// SNI is the server name to send in the TLS handshake, from the
+	// UpstreamTlsContext's sni field. Used only on the client-side.
+	SNI string
 }
 
 // Equal returns true if sc is equal to other.
@@ -245,6 +581,8 @@ func (sc *SecurityConfig) Equal(other *SecurityConfig) bool {
 		return false
 	case sc.RequireClientCert != other.RequireClientCert:
 		return false
+	case sc.SNI != other.SNI:
+		return false
 	default:
 		if len(sc.SubjectAltNameMatchers) != len(other.SubjectAltNameMatchers) {
 			return false
@@ -28,29 +28,132 @@ package resource
 import (
 	"errors"
 	"fmt"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 import (
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/types/known/anypb"
 )
 
 import (
 	dubboLogger "dubbo.apache.org/dubbo-go/v3/common/logger"
+	"dubbo.apache.org/dubbo-go/v3/xds/client/resource/version"
 )
 
 // UnmarshalOptions wraps the input parameters for `UnmarshalXxx` functions.
 type UnmarshalOptions struct {
 	// Version is the version of the received response.
 	Version string
+	// Nonce is the nonce of the received response.
+	Nonce string
+	// AckedVersion is the version most recently ACKed for this resource
+	// type, if any. It is used to detect responses which resend an already
+	// applied version.
+	AckedVersion string
+	// ResourceNames are the names of the resources requested from the
+	// management server. Listener and Cluster responses contain all the
+	// requested resources which exist, so each name missing from them gets
+	// an ErrorTypeResourceNotFound error in the result. Nothing is inferred
+	// if it is empty or contains the wildcard "*".
+	ResourceNames []string
+	// TransportAPI is the xDS transport protocol version the response was
+	// received on. Resources with v2 type URLs are rejected on v3 transports.
+	TransportAPI version.TransportAPI
+	// DisableV2 rejects v2 Listener resources instead of processing them
+	// with the relaxed v2 validation.
+	DisableV2 bool
+	// AllowOriginalDst accepts server-side Listeners with use_original_dst
+	// set, for transparent proxy deployments. They are rejected by default.
+	AllowOriginalDst bool
 	// Resources are the xDS resources resources in the received response.
 	Resources []*anypb.Any
+	// MaxResourceBytes, if positive, is the maximum serialized size of a
+	// resource. Larger resources are rejected without being unmarshaled.
+	MaxResourceBytes int
 	// Logger is the prefix logger to be used during unmarshaling.
 	Logger dubboLogger.Logger
+	// NodeID is the ID of the xDS node the response was received for. If
+	// set, the lines logged with Logger are prefixed with it, to tell apart
+	// the logs of multiple xDS clients in the same process.
+	NodeID string
 	// UpdateValidator is a post unmarshal validation check provided by the
 	// upper layer.
 	UpdateValidator UpdateValidatorFunc
+	// UpdateTransform, if set, is applied to each Listener update after
+	// UpdateValidator accepted it and Raw was set. It is not used for other
+	// resource types.
+	UpdateTransform UpdateTransformFunc
+	// TreatUnknownFiltersAsOptional skips the HTTP filters of Listeners which
+	// have no registered implementation, as if they were optional, instead of
+	// rejecting the Listener. A warning is logged for each skipped filter.
+	//
+	// This is unsafe: a required filter may enforce security, e.g. an
+	// authorization filter, and skipping it silently drops that enforcement.
+	// It is meant for meshes with heterogeneous data planes only.
+	TreatUnknownFiltersAsOptional bool
+	// Metrics, if set, records the resources which fail unmarshaling.
+	Metrics MetricsRecorder
+}
+
+// MetricsRecorder receives the metrics of the unmarshal functions. It must be
+// safe for concurrent use.
+type MetricsRecorder interface {
+	// RecordNACK is called once for every resource which fails unmarshaling
+	// or validation. reason is ReasonUnknown for errors which are not
+	// NACKErrors.
+	RecordNACK(rType ResourceType, reason NACKReason)
+}
+
+// withNodeLogger returns opts with Logger prefixing its lines with the node
+// ID, or opts itself if NodeID is not set. opts is not modified.
+func (opts *UnmarshalOptions) withNodeLogger() *UnmarshalOptions {
+	if opts.NodeID == "" || opts.Logger == nil {
+		return opts
+	}
+	if _, ok := opts.Logger.(*nodeLogger); ok {
+		return opts
+	}
+	o := *opts
+	o.Logger = &nodeLogger{Logger: opts.Logger, prefix: fmt.Sprintf("[xds node %q] ", opts.NodeID)}
+	return &o
+}
+
+// nodeLogger is a dubboLogger.Logger prefixing every line with the node ID.
+type nodeLogger struct {
+	dubboLogger.Logger
+	prefix string
+}
+
+func (l *nodeLogger) Info(args ...interface{})  { l.Logger.Info(l.prefix + fmt.Sprint(args...)) }
+func (l *nodeLogger) Warn(args ...interface{})  { l.Logger.Warn(l.prefix + fmt.Sprint(args...)) }
+func (l *nodeLogger) Error(args ...interface{}) { l.Logger.Error(l.prefix + fmt.Sprint(args...)) }
+func (l *nodeLogger) Debug(args ...interface{}) { l.Logger.Debug(l.prefix + fmt.Sprint(args...)) }
+func (l *nodeLogger) Fatal(args ...interface{}) { l.Logger.Fatal(l.prefix + fmt.Sprint(args...)) }
+
+func (l *nodeLogger) Infof(format string, args ...interface{}) {
+	l.Logger.Info(l.prefix + fmt.Sprintf(format, args...))
+}
+
+func (l *nodeLogger) Warnf(format string, args ...interface{}) {
+	l.Logger.Warn(l.prefix + fmt.Sprintf(format, args...))
+}
+
+func (l *nodeLogger) Errorf(format string, args ...interface{}) {
+	l.Logger.Error(l.prefix + fmt.Sprintf(format, args...))
+}
+
+func (l *nodeLogger) Debugf(format string, args ...interface{}) {
+	l.Logger.Debug(l.prefix + fmt.Sprintf(format, args...))
+}
+
+func (l *nodeLogger) Fatalf(format string, args ...interface{}) {
+	l.Logger.Fatal(l.prefix + fmt.Sprintf(format, args...))
 }
 
 // processAllResources unmarshals and validates the resources, populates the
@@ -58,89 +161,80 @@ type UnmarshalOptions struct {
 //
 // After this function, the ret map will be populated with both valid and
 // invalid updates. Invalid resources will have an entry with the key as the
-// resource name, value as an empty update.
+// resource name, value as an empty update, and the error.
+//
+// An invalid resource doesn't fail the others: the returned error is only
+// non-nil if a resource can't be attributed to a name, which means the
+// response itself is malformed. Errors of named resources are only reported
+// in their entries and in the metadata.
 //
 // The type of the resource is determined by the type of ret. E.g.
 // map[string]ListenerUpdate means this is for LDS.
+//
+// Resources are unmarshaled concurrently, but ret is populated in the order of
+// the resources in the response, so the result doesn't depend on scheduling.
 func processAllResources(opts *UnmarshalOptions, ret interface{}) (UpdateMetadata, error) {
-	timestamp := time.Now()
-	md := UpdateMetadata{
-		Version:   opts.Version,
-		Timestamp: timestamp,
-	}
+	md := newUpdateMetadata(opts)
 	var topLevelErrors []error
 	perResourceErrors := make(map[string]error)
 
-	for _, r := range opts.Resources {
-		switch ret2 := ret.(type) {
-		case map[string]ListenerUpdateErrTuple:
-			name, update, err := unmarshalListenerResource(r, opts.UpdateValidator, opts.Logger)
-			name = ParseName(name).String()
-			if err == nil {
-				ret2[name] = ListenerUpdateErrTuple{Update: update}
-				continue
-			}
+	var (
+		unmarshal func(*anypb.Any) (string, interface{}, error)
+		rType     ResourceType
+	)
+	switch ret.(type) {
+	case map[string]ListenerUpdateErrTuple:
+		rType = ListenerResource
+		unmarshal = func(r *anypb.Any) (string, interface{}, error) {
+			return unmarshalListenerResource(r, opts)
+		}
+	case map[string]RouteConfigUpdateErrTuple:
+		rType = RouteConfigResource
+		unmarshal = func(r *anypb.Any) (string, interface{}, error) {
+			return unmarshalRouteConfigResource(r, opts.Logger, opts.TransportAPI)
+		}
+	case map[string]ClusterUpdateErrTuple:
+		rType = ClusterResource
+		unmarshal = func(r *anypb.Any) (string, interface{}, error) {
+			return unmarshalClusterResource(r, opts.UpdateValidator, opts.Logger, opts.TransportAPI)
+		}
+	case map[string]EndpointsUpdateErrTuple:
+		rType = EndpointsResource
+		unmarshal = func(r *anypb.Any) (string, interface{}, error) {
+			return unmarshalEndpointsResource(r, opts.Logger, opts.TransportAPI)
+		}
+	}
+	if unmarshal != nil {
+		unmarshal = wrapUnmarshal(rType, opts, unmarshal)
+	}
+
+	for _, res := range unmarshalConcurrently(opts.Resources, unmarshal) {
+		name := ParseName(res.name).String()
+		if res.err != nil {
 			if name == "" {
-				topLevelErrors = append(topLevelErrors, err)
+				topLevelErrors = append(topLevelErrors, res.err)
 				continue
 			}
-			perResourceErrors[name] = err
-			// Add place holder in the map so we know this resource name was in
-			// the response.
-			ret2[name] = ListenerUpdateErrTuple{Err: err}
+			perResourceErrors[name] = res.err
+		}
+		// Invalid resources get a place holder in the map so we know this
+		// resource name was in the response.
+		switch ret2 := ret.(type) {
+		case map[string]ListenerUpdateErrTuple:
+			u, _ := res.update.(ListenerUpdate)
+			ret2[name] = ListenerUpdateErrTuple{Update: u, Err: res.err}
 		case map[string]RouteConfigUpdateErrTuple:
-			name, update, err := unmarshalRouteConfigResource(r, opts.Logger)
-			name = ParseName(name).String()
-			if err == nil {
-				ret2[name] = RouteConfigUpdateErrTuple{Update: update}
-				continue
-			}
-			if name == "" {
-				topLevelErrors = append(topLevelErrors, err)
-				continue
-			}
-			perResourceErrors[name] = err
-			// Add place holder in the map so we know this resource name was in
-			// the response.
-			ret2[name] = RouteConfigUpdateErrTuple{Err: err}
+			u, _ := res.update.(RouteConfigUpdate)
+			ret2[name] = RouteConfigUpdateErrTuple{Update: u, Err: res.err}
 		case map[string]ClusterUpdateErrTuple:
-			name, update, err := unmarshalClusterResource(r, opts.UpdateValidator, opts.Logger)
-			name = ParseName(name).String()
-			if err == nil {
-				ret2[name] = ClusterUpdateErrTuple{Update: update}
-				continue
-			}
-			if name == "" {
-				topLevelErrors = append(topLevelErrors, err)
-				continue
-			}
-			perResourceErrors[name] = err
-			// Add place holder in the map so we know this resource name was in
-			// the response.
-			ret2[name] = ClusterUpdateErrTuple{Err: err}
+			u, _ := res.update.(ClusterUpdate)
+			ret2[name] = ClusterUpdateErrTuple{Update: u, Err: res.err}
 		case map[string]EndpointsUpdateErrTuple:
-			name, update, err := unmarshalEndpointsResource(r, opts.Logger)
-			name = ParseName(name).String()
-			if err == nil {
-				ret2[name] = EndpointsUpdateErrTuple{Update: update}
-				continue
-			}
-			if name == "" {
-				topLevelErrors = append(topLevelErrors, err)
-				continue
-			}
-			perResourceErrors[name] = err
-			// Add place holder in the map so we know this resource name was in
-			// the response.
-			ret2[name] = EndpointsUpdateErrTuple{Err: err}
+			u, _ := res.update.(EndpointsUpdate)
+			ret2[name] = EndpointsUpdateErrTuple{Update: u, Err: res.err}
 		}
 	}
 
-	if len(topLevelErrors) == 0 && len(perResourceErrors) == 0 {
-		md.Status = ServiceStatusACKed
-		return md, nil
-	}
-
 	var typeStr string
 	switch ret.(type) {
 	case map[string]ListenerUpdate:
@@ -152,13 +246,113 @@ func processAllResources(opts *UnmarshalOptions, ret interface{}) (UpdateMetadat
 	case map[string]EndpointsUpdate:
 		typeStr = "EDS"
 	}
+	return resultMetadata(md, typeStr, topLevelErrors, perResourceErrors)
+}
+
+// isV2Resource reports whether typeURL, the type URL of a resource received on
+// the api transport, is the v2 URL v2URL. v3 transports only carry v3
+// resources, so a v2 URL on one is an error.
+func isV2Resource(api version.TransportAPI, typeURL, v2URL string) (bool, error) {
+	if typeURL != v2URL {
+		return false, nil
+	}
+	if api == version.TransportV3 {
+		return false, fmt.Errorf("unexpected v2 resource type %q on a v3 transport", typeURL)
+	}
+	return true, nil
+}
+
+type unmarshalResult struct {
+	name   string
+	update interface{}
+	err    error
+}
+
+// unmarshalConcurrently applies unmarshal to the resources on up to GOMAXPROCS
+// goroutines, and returns the results in the order of the resources. An error
+// for one resource doesn't stop the others from being processed.
+func unmarshalConcurrently(resources []*anypb.Any, unmarshal func(*anypb.Any) (string, interface{}, error)) []unmarshalResult {
+	results := make([]unmarshalResult, len(resources))
+	if unmarshal == nil {
+		return nil
+	}
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(resources) {
+		workers = len(resources)
+	}
+	var (
+		wg   sync.WaitGroup
+		next int64 = -1
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				j := int(atomic.AddInt64(&next, 1))
+				if j >= len(resources) {
+					return
+				}
+				name, update, err := unmarshal(resources[j])
+				results[j] = unmarshalResult{name: name, update: update, err: err}
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// newUpdateMetadata returns the metadata for the response described by opts,
+// timestamped now.
+func newUpdateMetadata(opts *UnmarshalOptions) UpdateMetadata {
+	return UpdateMetadata{
+		Version:   opts.Version,
+		Nonce:     opts.Nonce,
+		Timestamp: time.Now(),
+		Stale:     opts.Version != "" && opts.Version == opts.AckedVersion,
+	}
+}
+
+// notFoundNames returns the names of opts.ResourceNames for which present
+// returns false. It returns nil if the wildcard "*" was requested.
+func notFoundNames(opts *UnmarshalOptions, present func(name string) bool) []string {
+	var ret []string
+	for _, n := range opts.ResourceNames {
+		if n == "*" {
+			return nil
+		}
+		if name := ParseName(n).String(); !present(name) {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+// errNotFound returns the error of the requested resource name of type rType
+// missing from a response.
+func errNotFound(rType ResourceType, name string) error {
+	return NewErrorf(ErrorTypeResourceNotFound, "xds: %v %q not found in response", rType, name)
+}
+
+// resultMetadata sets the status of md from the errors found while processing
+// the resources. Any error NACKs the resources in md, but the combined error
+// is only returned if there are top level errors, see processAllResources.
+// The response is NACKed on the wire whenever md.ErrState is set.
+func resultMetadata(md UpdateMetadata, rType string, topLevelErrors []error, perResourceErrors map[string]error) (UpdateMetadata, error) {
+	if len(topLevelErrors) == 0 && len(perResourceErrors) == 0 {
+		md.Status = ServiceStatusACKed
+		return md, nil
+	}
 
 	md.Status = ServiceStatusNACKed
-	errRet := combineErrors(typeStr, topLevelErrors, perResourceErrors)
+	errRet := combineErrors(rType, topLevelErrors, perResourceErrors)
 	md.ErrState = &UpdateErrorMetadata{
-		Version:   opts.Version,
+		Version:   md.Version,
 		Err:       errRet,
-		Timestamp: timestamp,
+		Timestamp: md.Timestamp,
+	}
+	if len(topLevelErrors) == 0 {
+		return md, nil
 	}
 	return md, errRet
 }
@@ -187,3 +381,86 @@ func combineErrors(rType string, topLevelErrors []error, perResourceErrors map[s
 	}
 	return errors.New(errStrB.String())
 }
+
+// wrapUnmarshal wraps the unmarshal function of the resources of type rType
+// with what applies to every response whatever the entry point: the
+// registered resource types, the MaxResourceBytes limit, panic recovery and
+// the NACK metrics.
+func wrapUnmarshal(rType ResourceType, opts *UnmarshalOptions, unmarshal func(*anypb.Any) (string, interface{}, error)) func(*anypb.Any) (string, interface{}, error) {
+	if len(registeredTypes) != 0 {
+		unmarshal = withRegisteredTypes(rType, opts, unmarshal)
+	}
+	if max := opts.MaxResourceBytes; max > 0 {
+		inner := unmarshal
+		unmarshal = func(r *anypb.Any) (string, interface{}, error) {
+			if n := len(r.GetValue()); n > max {
+				name := resourceNameFromBytes(r.GetValue())
+				return name, nil, annotateNACKError(nackErrorf(ReasonResourceTooLarge, "resource of %d bytes exceeds the limit of %d bytes", n, max), rType, name)
+			}
+			return inner(r)
+		}
+	}
+	unmarshal = withRecover(rType, unmarshal)
+	if m := opts.Metrics; m != nil {
+		inner := unmarshal
+		unmarshal = func(r *anypb.Any) (string, interface{}, error) {
+			name, update, err := inner(r)
+			if err != nil {
+				m.RecordNACK(rType, NACKReasonOf(err))
+			}
+			return name, update, err
+		}
+	}
+	return unmarshal
+}
+
+// withRecover wraps unmarshal so that a panic while processing a resource
+// NACKs that resource, attributed by resourceNameFromBytes, instead of
+// crashing the client.
+func withRecover(rType ResourceType, unmarshal func(*anypb.Any) (string, interface{}, error)) func(*anypb.Any) (string, interface{}, error) {
+	return func(r *anypb.Any) (name string, update interface{}, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				name = resourceNameFromBytes(r.GetValue())
+				update = nil
+				err = annotateNACKError(nackErrorf(ReasonPanic, "panic while processing resource: %v", p), rType, name)
+			}
+		}()
+		return unmarshal(r)
+	}
+}
+
+// resourceNameFromBytes decodes only the name field of the serialized
+// resource b. It is used to attribute an unmarshal failure to the right
+// resource, and returns "" if the name can't be recovered.
+func resourceNameFromBytes(b []byte) string {
+	// Listener.name, RouteConfiguration.name, Cluster.name and
+	// ClusterLoadAssignment.cluster_name are all field 1.
+	const nameField protowire.Number = 1
+	var name string
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return name
+		}
+		b = b[n:]
+		if num == nameField && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return name
+			}
+			// As with any scalar field, the last occurrence wins.
+			if utf8.Valid(v) {
+				name = string(v)
+			}
+			b = b[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return name
+		}
+		b = b[n:]
+	}
+	return name
+}
@@ -28,6 +28,7 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"time"
 )
 
 import (
@@ -35,6 +36,7 @@ import (
 	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	v3aggregateclusterpb "github.com/envoyproxy/go-control-plane/envoy/extensions/clusters/aggregate/v3"
 	v3tlspb "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	v3httpoptionspb "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
 
 	"github.com/golang/protobuf/proto"
 
@@ -53,25 +55,48 @@ import (
 // to this value by the management server.
 const transportSocketName = "envoy.transport_sockets.tls"
 
+// rawBufferTransportSocketName is the name of the plaintext transport socket.
+const rawBufferTransportSocketName = "envoy.transport_sockets.raw_buffer"
+
+// errUnsupportedTransportSocket is wrapped by the errors about transport
+// sockets of a type other than TLS and raw buffer.
+var errUnsupportedTransportSocket = errors.New("unsupported transport socket")
+
+const (
+	// httpProtocolOptionsName is the key of the HTTP protocol options in the
+	// typed_extension_protocol_options of a cluster.
+	httpProtocolOptionsName    = "envoy.extensions.upstreams.http.v3.HttpProtocolOptions"
+	httpProtocolOptionsTypeURL = "type.googleapis.com/" + httpProtocolOptionsName
+)
+
 // UnmarshalCluster processes resources received in an CDS response, validates
 // them, and transforms them into a native struct which contains only fields we
 // are interested in.
 func UnmarshalCluster(opts *UnmarshalOptions) (map[string]ClusterUpdateErrTuple, UpdateMetadata, error) {
+	opts = opts.withNodeLogger()
 	update := make(map[string]ClusterUpdateErrTuple)
 	md, err := processAllResources(opts, update)
+	if err == nil {
+		for _, name := range notFoundNames(opts, func(name string) bool { _, ok := update[name]; return ok }) {
+			update[name] = ClusterUpdateErrTuple{Err: errNotFound(ClusterResource, name)}
+		}
+	}
 	return update, md, err
 }
 
-func unmarshalClusterResource(r *anypb.Any, f UpdateValidatorFunc, logger dubboLogger.Logger) (string, ClusterUpdate, error) {
-	if !IsClusterResource(r.GetTypeUrl()) {
-		return "", ClusterUpdate{}, fmt.Errorf("unexpected resource type: %q ", r.GetTypeUrl())
+func unmarshalClusterResource(r *anypb.Any, f UpdateValidatorFunc, logger dubboLogger.Logger, api version.TransportAPI) (string, ClusterUpdate, error) {
+	if err := ValidateResourceType(r.GetTypeUrl(), ClusterResource); err != nil {
+		return "", ClusterUpdate{}, err
+	}
+	if _, err := isV2Resource(api, r.GetTypeUrl(), version.V2ClusterURL); err != nil {
+		return "", ClusterUpdate{}, err
 	}
 
 	cluster := &v3clusterpb.Cluster{}
 	if err := proto.Unmarshal(r.GetValue(), cluster); err != nil {
 		return "", ClusterUpdate{}, fmt.Errorf("failed to unmarshal resource: %v", err)
 	}
-	dubboLogger.Debugf("Resource with name: %v, type: %T, contains: %v", cluster.GetName(), cluster, pretty.ToJSON(cluster))
+	logger.Debugf("Resource with name: %v, type: %T, contains: %v", cluster.GetName(), cluster, pretty.Lazy(cluster))
 	cu, err := validateClusterAndConstructClusterUpdate(cluster)
 	if err != nil {
 		return cluster.GetName(), ClusterUpdate{}, err
@@ -86,6 +111,19 @@ func unmarshalClusterResource(r *anypb.Any, f UpdateValidatorFunc, logger dubboL
 	return cluster.GetName(), cu, nil
 }
 
+const (
+	defaultCircuitBreakerMaxRequests        = 1024
+	defaultCircuitBreakerMaxPendingRequests = 1024
+)
+
+const (
+	defaultOutlierDetectionInterval                  = 10 * time.Second
+	defaultOutlierDetectionBaseEjectionTime          = 30 * time.Second
+	defaultOutlierDetectionMaxEjectionPercent        = 10
+	defaultOutlierDetectionConsecutive5xx            = 5
+	defaultOutlierDetectionConsecutiveGatewayFailure = 5
+)
+
 const (
 	defaultRingHashMinSize = 1024
 	defaultRingHashMaxSize = 8 * 1024 * 1024 // 8M
@@ -132,12 +170,24 @@ func validateClusterAndConstructClusterUpdate(cluster *v3clusterpb.Cluster) (Clu
 
 	// Process security configuration received from the control plane iff the
 	// corresponding environment variable is set.
-	var sc *SecurityConfig
+	var (
+		sc      *SecurityConfig
+		matches []TransportSocketMatch
+	)
 	if envconfig.XDSClientSideSecurity {
 		var err error
 		if sc, err = securityConfigFromCluster(cluster); err != nil {
 			return ClusterUpdate{}, err
 		}
+		if matches, err = transportSocketMatchesFromCluster(cluster); err != nil {
+			return ClusterUpdate{}, err
+		}
+	}
+
+	// The LRS server may only be the management server that sent this
+	// cluster resource, which is expressed by the `self` ConfigSource.
+	if lrs := cluster.GetLrsServer(); lrs != nil && lrs.GetSelf() == nil {
+		return ClusterUpdate{}, fmt.Errorf("unsupported config_source_specifier %T in lrs_server field in response: %+v", lrs.GetConfigSourceSpecifier(), cluster)
 	}
 
 	ret := ClusterUpdate{
@@ -147,6 +197,19 @@ func validateClusterAndConstructClusterUpdate(cluster *v3clusterpb.Cluster) (Clu
 		MaxRequests: circuitBreakersFromCluster(cluster),
 		LBPolicy:    lbPolicy,
 	}
+	ret.TransportSocketMatches = matches
+	ret.CircuitBreakers = circuitBreakerThresholdsFromCluster(cluster)
+	od, err := outlierDetectionFromCluster(cluster)
+	if err != nil {
+		return ClusterUpdate{}, err
+	}
+	ret.OutlierDetection = od
+	if ret.HTTP2Config, err = http2ConfigFromCluster(cluster); err != nil {
+		return ClusterUpdate{}, err
+	}
+	if ret.SubsetConfig, err = subsetConfigFromCluster(cluster); err != nil {
+		return ClusterUpdate{}, err
+	}
 
 	// Validate and set cluster type from the response.
 	// todo @laurence this set cluster
@@ -171,6 +234,9 @@ func validateClusterAndConstructClusterUpdate(cluster *v3clusterpb.Cluster) (Clu
 			return ClusterUpdate{}, err
 		}
 		ret.DNSHostName = dnsHN
+		if err := dnsResolutionFromCluster(cluster, &ret); err != nil {
+			return ClusterUpdate{}, err
+		}
 		return ret, nil
 	case cluster.GetClusterType() != nil && cluster.GetClusterType().Name == "envoy.clusters.aggregate":
 		if !envconfig.XDSAggregateAndDNS {
@@ -180,6 +246,12 @@ func validateClusterAndConstructClusterUpdate(cluster *v3clusterpb.Cluster) (Clu
 		if err := proto.Unmarshal(cluster.GetClusterType().GetTypedConfig().GetValue(), clusters); err != nil {
 			return ClusterUpdate{}, fmt.Errorf("failed to unmarshal resource: %v", err)
 		}
+		if len(clusters.GetClusters()) == 0 {
+			return ClusterUpdate{}, fmt.Errorf("aggregate cluster contains no child clusters in response: %+v", cluster)
+		}
+		if cluster.GetEdsClusterConfig() != nil {
+			return ClusterUpdate{}, fmt.Errorf("aggregate cluster must not set eds_cluster_config in response: %+v", cluster)
+		}
 		ret.ClusterType = ClusterTypeAggregate
 		ret.PrioritizedClusterNames = clusters.Clusters
 		return ret, nil
@@ -188,6 +260,163 @@ func validateClusterAndConstructClusterUpdate(cluster *v3clusterpb.Cluster) (Clu
 	}
 }
 
+// dnsResolutionFromCluster sets the DNS resolution options of the LOGICAL_DNS
+// cluster in ret. A dns_refresh_rate set along with respect_dns_ttl, which
+// Envoy only warns about, is rejected.
+func dnsResolutionFromCluster(cluster *v3clusterpb.Cluster, ret *ClusterUpdate) error {
+	ret.DNSRefreshRate = defaultDNSRefreshRate
+	if rr := cluster.GetDnsRefreshRate(); rr != nil {
+		if err := rr.CheckValid(); err != nil {
+			return fmt.Errorf("invalid dns_refresh_rate in response: %+v: %v", cluster, err)
+		}
+		switch d := rr.AsDuration(); {
+		case d == 0:
+			// Zero is the same as unset.
+		case cluster.GetRespectDnsTtl():
+			return fmt.Errorf("dns_refresh_rate %v conflicts with respect_dns_ttl in response: %+v", d, cluster)
+		case d < time.Millisecond:
+			return fmt.Errorf("dns_refresh_rate %v is less than 1ms in response: %+v", d, cluster)
+		default:
+			ret.DNSRefreshRate = d
+		}
+	}
+	ret.RespectDNSTTL = cluster.GetRespectDnsTtl()
+	switch f := cluster.GetDnsLookupFamily(); f {
+	case v3clusterpb.Cluster_AUTO:
+		ret.DNSLookupFamily = DNSLookupFamilyAuto
+	case v3clusterpb.Cluster_V4_ONLY:
+		ret.DNSLookupFamily = DNSLookupFamilyV4Only
+	case v3clusterpb.Cluster_V6_ONLY:
+		ret.DNSLookupFamily = DNSLookupFamilyV6Only
+	case v3clusterpb.Cluster_V4_PREFERRED:
+		ret.DNSLookupFamily = DNSLookupFamilyV4Preferred
+	case v3clusterpb.Cluster_ALL:
+		ret.DNSLookupFamily = DNSLookupFamilyAll
+	default:
+		return fmt.Errorf("unsupported dns_lookup_family %v in response: %+v", f, cluster)
+	}
+	return nil
+}
+
+// subsetConfigFromCluster extracts the lb_subset_config of the cluster. A
+// selector without keys, or whose keys fallback isn't a non-empty subset of
+// its keys, is rejected.
+func subsetConfigFromCluster(cluster *v3clusterpb.Cluster) (*SubsetConfig, error) {
+	lsc := cluster.GetLbSubsetConfig()
+	if lsc == nil {
+		return nil, nil
+	}
+	ret := &SubsetConfig{DefaultSubset: lsc.GetDefaultSubset()}
+	switch p := lsc.GetFallbackPolicy(); p {
+	case v3clusterpb.Cluster_LbSubsetConfig_NO_FALLBACK:
+		ret.FallbackPolicy = SubsetFallbackNone
+	case v3clusterpb.Cluster_LbSubsetConfig_ANY_ENDPOINT:
+		ret.FallbackPolicy = SubsetFallbackAnyEndpoint
+	case v3clusterpb.Cluster_LbSubsetConfig_DEFAULT_SUBSET:
+		ret.FallbackPolicy = SubsetFallbackDefaultSubset
+	default:
+		return nil, fmt.Errorf("lb_subset_config: unsupported fallback_policy %v in response: %+v", p, cluster)
+	}
+	for i, s := range lsc.GetSubsetSelectors() {
+		if len(s.GetKeys()) == 0 {
+			return nil, fmt.Errorf("lb_subset_config: subset selector %d has no keys in response: %+v", i, cluster)
+		}
+		sel := SubsetSelector{
+			Keys:                s.GetKeys(),
+			SingleHostPerSubset: s.GetSingleHostPerSubset(),
+		}
+		switch p := s.GetFallbackPolicy(); p {
+		case v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector_NOT_DEFINED:
+			sel.FallbackPolicy = ret.FallbackPolicy
+		case v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector_NO_FALLBACK:
+			sel.FallbackPolicy = SubsetFallbackNone
+		case v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector_ANY_ENDPOINT:
+			sel.FallbackPolicy = SubsetFallbackAnyEndpoint
+		case v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector_DEFAULT_SUBSET:
+			sel.FallbackPolicy = SubsetFallbackDefaultSubset
+		case v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector_KEYS_SUBSET:
+			sel.FallbackPolicy = SubsetFallbackKeysSubset
+			if err := validateFallbackKeysSubset(s.GetFallbackKeysSubset(), s.GetKeys()); err != nil {
+				return nil, fmt.Errorf("lb_subset_config: subset selector %d: %v in response: %+v", i, err, cluster)
+			}
+			sel.FallbackKeysSubset = s.GetFallbackKeysSubset()
+		default:
+			return nil, fmt.Errorf("lb_subset_config: subset selector %d has unsupported fallback_policy %v in response: %+v", i, p, cluster)
+		}
+		ret.Selectors = append(ret.Selectors, sel)
+	}
+	return ret, nil
+}
+
+// validateFallbackKeysSubset checks that the fallback_keys_subset of a
+// selector is a non-empty subset of its keys.
+func validateFallbackKeysSubset(fallback, keys []string) error {
+	if len(fallback) == 0 {
+		return fmt.Errorf("fallback_keys_subset is empty")
+	}
+	for _, f := range fallback {
+		found := false
+		for _, k := range keys {
+			if f == k {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("fallback_keys_subset key %q is not in keys %v", f, keys)
+		}
+	}
+	return nil
+}
+
+// http2ConfigFromCluster extracts the HTTP/2 protocol options of the cluster.
+// gRPC requires HTTP/2, so clusters explicitly configured for another HTTP
+// version are rejected.
+func http2ConfigFromCluster(cluster *v3clusterpb.Cluster) (*HTTP2Config, error) {
+	any, ok := cluster.GetTypedExtensionProtocolOptions()[httpProtocolOptionsName]
+	if !ok {
+		return nil, nil
+	}
+	if any.GetTypeUrl() != httpProtocolOptionsTypeURL {
+		return nil, fmt.Errorf("unexpected type %q for %s in response: %+v", any.GetTypeUrl(), httpProtocolOptionsName, cluster)
+	}
+	opts := &v3httpoptionspb.HttpProtocolOptions{}
+	if err := proto.Unmarshal(any.GetValue(), opts); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %v", httpProtocolOptionsName, err)
+	}
+	var h2 *v3corepb.Http2ProtocolOptions
+	switch upo := opts.GetUpstreamProtocolOptions().(type) {
+	case *v3httpoptionspb.HttpProtocolOptions_ExplicitHttpConfig_:
+		h2 = upo.ExplicitHttpConfig.GetHttp2ProtocolOptions()
+		if h2 == nil {
+			return nil, fmt.Errorf("cluster %q explicitly configures %T, but gRPC requires HTTP/2", cluster.GetName(), upo.ExplicitHttpConfig.GetProtocolConfig())
+		}
+	case *v3httpoptionspb.HttpProtocolOptions_UseDownstreamProtocolConfig:
+		h2 = upo.UseDownstreamProtocolConfig.GetHttp2ProtocolOptions()
+	case *v3httpoptionspb.HttpProtocolOptions_AutoConfig:
+		h2 = upo.AutoConfig.GetHttp2ProtocolOptions()
+	}
+	if h2 == nil {
+		return nil, nil
+	}
+	ret := &HTTP2Config{
+		MaxConcurrentStreams:        h2.GetMaxConcurrentStreams().GetValue(),
+		InitialStreamWindowSize:     h2.GetInitialStreamWindowSize().GetValue(),
+		InitialConnectionWindowSize: h2.GetInitialConnectionWindowSize().GetValue(),
+	}
+	// The ranges are those documented for Http2ProtocolOptions.
+	if v := h2.GetMaxConcurrentStreams(); v != nil && (v.GetValue() < 1 || v.GetValue() > 2147483647) {
+		return nil, fmt.Errorf("http2 max_concurrent_streams %d out of range in response: %+v", v.GetValue(), cluster)
+	}
+	if v := h2.GetInitialStreamWindowSize(); v != nil && (v.GetValue() < 65535 || v.GetValue() > 2147483647) {
+		return nil, fmt.Errorf("http2 initial_stream_window_size %d out of range in response: %+v", v.GetValue(), cluster)
+	}
+	if v := h2.GetInitialConnectionWindowSize(); v != nil && (v.GetValue() < 65535 || v.GetValue() > 2147483647) {
+		return nil, fmt.Errorf("http2 initial_connection_window_size %d out of range in response: %+v", v.GetValue(), cluster)
+	}
+	return ret, nil
+}
+
 // dnsHostNameFromCluster extracts the DNS host name from the cluster's load
 // assignment.
 //
@@ -230,9 +459,6 @@ func dnsHostNameFromCluster(cluster *v3clusterpb.Cluster) (string, error) {
 // securityConfigFromCluster extracts the relevant security configuration from
 // the received Cluster resource.
 func securityConfigFromCluster(cluster *v3clusterpb.Cluster) (*SecurityConfig, error) {
-	if tsm := cluster.GetTransportSocketMatches(); len(tsm) != 0 {
-		return nil, fmt.Errorf("unsupport transport_socket_matches field is non-empty: %+v", tsm)
-	}
 	// The Cluster resource contains a `transport_socket` field, which contains
 	// a oneof `typed_config` field of type `protobuf.Any`. The any proto
 	// contains a marshaled representation of an `UpstreamTlsContext` message.
@@ -240,26 +466,68 @@ func securityConfigFromCluster(cluster *v3clusterpb.Cluster) (*SecurityConfig, e
 	if ts == nil {
 		return nil, nil
 	}
+	return securityConfigFromTransportSocket(ts)
+}
+
+// transportSocketMatchesFromCluster extracts the transport_socket_matches of
+// the cluster. A match with an unsupported transport socket falls back to the
+// cluster's transport_socket, so it is only rejected if the cluster has none.
+func transportSocketMatchesFromCluster(cluster *v3clusterpb.Cluster) ([]TransportSocketMatch, error) {
+	tsms := cluster.GetTransportSocketMatches()
+	if len(tsms) == 0 {
+		return nil, nil
+	}
+	ret := make([]TransportSocketMatch, 0, len(tsms))
+	for _, tsm := range tsms {
+		m := TransportSocketMatch{Name: tsm.GetName(), Match: tsm.GetMatch()}
+		sc, err := securityConfigFromTransportSocket(tsm.GetTransportSocket())
+		switch {
+		case errors.Is(err, errUnsupportedTransportSocket) && cluster.GetTransportSocket() != nil:
+			m.UseClusterDefault = true
+		case err != nil:
+			return nil, fmt.Errorf("transport_socket_matches entry %q: %v", tsm.GetName(), err)
+		default:
+			m.SecurityCfg = sc
+		}
+		ret = append(ret, m)
+	}
+	return ret, nil
+}
+
+// securityConfigFromTransportSocket extracts the security configuration from
+// a TLS transport socket. It returns nil for a raw buffer transport socket.
+func securityConfigFromTransportSocket(ts *v3corepb.TransportSocket) (*SecurityConfig, error) {
+	if ts.GetName() == rawBufferTransportSocketName {
+		return nil, nil
+	}
 	if name := ts.GetName(); name != transportSocketName {
-		return nil, fmt.Errorf("transport_socket field has unexpected name: %s", name)
+		return nil, fmt.Errorf("transport_socket field has unexpected name: %s: %w", name, errUnsupportedTransportSocket)
 	}
 	any := ts.GetTypedConfig()
 	if any == nil || any.TypeUrl != version.V3UpstreamTLSContextURL {
-		return nil, fmt.Errorf("transport_socket field has unexpected typeURL: %s", any.TypeUrl)
+		return nil, fmt.Errorf("transport_socket field has unexpected typeURL: %s: %w", any.GetTypeUrl(), errUnsupportedTransportSocket)
 	}
 	upstreamCtx := &v3tlspb.UpstreamTlsContext{}
 	if err := proto.Unmarshal(any.GetValue(), upstreamCtx); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal UpstreamTlsContext in CDS response: %v", err)
 	}
 	// The following fields from `UpstreamTlsContext` are ignored:
-	// - sni
 	// - allow_renegotiation
 	// - max_session_keys
 	if upstreamCtx.GetCommonTlsContext() == nil {
 		return nil, errors.New("UpstreamTlsContext in CDS response does not contain a CommonTlsContext")
 	}
+	// The limit is the one documented for the sni field.
+	if sni := upstreamCtx.GetSni(); len(sni) > 255 {
+		return nil, fmt.Errorf("sni field in UpstreamTlsContext is longer than 255 bytes: %q", sni)
+	}
 
-	return securityConfigFromCommonTLSContext(upstreamCtx.GetCommonTlsContext(), false)
+	sc, err := securityConfigFromCommonTLSContext(upstreamCtx.GetCommonTlsContext(), false)
+	if err != nil || sc == nil {
+		return sc, err
+	}
+	sc.SNI = upstreamCtx.GetSni()
+	return sc, nil
 }
 
 // common is expected to be not nil.
@@ -271,6 +539,15 @@ func securityConfigFromCommonTLSContext(common *v3tlspb.CommonTlsContext, server
 	if common.GetCustomHandshaker() != nil {
 		return nil, fmt.Errorf("unsupported custom_handshaker field in CommonTlsContext message: %+v", common)
 	}
+	// Root certificates can only be fetched from certificate provider
+	// instances, so a combined validation context has to reference one instead
+	// of an SDS secret. This is checked upfront as the errors from the new
+	// fields are not reported.
+	if combined := common.GetCombinedValidationContext(); combined.GetValidationContextSdsSecretConfig() != nil &&
+		combined.GetValidationContextCertificateProviderInstance() == nil &&
+		combined.GetDefaultValidationContext().GetCaCertificateProviderInstance() == nil {
+		return nil, fmt.Errorf("unsupported validation_context_sds_secret_config field in CombinedCertificateValidationContext message: %+v", common)
+	}
 
 	// For now, if we can't get a valid security config from the new fields, we
 	// fallback to the old deprecated fields.
@@ -473,3 +750,74 @@ func circuitBreakersFromCluster(cluster *v3clusterpb.Cluster) *uint32 {
 	}
 	return nil
 }
+
+// circuitBreakerThresholdsFromCluster extracts the circuit breaking thresholds
+// for the DEFAULT and HIGH routing priorities from the received cluster
+// resource, applying defaults to unset fields. Returns nil if there are no
+// thresholds in CircuitBreakers.
+func circuitBreakerThresholdsFromCluster(cluster *v3clusterpb.Cluster) map[RoutingPriority]CircuitBreakerThresholds {
+	thresholds := cluster.GetCircuitBreakers().GetThresholds()
+	if len(thresholds) == 0 {
+		return nil
+	}
+	ret := make(map[RoutingPriority]CircuitBreakerThresholds, len(thresholds))
+	for _, threshold := range thresholds {
+		var priority RoutingPriority
+		switch threshold.GetPriority() {
+		case v3corepb.RoutingPriority_DEFAULT:
+			priority = RoutingPriorityDefault
+		case v3corepb.RoutingPriority_HIGH:
+			priority = RoutingPriorityHigh
+		default:
+			continue
+		}
+		t := CircuitBreakerThresholds{
+			MaxRequests:        defaultCircuitBreakerMaxRequests,
+			MaxPendingRequests: defaultCircuitBreakerMaxPendingRequests,
+		}
+		if mr := threshold.GetMaxRequests(); mr != nil {
+			t.MaxRequests = mr.GetValue()
+		}
+		if mpr := threshold.GetMaxPendingRequests(); mpr != nil {
+			t.MaxPendingRequests = mpr.GetValue()
+		}
+		ret[priority] = t
+	}
+	return ret
+}
+
+// outlierDetectionFromCluster extracts the outlier detection configuration
+// from the received cluster resource, applying the Envoy defaults to unset
+// fields. Returns nil if outlier detection is not configured.
+func outlierDetectionFromCluster(cluster *v3clusterpb.Cluster) (*OutlierDetection, error) {
+	od := cluster.GetOutlierDetection()
+	if od == nil {
+		return nil, nil
+	}
+	ret := &OutlierDetection{
+		Interval:                  defaultOutlierDetectionInterval,
+		BaseEjectionTime:          defaultOutlierDetectionBaseEjectionTime,
+		MaxEjectionPercent:        defaultOutlierDetectionMaxEjectionPercent,
+		Consecutive5xx:            defaultOutlierDetectionConsecutive5xx,
+		ConsecutiveGatewayFailure: defaultOutlierDetectionConsecutiveGatewayFailure,
+	}
+	if i := od.GetInterval(); i != nil {
+		ret.Interval = i.AsDuration()
+	}
+	if bet := od.GetBaseEjectionTime(); bet != nil {
+		ret.BaseEjectionTime = bet.AsDuration()
+	}
+	if mep := od.GetMaxEjectionPercent(); mep != nil {
+		if mep.GetValue() > 100 {
+			return nil, fmt.Errorf("outlier_detection.max_ejection_percent = %v; must be <= 100", mep.GetValue())
+		}
+		ret.MaxEjectionPercent = mep.GetValue()
+	}
+	if c := od.GetConsecutive_5Xx(); c != nil {
+		ret.Consecutive5xx = c.GetValue()
+	}
+	if c := od.GetConsecutiveGatewayFailure(); c != nil {
+		ret.ConsecutiveGatewayFailure = c.GetValue()
+	}
+	return ret, nil
+}
@@ -0,0 +1,584 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+import (
+	v3clusterpb "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3aggregateclusterpb "github.com/envoyproxy/go-control-plane/envoy/extensions/clusters/aggregate/v3"
+	v3tlspb "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	v3httpoptionspb "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+import (
+	"dubbo.apache.org/dubbo-go/v3/xds/utils/envconfig"
+)
+
+// edsCluster returns an EDS cluster fetching its endpoints on the ADS stream.
+func edsCluster(name string) *v3clusterpb.Cluster {
+	return &v3clusterpb.Cluster{
+		Name:                 name,
+		ClusterDiscoveryType: &v3clusterpb.Cluster_Type{Type: v3clusterpb.Cluster_EDS},
+		EdsClusterConfig: &v3clusterpb.Cluster_EdsClusterConfig{
+			EdsConfig: &v3corepb.ConfigSource{
+				ConfigSourceSpecifier: &v3corepb.ConfigSource_Ads{Ads: &v3corepb.AggregatedConfigSource{}},
+			},
+		},
+	}
+}
+
+func marshalAny(t *testing.T, m proto.Message) *anypb.Any {
+	t.Helper()
+	a, err := ptypes.MarshalAny(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestValidateClusterAggregate(t *testing.T) {
+	oldAggregateAndDNS := envconfig.XDSAggregateAndDNS
+	envconfig.XDSAggregateAndDNS = true
+	defer func() { envconfig.XDSAggregateAndDNS = oldAggregateAndDNS }()
+
+	aggregate := func(t *testing.T, children ...string) *v3clusterpb.Cluster {
+		return &v3clusterpb.Cluster{
+			Name: "aggregate",
+			ClusterDiscoveryType: &v3clusterpb.Cluster_ClusterType{ClusterType: &v3clusterpb.Cluster_CustomClusterType{
+				Name:        "envoy.clusters.aggregate",
+				TypedConfig: marshalAny(t, &v3aggregateclusterpb.ClusterConfig{Clusters: children}),
+			}},
+		}
+	}
+
+	cu, err := validateClusterAndConstructClusterUpdate(aggregate(t, "primary", "secondary"))
+	if err != nil {
+		t.Fatalf("validateClusterAndConstructClusterUpdate() failed: %v", err)
+	}
+	if cu.ClusterType != ClusterTypeAggregate || !reflect.DeepEqual(cu.PrioritizedClusterNames, []string{"primary", "secondary"}) {
+		t.Errorf("validateClusterAndConstructClusterUpdate() = %+v, want an aggregate cluster of primary and secondary", cu)
+	}
+
+	if _, err := validateClusterAndConstructClusterUpdate(aggregate(t)); err == nil {
+		t.Error("validateClusterAndConstructClusterUpdate() succeeded with an aggregate cluster without children, want an error")
+	}
+	withEDS := aggregate(t, "primary")
+	withEDS.EdsClusterConfig = edsCluster("").EdsClusterConfig
+	if _, err := validateClusterAndConstructClusterUpdate(withEDS); err == nil {
+		t.Error("validateClusterAndConstructClusterUpdate() succeeded with an aggregate cluster setting eds_cluster_config, want an error")
+	}
+}
+
+func TestValidateClusterLRSServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		lrs     *v3corepb.ConfigSource
+		want    bool
+		wantErr bool
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name: "self",
+			lrs:  &v3corepb.ConfigSource{ConfigSourceSpecifier: &v3corepb.ConfigSource_Self{Self: &v3corepb.SelfConfigSource{}}},
+			want: true,
+		},
+		{
+			name:    "ads",
+			lrs:     &v3corepb.ConfigSource{ConfigSourceSpecifier: &v3corepb.ConfigSource_Ads{Ads: &v3corepb.AggregatedConfigSource{}}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := edsCluster("cluster")
+			c.LrsServer = test.lrs
+			cu, err := validateClusterAndConstructClusterUpdate(c)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("validateClusterAndConstructClusterUpdate() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if cu.EnableLRS != test.want {
+				t.Errorf("EnableLRS = %v, want %v", cu.EnableLRS, test.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerThresholdsFromCluster(t *testing.T) {
+	threshold := func(p v3corepb.RoutingPriority, maxRequests, maxPending *wrapperspb.UInt32Value) *v3clusterpb.CircuitBreakers_Thresholds {
+		return &v3clusterpb.CircuitBreakers_Thresholds{Priority: p, MaxRequests: maxRequests, MaxPendingRequests: maxPending}
+	}
+	tests := []struct {
+		name       string
+		thresholds []*v3clusterpb.CircuitBreakers_Thresholds
+		want       map[RoutingPriority]CircuitBreakerThresholds
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name:       "defaults",
+			thresholds: []*v3clusterpb.CircuitBreakers_Thresholds{threshold(v3corepb.RoutingPriority_DEFAULT, nil, nil)},
+			want:       map[RoutingPriority]CircuitBreakerThresholds{RoutingPriorityDefault: {MaxRequests: 1024, MaxPendingRequests: 1024}},
+		},
+		{
+			name: "default and high priorities",
+			thresholds: []*v3clusterpb.CircuitBreakers_Thresholds{
+				threshold(v3corepb.RoutingPriority_DEFAULT, wrapperspb.UInt32(100), nil),
+				threshold(v3corepb.RoutingPriority_HIGH, wrapperspb.UInt32(200), wrapperspb.UInt32(50)),
+			},
+			want: map[RoutingPriority]CircuitBreakerThresholds{
+				RoutingPriorityDefault: {MaxRequests: 100, MaxPendingRequests: 1024},
+				RoutingPriorityHigh:    {MaxRequests: 200, MaxPendingRequests: 50},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := edsCluster("cluster")
+			if test.thresholds != nil {
+				c.CircuitBreakers = &v3clusterpb.CircuitBreakers{Thresholds: test.thresholds}
+			}
+			cu, err := validateClusterAndConstructClusterUpdate(c)
+			if err != nil {
+				t.Fatalf("validateClusterAndConstructClusterUpdate() failed: %v", err)
+			}
+			if !reflect.DeepEqual(cu.CircuitBreakers, test.want) {
+				t.Errorf("CircuitBreakers = %+v, want %+v", cu.CircuitBreakers, test.want)
+			}
+		})
+	}
+}
+
+func TestOutlierDetectionFromCluster(t *testing.T) {
+	tests := []struct {
+		name    string
+		od      *v3clusterpb.OutlierDetection
+		want    *OutlierDetection
+		wantErr bool
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name: "defaults",
+			od:   &v3clusterpb.OutlierDetection{},
+			want: &OutlierDetection{
+				Interval:                  10 * time.Second,
+				BaseEjectionTime:          30 * time.Second,
+				MaxEjectionPercent:        10,
+				Consecutive5xx:            5,
+				ConsecutiveGatewayFailure: 5,
+			},
+		},
+		{
+			name: "all fields",
+			od: &v3clusterpb.OutlierDetection{
+				Interval:                  durationpb.New(time.Second),
+				BaseEjectionTime:          durationpb.New(time.Minute),
+				MaxEjectionPercent:        wrapperspb.UInt32(100),
+				Consecutive_5Xx:           wrapperspb.UInt32(3),
+				ConsecutiveGatewayFailure: wrapperspb.UInt32(2),
+			},
+			want: &OutlierDetection{
+				Interval:                  time.Second,
+				BaseEjectionTime:          time.Minute,
+				MaxEjectionPercent:        100,
+				Consecutive5xx:            3,
+				ConsecutiveGatewayFailure: 2,
+			},
+		},
+		{
+			name:    "max ejection percent above 100",
+			od:      &v3clusterpb.OutlierDetection{MaxEjectionPercent: wrapperspb.UInt32(101)},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := edsCluster("cluster")
+			c.OutlierDetection = test.od
+			got, err := outlierDetectionFromCluster(c)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("outlierDetectionFromCluster() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("outlierDetectionFromCluster() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHTTP2ConfigFromCluster(t *testing.T) {
+	h2 := &v3corepb.Http2ProtocolOptions{
+		MaxConcurrentStreams:    wrapperspb.UInt32(100),
+		InitialStreamWindowSize: wrapperspb.UInt32(1 << 20),
+	}
+	explicit := func(pc interface{}) *v3httpoptionspb.HttpProtocolOptions {
+		ehc := &v3httpoptionspb.HttpProtocolOptions_ExplicitHttpConfig{}
+		switch pc := pc.(type) {
+		case *v3corepb.Http2ProtocolOptions:
+			ehc.ProtocolConfig = &v3httpoptionspb.HttpProtocolOptions_ExplicitHttpConfig_Http2ProtocolOptions{Http2ProtocolOptions: pc}
+		case *v3corepb.Http1ProtocolOptions:
+			ehc.ProtocolConfig = &v3httpoptionspb.HttpProtocolOptions_ExplicitHttpConfig_HttpProtocolOptions{HttpProtocolOptions: pc}
+		}
+		return &v3httpoptionspb.HttpProtocolOptions{
+			UpstreamProtocolOptions: &v3httpoptionspb.HttpProtocolOptions_ExplicitHttpConfig_{ExplicitHttpConfig: ehc},
+		}
+	}
+	tests := []struct {
+		name    string
+		opts    proto.Message
+		want    *HTTP2Config
+		wantErr bool
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name: "explicit HTTP/2",
+			opts: explicit(h2),
+			want: &HTTP2Config{MaxConcurrentStreams: 100, InitialStreamWindowSize: 1 << 20},
+		},
+		{
+			name: "auto config",
+			opts: &v3httpoptionspb.HttpProtocolOptions{
+				UpstreamProtocolOptions: &v3httpoptionspb.HttpProtocolOptions_AutoConfig{AutoConfig: &v3httpoptionspb.HttpProtocolOptions_AutoHttpConfig{
+					Http2ProtocolOptions: h2,
+				}},
+			},
+			want: &HTTP2Config{MaxConcurrentStreams: 100, InitialStreamWindowSize: 1 << 20},
+		},
+		{
+			name: "no HTTP/2 options",
+			opts: &v3httpoptionspb.HttpProtocolOptions{},
+		},
+		{
+			name:    "explicit HTTP/1.1",
+			opts:    explicit(&v3corepb.Http1ProtocolOptions{}),
+			wantErr: true,
+		},
+		{
+			name:    "stream window size out of range",
+			opts:    explicit(&v3corepb.Http2ProtocolOptions{InitialStreamWindowSize: wrapperspb.UInt32(1024)}),
+			wantErr: true,
+		},
+		{
+			name:    "zero max concurrent streams",
+			opts:    explicit(&v3corepb.Http2ProtocolOptions{MaxConcurrentStreams: wrapperspb.UInt32(0)}),
+			wantErr: true,
+		},
+		{
+			name:    "unexpected type",
+			opts:    &v3corepb.Http2ProtocolOptions{},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := edsCluster("cluster")
+			if test.opts != nil {
+				c.TypedExtensionProtocolOptions = map[string]*anypb.Any{httpProtocolOptionsName: marshalAny(t, test.opts)}
+			}
+			got, err := http2ConfigFromCluster(c)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("http2ConfigFromCluster() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("http2ConfigFromCluster() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// tlsTransportSocket returns a TLS transport socket whose UpstreamTlsContext
+// validates the server certificates with the certificate provider instance
+// "root".
+func tlsTransportSocket(t *testing.T, sni string) *v3corepb.TransportSocket {
+	return &v3corepb.TransportSocket{
+		Name: transportSocketName,
+		ConfigType: &v3corepb.TransportSocket_TypedConfig{TypedConfig: marshalAny(t, &v3tlspb.UpstreamTlsContext{
+			CommonTlsContext: &v3tlspb.CommonTlsContext{
+				ValidationContextType: &v3tlspb.CommonTlsContext_ValidationContext{ValidationContext: &v3tlspb.CertificateValidationContext{
+					CaCertificateProviderInstance: &v3tlspb.CertificateProviderPluginInstance{InstanceName: "root"},
+				}},
+			},
+			Sni: sni,
+		})},
+	}
+}
+
+func TestSecurityConfigFromTransportSocket(t *testing.T) {
+	sdsContext := &v3tlspb.UpstreamTlsContext{CommonTlsContext: &v3tlspb.CommonTlsContext{
+		ValidationContextType: &v3tlspb.CommonTlsContext_CombinedValidationContext{CombinedValidationContext: &v3tlspb.CommonTlsContext_CombinedCertificateValidationContext{
+			DefaultValidationContext:         &v3tlspb.CertificateValidationContext{},
+			ValidationContextSdsSecretConfig: &v3tlspb.SdsSecretConfig{Name: "sds"},
+		}},
+	}}
+	tests := []struct {
+		name            string
+		ts              *v3corepb.TransportSocket
+		want            *SecurityConfig
+		wantErr         bool
+		wantUnsupported bool
+	}{
+		{
+			name: "raw buffer",
+			ts:   &v3corepb.TransportSocket{Name: rawBufferTransportSocketName},
+		},
+		{
+			name: "tls with sni",
+			ts:   tlsTransportSocket(t, "backend.example.com"),
+			want: &SecurityConfig{RootInstanceName: "root", SNI: "backend.example.com"},
+		},
+		{
+			name: "sds validation context",
+			ts: &v3corepb.TransportSocket{
+				Name:       transportSocketName,
+				ConfigType: &v3corepb.TransportSocket_TypedConfig{TypedConfig: marshalAny(t, sdsContext)},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "sni too long",
+			ts:      tlsTransportSocket(t, string(make([]byte, 256))),
+			wantErr: true,
+		},
+		{
+			name:            "unsupported transport socket",
+			ts:              &v3corepb.TransportSocket{Name: "envoy.transport_sockets.alts"},
+			wantErr:         true,
+			wantUnsupported: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := securityConfigFromTransportSocket(test.ts)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("securityConfigFromTransportSocket() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if errors.Is(err, errUnsupportedTransportSocket) != test.wantUnsupported {
+				t.Errorf("securityConfigFromTransportSocket() returned err %v, want errUnsupportedTransportSocket: %v", err, test.wantUnsupported)
+			}
+			if !got.Equal(test.want) {
+				t.Errorf("securityConfigFromTransportSocket() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTransportSocketMatchesFromCluster(t *testing.T) {
+	criteria, err := structpb.NewStruct(map[string]interface{}{"mtls": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := edsCluster("cluster")
+	c.TransportSocketMatches = []*v3clusterpb.Cluster_TransportSocketMatch{
+		{Name: "mtls", Match: criteria, TransportSocket: tlsTransportSocket(t, "")},
+		{Name: "plaintext", TransportSocket: &v3corepb.TransportSocket{Name: rawBufferTransportSocketName}},
+		{Name: "alts", TransportSocket: &v3corepb.TransportSocket{Name: "envoy.transport_sockets.alts"}},
+	}
+
+	// Without a transport_socket, the unsupported transport socket of a match
+	// can't fall back to anything.
+	if _, err := transportSocketMatchesFromCluster(c); err == nil {
+		t.Fatal("transportSocketMatchesFromCluster() succeeded with an unsupported transport socket and no fallback, want an error")
+	}
+
+	c.TransportSocket = tlsTransportSocket(t, "")
+	got, err := transportSocketMatchesFromCluster(c)
+	if err != nil {
+		t.Fatalf("transportSocketMatchesFromCluster() failed: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("transportSocketMatchesFromCluster() returned %d matches, want 3", len(got))
+	}
+	if m := got[0]; m.Name != "mtls" || !proto.Equal(m.Match, criteria) || !m.SecurityCfg.Equal(&SecurityConfig{RootInstanceName: "root"}) || m.UseClusterDefault {
+		t.Errorf("match mtls = %+v, want the root certificate provider", m)
+	}
+	if m := got[1]; m.SecurityCfg != nil || m.UseClusterDefault {
+		t.Errorf("match plaintext = %+v, want no security config", m)
+	}
+	if m := got[2]; m.SecurityCfg != nil || !m.UseClusterDefault {
+		t.Errorf("match alts = %+v, want the cluster default", m)
+	}
+}
+
+func TestSubsetConfigFromCluster(t *testing.T) {
+	selector := func(p v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector_LbSubsetSelectorFallbackPolicy, fallbackKeys []string, keys ...string) *v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector {
+		return &v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector{Keys: keys, FallbackPolicy: p, FallbackKeysSubset: fallbackKeys}
+	}
+	tests := []struct {
+		name    string
+		lsc     *v3clusterpb.Cluster_LbSubsetConfig
+		want    *SubsetConfig
+		wantErr bool
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name: "fallback defaults to no fallback",
+			lsc: &v3clusterpb.Cluster_LbSubsetConfig{SubsetSelectors: []*v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector{
+				selector(v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector_NOT_DEFINED, nil, "version"),
+			}},
+			want: &SubsetConfig{
+				FallbackPolicy: SubsetFallbackNone,
+				Selectors:      []SubsetSelector{{Keys: []string{"version"}, FallbackPolicy: SubsetFallbackNone}},
+			},
+		},
+		{
+			name: "selectors inherit the cluster fallback",
+			lsc: &v3clusterpb.Cluster_LbSubsetConfig{
+				FallbackPolicy: v3clusterpb.Cluster_LbSubsetConfig_ANY_ENDPOINT,
+				SubsetSelectors: []*v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector{
+					selector(v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector_NOT_DEFINED, nil, "version"),
+					selector(v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector_KEYS_SUBSET, []string{"version"}, "version", "stage"),
+				},
+			},
+			want: &SubsetConfig{
+				FallbackPolicy: SubsetFallbackAnyEndpoint,
+				Selectors: []SubsetSelector{
+					{Keys: []string{"version"}, FallbackPolicy: SubsetFallbackAnyEndpoint},
+					{Keys: []string{"version", "stage"}, FallbackPolicy: SubsetFallbackKeysSubset, FallbackKeysSubset: []string{"version"}},
+				},
+			},
+		},
+		{
+			name: "selector without keys",
+			lsc: &v3clusterpb.Cluster_LbSubsetConfig{SubsetSelectors: []*v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector{
+				selector(v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector_NOT_DEFINED, nil),
+			}},
+			wantErr: true,
+		},
+		{
+			name: "fallback keys not a subset of keys",
+			lsc: &v3clusterpb.Cluster_LbSubsetConfig{SubsetSelectors: []*v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector{
+				selector(v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector_KEYS_SUBSET, []string{"stage"}, "version"),
+			}},
+			wantErr: true,
+		},
+		{
+			name: "empty fallback keys",
+			lsc: &v3clusterpb.Cluster_LbSubsetConfig{SubsetSelectors: []*v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector{
+				selector(v3clusterpb.Cluster_LbSubsetConfig_LbSubsetSelector_KEYS_SUBSET, nil, "version"),
+			}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := edsCluster("cluster")
+			c.LbSubsetConfig = test.lsc
+			got, err := subsetConfigFromCluster(c)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("subsetConfigFromCluster() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("subsetConfigFromCluster() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDNSResolutionFromCluster(t *testing.T) {
+	tests := []struct {
+		name        string
+		refreshRate *durationpb.Duration
+		respectTTL  bool
+		family      v3clusterpb.Cluster_DnsLookupFamily
+		want        ClusterUpdate
+		wantErr     bool
+	}{
+		{
+			name: "defaults",
+			want: ClusterUpdate{DNSRefreshRate: 5 * time.Second, DNSLookupFamily: DNSLookupFamilyAuto},
+		},
+		{
+			name:        "refresh rate and family",
+			refreshRate: durationpb.New(time.Minute),
+			family:      v3clusterpb.Cluster_V4_ONLY,
+			want:        ClusterUpdate{DNSRefreshRate: time.Minute, DNSLookupFamily: DNSLookupFamilyV4Only},
+		},
+		{
+			name:        "zero refresh rate with respect_dns_ttl",
+			refreshRate: durationpb.New(0),
+			respectTTL:  true,
+			family:      v3clusterpb.Cluster_ALL,
+			want:        ClusterUpdate{DNSRefreshRate: 5 * time.Second, RespectDNSTTL: true, DNSLookupFamily: DNSLookupFamilyAll},
+		},
+		{
+			name:        "refresh rate with respect_dns_ttl",
+			refreshRate: durationpb.New(time.Minute),
+			respectTTL:  true,
+			wantErr:     true,
+		},
+		{
+			name:        "refresh rate below 1ms",
+			refreshRate: durationpb.New(time.Microsecond),
+			wantErr:     true,
+		},
+		{
+			name:        "invalid refresh rate",
+			refreshRate: &durationpb.Duration{Seconds: 1, Nanos: -1},
+			wantErr:     true,
+		},
+		{
+			name:    "unknown family",
+			family:  42,
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &v3clusterpb.Cluster{
+				Name:            "cluster",
+				DnsRefreshRate:  test.refreshRate,
+				RespectDnsTtl:   test.respectTTL,
+				DnsLookupFamily: test.family,
+			}
+			var got ClusterUpdate
+			err := dnsResolutionFromCluster(c, &got)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("dnsResolutionFromCluster() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("dnsResolutionFromCluster() set %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
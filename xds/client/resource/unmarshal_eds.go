@@ -41,6 +41,7 @@ import (
 
 import (
 	dubboLogger "dubbo.apache.org/dubbo-go/v3/common/logger"
+	"dubbo.apache.org/dubbo-go/v3/xds/client/resource/version"
 	"dubbo.apache.org/dubbo-go/v3/xds/utils/pretty"
 )
 
@@ -48,21 +49,25 @@ import (
 // validates them, and transforms them into a native struct which contains only
 // fields we are interested in.
 func UnmarshalEndpoints(opts *UnmarshalOptions) (map[string]EndpointsUpdateErrTuple, UpdateMetadata, error) {
+	opts = opts.withNodeLogger()
 	update := make(map[string]EndpointsUpdateErrTuple)
 	md, err := processAllResources(opts, update)
 	return update, md, err
 }
 
-func unmarshalEndpointsResource(r *anypb.Any, logger dubboLogger.Logger) (string, EndpointsUpdate, error) {
-	if !IsEndpointsResource(r.GetTypeUrl()) {
-		return "", EndpointsUpdate{}, fmt.Errorf("unexpected resource type: %q ", r.GetTypeUrl())
+func unmarshalEndpointsResource(r *anypb.Any, logger dubboLogger.Logger, api version.TransportAPI) (string, EndpointsUpdate, error) {
+	if err := ValidateResourceType(r.GetTypeUrl(), EndpointsResource); err != nil {
+		return "", EndpointsUpdate{}, err
+	}
+	if _, err := isV2Resource(api, r.GetTypeUrl(), version.V2EndpointsURL); err != nil {
+		return "", EndpointsUpdate{}, err
 	}
 
 	cla := &v3endpointpb.ClusterLoadAssignment{}
 	if err := proto.Unmarshal(r.GetValue(), cla); err != nil {
 		return "", EndpointsUpdate{}, fmt.Errorf("failed to unmarshal resource: %v", err)
 	}
-	dubboLogger.Debugf("Resource with name: %v, type: %T, contains: %v", cla.GetClusterName(), cla, pretty.ToJSON(cla))
+	logger.Debugf("Resource with name: %v, type: %T, contains: %v", cla.GetClusterName(), cla, pretty.Lazy(cla))
 
 	u, err := parseEDSRespProto(cla)
 	if err != nil {
@@ -97,16 +102,25 @@ func parseDropPolicy(dropPolicy *v3endpointpb.ClusterLoadAssignment_Policy_DropO
 	}
 }
 
-func parseEndpoints(lbEndpoints []*v3endpointpb.LbEndpoint) []Endpoint {
+func parseEndpoints(lbEndpoints []*v3endpointpb.LbEndpoint) ([]Endpoint, error) {
 	endpoints := make([]Endpoint, 0, len(lbEndpoints))
 	for _, lbEndpoint := range lbEndpoints {
+		weight := uint32(1)
+		if w := lbEndpoint.GetLoadBalancingWeight(); w != nil {
+			if w.GetValue() == 0 {
+				return nil, fmt.Errorf("EDS response contains an endpoint with zero load_balancing_weight, endpoint: %+v", lbEndpoint)
+			}
+			weight = w.GetValue()
+		}
 		endpoints = append(endpoints, Endpoint{
 			HealthStatus: EndpointHealthStatus(lbEndpoint.GetHealthStatus()),
 			Address:      parseAddress(lbEndpoint.GetEndpoint().GetAddress().GetSocketAddress()),
-			Weight:       lbEndpoint.GetLoadBalancingWeight().GetValue(),
+			Weight:       weight,
+			// The metadata is opaque here, it is never validated.
+			FilterMetadata: lbEndpoint.GetMetadata().GetFilterMetadata(),
 		})
 	}
-	return endpoints
+	return endpoints, nil
 }
 
 func parseEDSRespProto(m *v3endpointpb.ClusterLoadAssignment) (EndpointsUpdate, error) {
@@ -115,6 +129,9 @@ func parseEDSRespProto(m *v3endpointpb.ClusterLoadAssignment) (EndpointsUpdate,
 		ret.Drops = append(ret.Drops, parseDropPolicy(dropPolicy))
 	}
 	priorities := make(map[uint32]struct{})
+	// weighted records, per priority, whether the localities seen so far set
+	// load_balancing_weight.
+	weighted := make(map[uint32]bool)
 	for _, locality := range m.Endpoints {
 		l := locality.GetLocality()
 		if l == nil {
@@ -126,11 +143,24 @@ func parseEDSRespProto(m *v3endpointpb.ClusterLoadAssignment) (EndpointsUpdate,
 			SubZone: l.SubZone,
 		}
 		priority := locality.GetPriority()
+		hasWeight := locality.GetLoadBalancingWeight() != nil
+		if _, ok := priorities[priority]; ok && weighted[priority] != hasWeight {
+			return EndpointsUpdate{}, fmt.Errorf("EDS response contains localities of priority %v with and without load_balancing_weight, locality: %+v", priority, locality)
+		}
 		priorities[priority] = struct{}{}
+		weighted[priority] = hasWeight
+		weight := uint32(1)
+		if hasWeight {
+			weight = locality.GetLoadBalancingWeight().GetValue()
+		}
+		endpoints, err := parseEndpoints(locality.GetLbEndpoints())
+		if err != nil {
+			return EndpointsUpdate{}, err
+		}
 		ret.Localities = append(ret.Localities, Locality{
 			ID:        lid,
-			Endpoints: parseEndpoints(locality.GetLbEndpoints()),
-			Weight:    locality.GetLoadBalancingWeight().GetValue(),
+			Endpoints: endpoints,
+			Weight:    weight,
 			Priority:  priority,
 		})
 	}
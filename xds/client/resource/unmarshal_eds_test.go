@@ -0,0 +1,162 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"reflect"
+	"testing"
+)
+
+import (
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3endpointpb "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+
+	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// lbEndpoint returns an endpoint listening on addr:8080.
+func lbEndpoint(addr string) *v3endpointpb.LbEndpoint {
+	return &v3endpointpb.LbEndpoint{HostIdentifier: &v3endpointpb.LbEndpoint_Endpoint{Endpoint: &v3endpointpb.Endpoint{
+		Address: &v3corepb.Address{Address: &v3corepb.Address_SocketAddress{SocketAddress: &v3corepb.SocketAddress{
+			Address:       addr,
+			PortSpecifier: &v3corepb.SocketAddress_PortValue{PortValue: 8080},
+		}}},
+	}}}
+}
+
+// locality returns a locality of the zone, with one endpoint.
+func locality(zone string, priority uint32, weight *wrapperspb.UInt32Value) *v3endpointpb.LocalityLbEndpoints {
+	return &v3endpointpb.LocalityLbEndpoints{
+		Locality:            &v3corepb.Locality{Region: "region", Zone: zone},
+		LbEndpoints:         []*v3endpointpb.LbEndpoint{lbEndpoint("10.0.0.1")},
+		LoadBalancingWeight: weight,
+		Priority:            priority,
+	}
+}
+
+func TestParseEDSRespProtoLocalities(t *testing.T) {
+	tests := []struct {
+		name       string
+		localities []*v3endpointpb.LocalityLbEndpoints
+		// want are the weight and priority of each locality.
+		want    [][2]uint32
+		wantErr bool
+	}{
+		{
+			name:       "weights default to 1",
+			localities: []*v3endpointpb.LocalityLbEndpoints{locality("a", 0, nil), locality("b", 0, nil)},
+			want:       [][2]uint32{{1, 0}, {1, 0}},
+		},
+		{
+			name: "weighted",
+			localities: []*v3endpointpb.LocalityLbEndpoints{
+				locality("a", 0, wrapperspb.UInt32(3)),
+				locality("b", 0, wrapperspb.UInt32(1)),
+				locality("c", 1, nil),
+			},
+			want: [][2]uint32{{3, 0}, {1, 0}, {1, 1}},
+		},
+		{
+			name:       "partially weighted priority",
+			localities: []*v3endpointpb.LocalityLbEndpoints{locality("a", 0, wrapperspb.UInt32(3)), locality("b", 0, nil)},
+			wantErr:    true,
+		},
+		{
+			name:       "missing priority",
+			localities: []*v3endpointpb.LocalityLbEndpoints{locality("a", 0, nil), locality("b", 2, nil)},
+			wantErr:    true,
+		},
+		{
+			name:       "locality without ID",
+			localities: []*v3endpointpb.LocalityLbEndpoints{{LbEndpoints: []*v3endpointpb.LbEndpoint{lbEndpoint("10.0.0.1")}}},
+			wantErr:    true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			u, err := parseEDSRespProto(&v3endpointpb.ClusterLoadAssignment{ClusterName: "cluster", Endpoints: test.localities})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseEDSRespProto() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var got [][2]uint32
+			for _, l := range u.Localities {
+				got = append(got, [2]uint32{l.Weight, l.Priority})
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("weights and priorities of the localities = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseEndpoints(t *testing.T) {
+	lbMetadata, err := structpb.NewStruct(map[string]interface{}{"version": "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	draining := lbEndpoint("10.0.0.2")
+	draining.HealthStatus = v3corepb.HealthStatus_DRAINING
+	weighted := lbEndpoint("10.0.0.3")
+	weighted.LoadBalancingWeight = wrapperspb.UInt32(5)
+	weighted.Metadata = &v3corepb.Metadata{FilterMetadata: map[string]*structpb.Struct{LBMetadataKey: lbMetadata}}
+
+	got, err := parseEndpoints([]*v3endpointpb.LbEndpoint{lbEndpoint("10.0.0.1"), draining, weighted})
+	if err != nil {
+		t.Fatalf("parseEndpoints() failed: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("parseEndpoints() returned %d endpoints, want 3", len(got))
+	}
+	if e := got[0]; e.Address != "10.0.0.1:8080" || e.Weight != 1 || !e.IsUsable() || e.LBMetadata() != nil {
+		t.Errorf("endpoint without options = %+v, want a usable endpoint of weight 1 without metadata", e)
+	}
+	if e := got[1]; e.HealthStatus != EndpointHealthStatusDraining || e.IsUsable() {
+		t.Errorf("draining endpoint = %+v, want an unusable draining endpoint", e)
+	}
+	if e := got[2]; e.Weight != 5 || !proto.Equal(e.LBMetadata(), lbMetadata) {
+		t.Errorf("weighted endpoint = %+v, want weight 5 and LB metadata %v", e, lbMetadata)
+	}
+
+	zero := lbEndpoint("10.0.0.4")
+	zero.LoadBalancingWeight = wrapperspb.UInt32(0)
+	if _, err := parseEndpoints([]*v3endpointpb.LbEndpoint{zero}); err == nil {
+		t.Error("parseEndpoints() succeeded with a zero load_balancing_weight, want an error")
+	}
+}
+
+func TestEndpointIsUsable(t *testing.T) {
+	for status, want := range map[EndpointHealthStatus]bool{
+		EndpointHealthStatusUnknown:   true,
+		EndpointHealthStatusHealthy:   true,
+		EndpointHealthStatusUnhealthy: false,
+		EndpointHealthStatusDraining:  false,
+		EndpointHealthStatusTimeout:   false,
+		EndpointHealthStatusDegraded:  false,
+	} {
+		e := Endpoint{HealthStatus: status}
+		if got := e.IsUsable(); got != want {
+			t.Errorf("IsUsable() of an endpoint with health status %v = %v, want %v", status, got, want)
+		}
+	}
+}
@@ -26,7 +26,11 @@ package resource
 import (
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 import (
@@ -34,20 +38,25 @@ import (
 
 	v3cncftypepb "github.com/cncf/xds/go/xds/type/v3"
 
+	v3accesslogpb "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	v3listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	v3routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	v3fileaccesslogpb "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/file/v3"
 	v3httppb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 
 	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 import (
 	dubboLogger "dubbo.apache.org/dubbo-go/v3/common/logger"
 	"dubbo.apache.org/dubbo-go/v3/xds/client/resource/version"
 	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
+	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter/router"
 	"dubbo.apache.org/dubbo-go/v3/xds/utils/pretty"
 )
 
@@ -55,86 +64,240 @@ import (
 // them, and transforms them into a native struct which contains only fields we
 // are interested in.
 func UnmarshalListener(opts *UnmarshalOptions) (map[string]ListenerUpdateErrTuple, UpdateMetadata, error) {
+	opts = opts.withNodeLogger()
 	update := make(map[string]ListenerUpdateErrTuple)
 	md, err := processAllResources(opts, update)
+	for _, w := range inlineRouteConfigConflicts(update) {
+		opts.Logger.Warnf("%s", w)
+		md.Warnings = append(md.Warnings, w)
+	}
+	if err == nil {
+		for _, name := range notFoundNames(opts, func(name string) bool { _, ok := update[name]; return ok }) {
+			update[name] = ListenerUpdateErrTuple{Err: errNotFound(ListenerResource, name)}
+		}
+	}
 	return update, md, err
 }
 
-func unmarshalListenerResource(r *anypb.Any, f UpdateValidatorFunc, logger dubboLogger.Logger) (string, ListenerUpdate, error) {
-	if !IsListenerResource(r.GetTypeUrl()) {
-		return "", ListenerUpdate{}, fmt.Errorf("unexpected resource type: %q ", r.GetTypeUrl())
+// UnmarshalListenerStream is like UnmarshalListener, but instead of building a
+// map of all the listeners in the response it invokes cb with each one as soon
+// as it is processed, so that callers need not hold all of them at once.
+// Resources which fail before their name is known are not passed to cb; they
+// are only reflected in the returned metadata and error.
+//
+// If cb returns an error, processing stops and that error is returned.
+func UnmarshalListenerStream(opts *UnmarshalOptions, cb func(name string, tuple ListenerUpdateErrTuple) error) (UpdateMetadata, error) {
+	opts = opts.withNodeLogger()
+	md := newUpdateMetadata(opts)
+	var topLevelErrors []error
+	perResourceErrors := make(map[string]error)
+	tracker := make(inlineRouteConfigTracker)
+	var warnings []string
+	seen := make(map[string]bool, len(opts.Resources))
+	unmarshal := wrapUnmarshal(ListenerResource, opts, func(r *anypb.Any) (string, interface{}, error) {
+		return unmarshalListenerResource(r, opts)
+	})
+
+	for _, r := range opts.Resources {
+		name, u, err := unmarshal(r)
+		update, _ := u.(ListenerUpdate)
+		name = ParseName(name).String()
+		seen[name] = true
+		tuple := ListenerUpdateErrTuple{Update: update, Err: err}
+		switch {
+		case err == nil:
+			if w, ok := tracker.add(name, update); ok {
+				opts.Logger.Warnf("%s", w)
+				warnings = append(warnings, w)
+			}
+		case name == "":
+			topLevelErrors = append(topLevelErrors, err)
+			continue
+		default:
+			perResourceErrors[name] = err
+		}
+		if err := cb(name, tuple); err != nil {
+			return md, err
+		}
+	}
+
+	md, err := resultMetadata(md, "LDS", topLevelErrors, perResourceErrors)
+	md.Warnings = warnings
+	if err == nil {
+		for _, name := range notFoundNames(opts, func(name string) bool { return seen[name] }) {
+			if err := cb(name, ListenerUpdateErrTuple{Err: errNotFound(ListenerResource, name)}); err != nil {
+				return md, err
+			}
+		}
+	}
+	return md, err
+}
+
+// inlineRouteConfigConflicts returns a warning for every listener whose inline
+// route configuration differs from that of another listener using the same
+// route configuration name. Listeners are visited in name order, so the
+// result is deterministic.
+func inlineRouteConfigConflicts(update map[string]ListenerUpdateErrTuple) []string {
+	names := make([]string, 0, len(update))
+	for name := range update {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var warnings []string
+	tracker := make(inlineRouteConfigTracker)
+	for _, lisName := range names {
+		u := update[lisName]
+		if u.Err != nil {
+			continue
+		}
+		if w, ok := tracker.add(lisName, u.Update); ok {
+			warnings = append(warnings, w)
+		}
+	}
+	return warnings
+}
+
+// inlineRouteConfigTracker records, per inline route configuration name, the
+// first listener carrying it.
+type inlineRouteConfigTracker map[string]inlineRouteConfigUser
+
+type inlineRouteConfigUser struct {
+	listener string
+	raw      *anypb.Any
+}
+
+// add records the inline route configuration of listener u, and returns a
+// warning if it conflicts with the one recorded under the same name.
+func (t inlineRouteConfigTracker) add(lisName string, u ListenerUpdate) (string, bool) {
+	rcName := u.InlineRouteConfigName
+	if u.InlineRouteConfig == nil || rcName == "" {
+		return "", false
+	}
+	first, ok := t[rcName]
+	if !ok {
+		t[rcName] = inlineRouteConfigUser{listener: lisName, raw: u.InlineRouteConfig.Raw}
+		return "", false
+	}
+	if proto.Equal(first.raw, u.InlineRouteConfig.Raw) {
+		return "", false
+	}
+	return fmt.Sprintf("listeners %q and %q have conflicting inline route configurations named %q", first.listener, lisName, rcName), true
+}
+
+func unmarshalListenerResource(r *anypb.Any, opts *UnmarshalOptions) (string, ListenerUpdate, error) {
+	f, logger := opts.UpdateValidator, opts.Logger
+	if err := ValidateResourceType(r.GetTypeUrl(), ListenerResource); err != nil {
+		return "", ListenerUpdate{}, annotateNACKError(&NACKError{Reason: ReasonUnexpectedResourceType, Err: err}, ListenerResource, "")
+	}
+	v2, err := isV2Resource(opts.TransportAPI, r.GetTypeUrl(), version.V2ListenerURL)
+	if err != nil {
+		return "", ListenerUpdate{}, annotateNACKError(&NACKError{Reason: ReasonUnexpectedResourceType, Err: err}, ListenerResource, "")
 	}
-	// TODO: Pass version.TransportAPI instead of relying upon the type URL
-	v2 := r.GetTypeUrl() == version.V2ListenerURL
 	lis := &v3listenerpb.Listener{}
 	if err := proto.Unmarshal(r.GetValue(), lis); err != nil {
-		return "", ListenerUpdate{}, fmt.Errorf("failed to unmarshal resource: %v", err)
+		name := resourceNameFromBytes(r.GetValue())
+		return name, ListenerUpdate{}, annotateNACKError(nackErrorf(ReasonUnmarshalFailed, "failed to unmarshal resource: %v", err), ListenerResource, name)
+	}
+	logger.Debugf("Resource with name: %v, type: %T, contains: %v", lis.GetName(), lis, pretty.Lazy(lis))
+	if v2 && opts.DisableV2 {
+		return lis.GetName(), ListenerUpdate{}, annotateNACKError(nackErrorf(ReasonUnexpectedResourceType, "v2 listeners are disabled"), ListenerResource, lis.GetName())
 	}
-	dubboLogger.Debugf("Resource with name: %v, type: %T, contains: %v", lis.GetName(), lis, pretty.ToJSON(lis))
 
-	lu, err := processListener(lis, logger, v2)
+	lu, err := processListener(lis, opts, v2)
 	if err != nil {
-		return lis.GetName(), ListenerUpdate{}, err
+		return lis.GetName(), ListenerUpdate{}, annotateNACKError(err, ListenerResource, lis.GetName())
 	}
+	lu.FilterMetadata = lis.GetMetadata().GetFilterMetadata()
+	lu.TrafficDirection = trafficDirectionFromProto(lis.GetTrafficDirection(), logger)
 	if f != nil {
 		if err := f(*lu); err != nil {
-			return lis.GetName(), ListenerUpdate{}, err
+			return lis.GetName(), ListenerUpdate{}, annotateNACKError(&NACKError{Reason: ReasonValidationFailed, Err: err}, ListenerResource, lis.GetName())
 		}
 	}
 	lu.Raw = r
+	lu.RawHash = hashBytes(r.GetValue())
+	if t := opts.UpdateTransform; t != nil {
+		if err := t(lu); err != nil {
+			return lis.GetName(), ListenerUpdate{}, annotateNACKError(&NACKError{Reason: ReasonValidationFailed, Err: err}, ListenerResource, lis.GetName())
+		}
+	}
+	lu.httpFilterIndex = indexHTTPFilters(lu.HTTPFilters)
 	return lis.GetName(), *lu, nil
 }
 
-func processListener(lis *v3listenerpb.Listener, logger dubboLogger.Logger, v2 bool) (*ListenerUpdate, error) {
-	if lis.GetApiListener() != nil {
-		return processClientSideListener(lis, logger, v2)
+// hashBytes returns the 64-bit FNV-1a hash of b.
+func hashBytes(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+// processListener dispatches on the kind of the listener: client-side
+// listeners set an api_listener, server-side ones an address and filter
+// chains. A listener must be exactly one of the two.
+func processListener(lis *v3listenerpb.Listener, opts *UnmarshalOptions, v2 bool) (*ListenerUpdate, error) {
+	hasServerFields := lis.GetAddress() != nil || len(lis.GetFilterChains()) != 0 || lis.GetDefaultFilterChain() != nil
+	switch {
+	case lis.GetApiListener() != nil && hasServerFields:
+		return nil, nackErrorf(ReasonAmbiguousListener, "listener sets both an api_listener and an address or filter chains")
+	case lis.GetApiListener() != nil:
+		return processClientSideListener(lis, opts, v2)
+	case lis.GetAddress() == nil:
+		return nil, nackErrorf(ReasonAmbiguousListener, "listener sets neither an api_listener nor an address")
+	default:
+		return processServerSideListener(lis, opts)
 	}
-	return processServerSideListener(lis, logger)
 }
 
 // processClientSideListener checks if the provided Listener proto meets
 // the expected criteria. If so, it returns a non-empty routeConfigName.
-func processClientSideListener(lis *v3listenerpb.Listener, logger dubboLogger.Logger, v2 bool) (*ListenerUpdate, error) {
+func processClientSideListener(lis *v3listenerpb.Listener, opts *UnmarshalOptions, v2 bool) (*ListenerUpdate, error) {
+	logger := opts.Logger
 	update := &ListenerUpdate{}
 
 	apiLisAny := lis.GetApiListener().GetApiListener()
-	if !IsHTTPConnManagerResource(apiLisAny.GetTypeUrl()) {
-		return nil, fmt.Errorf("unexpected resource type: %q", apiLisAny.GetTypeUrl())
+	if err := ValidateResourceType(apiLisAny.GetTypeUrl(), HTTPConnManagerResource); err != nil {
+		return nil, &NACKError{Reason: ReasonUnexpectedResourceType, Err: err}
 	}
 	apiLis := &v3httppb.HttpConnectionManager{}
 	if err := proto.Unmarshal(apiLisAny.GetValue(), apiLis); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal api_listner: %v", err)
+		return nil, nackErrorf(ReasonUnmarshalFailed, "failed to unmarshal api_listner: %v", err)
 	}
 	// "HttpConnectionManager.xff_num_trusted_hops must be unset or zero and
 	// HttpConnectionManager.original_ip_detection_extensions must be empty. If
 	// either field has an incorrect value, the Listener must be NACKed." - A41
 	if apiLis.XffNumTrustedHops != 0 {
-		return nil, fmt.Errorf("xff_num_trusted_hops must be unset or zero %+v", apiLis)
+		return nil, nackErrorf(ReasonInvalidHTTPConnManager, "xff_num_trusted_hops must be unset or zero %+v", apiLis)
 	}
 	if len(apiLis.OriginalIpDetectionExtensions) != 0 {
-		return nil, fmt.Errorf("original_ip_detection_extensions must be empty %+v", apiLis)
+		return nil, nackErrorf(ReasonInvalidHTTPConnManager, "original_ip_detection_extensions must be empty %+v", apiLis)
 	}
 
 	switch apiLis.RouteSpecifier.(type) {
 	case *v3httppb.HttpConnectionManager_Rds:
 		if apiLis.GetRds().GetConfigSource().GetAds() == nil {
-			return nil, fmt.Errorf("ConfigSource is not ADS: %+v", lis)
+			return nil, nackErrorf(ReasonInvalidRouteSpecifier, "ConfigSource is not ADS: %+v", lis)
 		}
 		name := apiLis.GetRds().GetRouteConfigName()
 		if name == "" {
-			return nil, fmt.Errorf("empty route_config_name: %+v", lis)
+			return nil, nackErrorf(ReasonInvalidRouteSpecifier, "empty route_config_name: %+v", lis)
 		}
 		update.RouteConfigName = name
 	case *v3httppb.HttpConnectionManager_RouteConfig:
 		routeU, err := generateRDSUpdateFromRouteConfiguration(apiLis.GetRouteConfig(), logger, v2)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse inline RDS resp: %v", err)
+			return nil, nackErrorf(ReasonInvalidRouteConfig, "failed to parse inline RDS resp: %v", err)
+		}
+		if routeU.Raw, err = ptypes.MarshalAny(apiLis.GetRouteConfig()); err != nil {
+			return nil, nackErrorf(ReasonInvalidRouteConfig, "failed to marshal inline RDS resp: %v", err)
 		}
 		update.InlineRouteConfig = &routeU
+		update.InlineRouteConfigName = apiLis.GetRouteConfig().GetName()
 	case nil:
-		return nil, fmt.Errorf("no RouteSpecifier: %+v", apiLis)
+		return nil, nackErrorf(ReasonInvalidRouteSpecifier, "no RouteSpecifier: %+v", apiLis)
 	default:
-		return nil, fmt.Errorf("unsupported type %T for RouteSpecifier", apiLis.RouteSpecifier)
+		return nil, nackErrorf(ReasonInvalidRouteSpecifier, "unsupported type %T for RouteSpecifier", apiLis.RouteSpecifier)
 	}
 
 	if v2 {
@@ -143,38 +306,174 @@ func processClientSideListener(lis *v3listenerpb.Listener, logger dubboLogger.Lo
 
 	// The following checks and fields only apply to xDS protocol versions v3+.
 
-	update.MaxStreamDuration = apiLis.GetCommonHttpProtocolOptions().GetMaxStreamDuration().AsDuration()
+	protocolOpts := apiLis.GetCommonHttpProtocolOptions()
+	update.MaxStreamDuration = protocolOpts.GetMaxStreamDuration().AsDuration()
+	if it := protocolOpts.GetIdleTimeout(); it != nil {
+		if err := it.CheckValid(); err != nil {
+			return nil, nackErrorf(ReasonInvalidHTTPConnManager, "invalid idle_timeout %v: %v", it, err)
+		}
+		update.IdleTimeout = it.AsDuration()
+		if update.IdleTimeout < 0 {
+			return nil, nackErrorf(ReasonInvalidHTTPConnManager, "idle_timeout = %v; must be >= 0", update.IdleTimeout)
+		}
+	}
+	if update.MaxStreamDuration > 0 && update.IdleTimeout > update.MaxStreamDuration {
+		return nil, nackErrorf(ReasonInvalidHTTPConnManager, "idle_timeout %v is larger than max_stream_duration %v", update.IdleTimeout, update.MaxStreamDuration)
+	}
 
 	var err error
-	if update.HTTPFilters, err = processHTTPFilters(apiLis.GetHttpFilters(), false); err != nil {
+	if update.HTTPFilters, err = processHTTPFilters(apiLis.GetHttpFilters(), false, v2, nil, opts.TreatUnknownFiltersAsOptional, logger); err != nil {
+		return nil, err
+	}
+	if update.UpgradeConfigs, err = processUpgradeConfigs(apiLis.GetUpgradeConfigs()); err != nil {
+		return nil, err
+	}
+	if update.LocalReplyConfig, err = processLocalReplyConfig(apiLis.GetLocalReplyConfig(), logger); err != nil {
+		return nil, err
+	}
+	if update.AccessLogs, err = processAccessLogs(apiLis.GetAccessLog(), logger); err != nil {
 		return nil, err
 	}
 
 	return update, nil
 }
 
+// processAccessLogs converts the access_log entries of an
+// HttpConnectionManager. Access logs of unsupported types are kept, with a
+// warning, as they don't change how requests are handled.
+func processAccessLogs(als []*v3accesslogpb.AccessLog, logger dubboLogger.Logger) ([]AccessLogConfig, error) {
+	if len(als) == 0 {
+		return nil, nil
+	}
+	ret := make([]AccessLogConfig, 0, len(als))
+	for _, al := range als {
+		c := AccessLogConfig{Name: al.GetName(), TypeURL: al.GetTypedConfig().GetTypeUrl()}
+		if c.TypeURL != version.V3FileAccessLogURL {
+			logger.Warnf("Access log %q of unsupported type %q, nothing will be logged", c.Name, c.TypeURL)
+			ret = append(ret, c)
+			continue
+		}
+		fal := &v3fileaccesslogpb.FileAccessLog{}
+		if err := proto.Unmarshal(al.GetTypedConfig().GetValue(), fal); err != nil {
+			return nil, nackErrorf(ReasonUnmarshalFailed, "failed to unmarshal access log %q: %v", c.Name, err)
+		}
+		if c.Path = fal.GetPath(); c.Path == "" {
+			return nil, nackErrorf(ReasonInvalidHTTPConnManager, "access log %q has no path", c.Name)
+		}
+		switch f := fal.GetAccessLogFormat().(type) {
+		case *v3fileaccesslogpb.FileAccessLog_Format:
+			c.Format = f.Format
+		case *v3fileaccesslogpb.FileAccessLog_JsonFormat:
+			c.JSONFormat = f.JsonFormat
+		case *v3fileaccesslogpb.FileAccessLog_TypedJsonFormat:
+			c.JSONFormat = f.TypedJsonFormat
+		case *v3fileaccesslogpb.FileAccessLog_LogFormat:
+			switch lf := f.LogFormat.GetFormat().(type) {
+			case *v3corepb.SubstitutionFormatString_TextFormat:
+				c.Format = lf.TextFormat
+			case *v3corepb.SubstitutionFormatString_JsonFormat:
+				c.JSONFormat = lf.JsonFormat
+			case *v3corepb.SubstitutionFormatString_TextFormatSource:
+				if c.Format = lf.TextFormatSource.GetInlineString(); c.Format == "" {
+					return nil, nackErrorf(ReasonUnsupportedField, "access log %q: text_format_source other than an inline string is not supported", c.Name)
+				}
+			}
+		}
+		c.Supported = true
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
+// processUpgradeConfigs converts the upgrade_configs of an
+// HttpConnectionManager, see ListenerUpdate.UpgradeConfigs.
+func processUpgradeConfigs(ucs []*v3httppb.HttpConnectionManager_UpgradeConfig) (map[string]bool, error) {
+	if len(ucs) == 0 {
+		return nil, nil
+	}
+	ret := make(map[string]bool, len(ucs))
+	for _, uc := range ucs {
+		t := strings.ToLower(uc.GetUpgradeType())
+		if t == "" {
+			return nil, nackErrorf(ReasonInvalidHTTPConnManager, "upgrade_configs entry without an upgrade_type")
+		}
+		if _, ok := ret[t]; ok {
+			return nil, nackErrorf(ReasonInvalidHTTPConnManager, "duplicate upgrade_type %q in upgrade_configs", t)
+		}
+		// Upgraded connections go through the filters of the listener.
+		if len(uc.GetFilters()) != 0 {
+			return nil, nackErrorf(ReasonUnsupportedField, "unsupported filters in upgrade_configs entry %q", t)
+		}
+		// "Determines if upgrades are enabled or disabled by default.
+		// Defaults to true."
+		ret[t] = uc.GetEnabled() == nil || uc.GetEnabled().GetValue()
+	}
+	return ret, nil
+}
+
+// maxTypedStructDepth is the number of nested TypedStructs which
+// unwrapHTTPFilterConfig unwraps.
+const maxTypedStructDepth = 2
+
+// typedStruct is implemented by both the new and the old TypedStruct message.
+type typedStruct interface {
+	proto.Message
+	GetTypeUrl() string
+	GetValue() *structpb.Struct
+}
+
 func unwrapHTTPFilterConfig(config *anypb.Any) (proto.Message, string, error) {
+	var s typedStruct
 	switch {
 	case ptypes.Is(config, &v3cncftypepb.TypedStruct{}):
 		// The real type name is inside the new TypedStruct message.
-		s := new(v3cncftypepb.TypedStruct)
-		if err := ptypes.UnmarshalAny(config, s); err != nil {
-			return nil, "", fmt.Errorf("error unmarshaling TypedStruct filter config: %v", err)
-		}
-		return s, s.GetTypeUrl(), nil
+		s = new(v3cncftypepb.TypedStruct)
 	case ptypes.Is(config, &v1udpatypepb.TypedStruct{}):
 		// The real type name is inside the old TypedStruct message.
-		s := new(v1udpatypepb.TypedStruct)
-		if err := ptypes.UnmarshalAny(config, s); err != nil {
-			return nil, "", fmt.Errorf("error unmarshaling TypedStruct filter config: %v", err)
-		}
-		return s, s.GetTypeUrl(), nil
+		s = new(v1udpatypepb.TypedStruct)
 	default:
 		return config, config.GetTypeUrl(), nil
 	}
+	if err := ptypes.UnmarshalAny(config, s); err != nil {
+		return nil, "", fmt.Errorf("error unmarshaling TypedStruct filter config: %v", err)
+	}
+	// A TypedStruct may wrap another one, whose fields are then encoded in
+	// the value. Only a bounded number of levels is unwrapped, so the real
+	// type can't be hidden behind arbitrarily deep nesting.
+	for depth := 1; isTypedStructURL(s.GetTypeUrl()); depth++ {
+		if depth == maxTypedStructDepth {
+			return nil, "", fmt.Errorf("TypedStruct filter config is nested more than %d levels deep", maxTypedStructDepth)
+		}
+		fields := s.GetValue().GetFields()
+		typeURL := fields["type_url"].GetStringValue()
+		if typeURL == "" {
+			return nil, "", fmt.Errorf("nested TypedStruct filter config has no type_url: %v", s)
+		}
+		s = &v3cncftypepb.TypedStruct{TypeUrl: typeURL, Value: fields["value"].GetStructValue()}
+	}
+	// Filters only get the new TypedStruct, see httpfilter.Filter.
+	if _, ok := s.(*v3cncftypepb.TypedStruct); !ok {
+		s = &v3cncftypepb.TypedStruct{TypeUrl: s.GetTypeUrl(), Value: s.GetValue()}
+	}
+	return s, s.GetTypeUrl(), nil
+}
+
+// isTypedStructURL reports whether typeURL names the new or the old
+// TypedStruct message, with any type URL prefix.
+func isTypedStructURL(typeURL string) bool {
+	switch typeURL[strings.LastIndex(typeURL, "/")+1:] {
+	case "xds.type.v3.TypedStruct", "udpa.type.v1.TypedStruct":
+		return true
+	default:
+		return false
+	}
 }
 
-func validateHTTPFilterConfig(cfg *anypb.Any, lds, optional bool) (httpfilter.Filter, httpfilter.FilterConfig, error) {
+// errNoFilterImplementation is returned, wrapped, by validateHTTPFilterConfig
+// for a filter type which has no registered implementation.
+var errNoFilterImplementation = errors.New("no filter implementation found")
+
+func validateHTTPFilterConfig(cfg *anypb.Any, lds, optional bool, logger dubboLogger.Logger) (httpfilter.Filter, httpfilter.FilterConfig, error) {
 	config, typeURL, err := unwrapHTTPFilterConfig(cfg)
 	if err != nil {
 		return nil, nil, err
@@ -184,7 +483,7 @@ func validateHTTPFilterConfig(cfg *anypb.Any, lds, optional bool) (httpfilter.Fi
 		if optional {
 			return nil, nil, nil
 		}
-		return nil, nil, fmt.Errorf("no filter implementation found for %q", typeURL)
+		return nil, nil, fmt.Errorf("%w for %q", errNoFilterImplementation, typeURL)
 	}
 	parseFunc := filterBuilder.ParseFilterConfig
 	if !lds {
@@ -192,33 +491,91 @@ func validateHTTPFilterConfig(cfg *anypb.Any, lds, optional bool) (httpfilter.Fi
 	}
 	filterConfig, err := parseFunc(config)
 	if err != nil {
+		if errors.Is(err, httpfilter.ErrSkipFilter) {
+			logger.Debugf("Skipping HTTP filter %q: %v", typeURL, err)
+			return nil, nil, nil
+		}
+		if optional && errors.Is(err, httpfilter.ErrUnsupportedExtension) {
+			return nil, nil, nil
+		}
 		return nil, nil, fmt.Errorf("error parsing config for filter %q: %v", typeURL, err)
 	}
 	return filterBuilder, filterConfig, nil
 }
 
-func processHTTPFilterOverrides(cfgs map[string]*anypb.Any) (map[string]httpfilter.FilterConfig, error) {
+// filterConfigCache memoizes validateHTTPFilterConfig by the type URL and the
+// serialized bytes of the config. It is not safe for concurrent use.
+type filterConfigCache struct {
+	m map[filterConfigKey]filterConfigResult
+}
+
+type filterConfigKey struct {
+	typeURL       string
+	value         string
+	lds, optional bool
+}
+
+type filterConfigResult struct {
+	filter httpfilter.Filter
+	config httpfilter.FilterConfig
+	err    error
+}
+
+func newFilterConfigCache() *filterConfigCache {
+	return &filterConfigCache{m: make(map[filterConfigKey]filterConfigResult)}
+}
+
+// validateHTTPFilterConfig is validateHTTPFilterConfig, returning the cached
+// result for a config seen before. A nil cache parses every config.
+func (c *filterConfigCache) validateHTTPFilterConfig(cfg *anypb.Any, lds, optional bool, logger dubboLogger.Logger) (httpfilter.Filter, httpfilter.FilterConfig, error) {
+	if c == nil {
+		return validateHTTPFilterConfig(cfg, lds, optional, logger)
+	}
+	key := filterConfigKey{typeURL: cfg.GetTypeUrl(), value: string(cfg.GetValue()), lds: lds, optional: optional}
+	if r, ok := c.m[key]; ok {
+		return r.filter, r.config, r.err
+	}
+	filter, config, err := validateHTTPFilterConfig(cfg, lds, optional, logger)
+	c.m[key] = filterConfigResult{filter: filter, config: config, err: err}
+	return filter, config, err
+}
+
+// unwrapFilterConfig returns the config wrapped in cfg if it is a
+// FilterConfig, and whether the FilterConfig marks it optional. Other configs
+// are returned as is.
+func unwrapFilterConfig(cfg *anypb.Any) (*anypb.Any, bool, error) {
+	s := new(v3routepb.FilterConfig)
+	if !ptypes.Is(cfg, s) {
+		return cfg, false, nil
+	}
+	if err := ptypes.UnmarshalAny(cfg, s); err != nil {
+		return nil, false, fmt.Errorf("error unmarshaling FilterConfig: %v", err)
+	}
+	return s.GetConfig(), s.GetIsOptional(), nil
+}
+
+func processHTTPFilterOverrides(cfgs map[string]*anypb.Any, logger dubboLogger.Logger) (map[string]httpfilter.FilterConfig, error) {
 	if len(cfgs) == 0 {
 		return nil, nil
 	}
 	m := make(map[string]httpfilter.FilterConfig)
 	for name, cfg := range cfgs {
-		optional := false
-		s := new(v3routepb.FilterConfig)
-		if ptypes.Is(cfg, s) {
-			if err := ptypes.UnmarshalAny(cfg, s); err != nil {
-				return nil, fmt.Errorf("filter override %q: error unmarshaling FilterConfig: %v", name, err)
-			}
-			cfg = s.GetConfig()
-			optional = s.GetIsOptional()
+		cfg, optional, err := unwrapFilterConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("filter override %q: %v", name, err)
+		}
+		if cfg.GetTypeUrl() == corsPolicyTypeURL && httpfilter.Get(corsPolicyTypeURL) == nil {
+			// CORS policies are skipped, not NACKed, when the CORS filter is
+			// not registered.
+			continue
 		}
 
-		httpFilter, config, err := validateHTTPFilterConfig(cfg, false, optional)
+		httpFilter, config, err := validateHTTPFilterConfig(cfg, false, optional, logger)
 		if err != nil {
 			return nil, fmt.Errorf("filter override %q: %v", name, err)
 		}
 		if httpFilter == nil {
-			// Optional configs are ignored.
+			// Optional and skipped configs are ignored.
 			continue
 		}
 		m[name] = config
@@ -226,87 +583,284 @@ func processHTTPFilterOverrides(cfgs map[string]*anypb.Any) (map[string]httpfilt
 	return m, nil
 }
 
-func processHTTPFilters(filters []*v3httppb.HttpFilter, server bool) ([]HTTPFilter, error) {
+// processHTTPFilters validates and parses the HTTP filters of an
+// HttpConnectionManager. v2 listeners do not require a router filter, so with
+// v2 set an empty list is accepted and the terminal filter checks are skipped.
+// The filter configs are parsed through cache, which may be nil. With
+// treatUnknownAsOptional set, filters without a registered implementation are
+// skipped with a warning logged to logger, see
+// UnmarshalOptions.TreatUnknownFiltersAsOptional. Optional terminal filters
+// which are not last are skipped as well.
+func processHTTPFilters(filters []*v3httppb.HttpFilter, server, v2 bool, cache *filterConfigCache, treatUnknownAsOptional bool, logger dubboLogger.Logger) ([]HTTPFilter, error) {
 	ret := make([]HTTPFilter, 0, len(filters))
+	// optional records which filters of ret are optional.
+	optional := make([]bool, 0, len(filters))
 	seenNames := make(map[string]bool, len(filters))
 	for _, filter := range filters {
 		name := filter.GetName()
 		if name == "" {
-			return nil, errors.New("filter missing name field")
+			return nil, nackErrorf(ReasonInvalidHTTPFilter, "filter missing name field")
 		}
 		if seenNames[name] {
-			return nil, fmt.Errorf("duplicate filter name %q", name)
+			return nil, nackErrorf(ReasonInvalidHTTPFilter, "duplicate filter name %q", name)
 		}
 		seenNames[name] = true
 
-		httpFilter, config, err := validateHTTPFilterConfig(filter.GetTypedConfig(), true, filter.GetIsOptional())
+		// The typed_config may be wrapped in a FilterConfig, as overrides are;
+		// the filter is optional if either marks it so.
+		cfg, wrappedOptional, err := unwrapFilterConfig(filter.GetTypedConfig())
 		if err != nil {
-			return nil, err
+			return nil, nackErrorf(ReasonInvalidHTTPFilter, "filter %q: %v", name, err)
+		}
+		isOptional := filter.GetIsOptional() || wrappedOptional
+		httpFilter, config, err := cache.validateHTTPFilterConfig(cfg, true, isOptional, logger)
+		if err != nil {
+			if treatUnknownAsOptional && errors.Is(err, errNoFilterImplementation) {
+				logger.Warnf("Skipping required HTTP filter %q: %v", name, err)
+				continue
+			}
+			return nil, &NACKError{Reason: ReasonInvalidHTTPFilter, Err: err}
 		}
 		if httpFilter == nil {
-			// Optional configs are ignored.
+			// Optional and skipped configs are ignored.
 			continue
 		}
 		if server {
 			if _, ok := httpFilter.(httpfilter.ServerInterceptorBuilder); !ok {
-				if filter.GetIsOptional() {
+				if isOptional {
 					continue
 				}
-				return nil, fmt.Errorf("HTTP filter %q not supported server-side", name)
+				return nil, nackErrorf(ReasonInvalidHTTPFilter, "HTTP filter %q not supported server-side", name)
 			}
 		} else if _, ok := httpFilter.(httpfilter.ClientInterceptorBuilder); !ok {
-			if filter.GetIsOptional() {
+			if isOptional {
 				continue
 			}
-			return nil, fmt.Errorf("HTTP filter %q not supported client-side", name)
+			return nil, nackErrorf(ReasonInvalidHTTPFilter, "HTTP filter %q not supported client-side", name)
 		}
 
 		// Save name/config
-		ret = append(ret, HTTPFilter{Name: name, Filter: httpFilter, Config: config})
+		ret = append(ret, HTTPFilter{Name: name, Filter: httpFilter, Config: config, RawConfig: cfg})
+		optional = append(optional, isOptional)
+	}
+	if v2 {
+		return ret, nil
 	}
 	// "Validation will fail if a terminal filter is not the last filter in the
 	// chain or if a non-terminal filter is the last filter in the chain." - A39
 	if len(ret) == 0 {
-		return nil, fmt.Errorf("http filters list is empty")
+		return nil, nackErrorf(ReasonEmptyHTTPFilters, "http filters list is empty")
 	}
-	var i int
-	for ; i < len(ret)-1; i++ {
-		if ret[i].Filter.IsTerminal() {
-			return nil, fmt.Errorf("http filter %q is a terminal filter but it is not last in the filter chain", ret[i].Name)
+	// Some control planes emit an optional terminal filter followed by the
+	// router. Such a filter is skipped, as if it were unsupported; the last
+	// remaining filter must still be terminal.
+	kept := ret[:0]
+	for j, f := range ret {
+		if j < len(ret)-1 && f.Filter.IsTerminal() {
+			if !optional[j] {
+				return nil, nackErrorf(ReasonTerminalFilterNotLast, "http filter %q is a terminal filter but it is not last in the filter chain", f.Name)
+			}
+			logger.Debugf("Skipping optional terminal HTTP filter %q, which is not last in the filter chain", f.Name)
+			continue
 		}
+		kept = append(kept, f)
 	}
+	ret = kept
+	i := len(ret) - 1
 	if !ret[i].Filter.IsTerminal() {
-		return nil, fmt.Errorf("http filter %q is not a terminal filter", ret[len(ret)-1].Name)
+		return nil, nackErrorf(ReasonMissingTerminalFilter, "http filter %q is not a terminal filter", ret[len(ret)-1].Name)
+	}
+	// The router is the only terminal filter supported.
+	if !router.IsRouterFilter(ret[i].Filter) {
+		return nil, nackErrorf(ReasonMissingTerminalFilter, "terminal http filter %q is not the router filter", ret[i].Name)
 	}
 	return ret, nil
 }
 
-func processServerSideListener(lis *v3listenerpb.Listener, logger dubboLogger.Logger) (*ListenerUpdate, error) {
-	if n := len(lis.ListenerFilters); n != 0 {
-		return nil, fmt.Errorf("unsupported field 'listener_filters' contains %d entries", n)
+const (
+	tlsInspectorName  = "envoy.filters.listener.tls_inspector"
+	httpInspectorName = "envoy.filters.listener.http_inspector"
+)
+
+// noopListenerFilters maps the type URLs of the listener filters accepted on
+// server-side Listeners to their names. These filters only inspect the start
+// of a connection, for the filter chain match, and don't otherwise change its
+// handling.
+var noopListenerFilters = map[string]string{
+	"type.googleapis.com/envoy.extensions.filters.listener.tls_inspector.v3.TlsInspector":   tlsInspectorName,
+	"type.googleapis.com/envoy.extensions.filters.listener.http_inspector.v3.HttpInspector": httpInspectorName,
+}
+
+// processListenerFilters validates the listener_filters of a server-side
+// Listener, and returns the names of the inspectors present. A filter without
+// a typed_config is identified by its name.
+func processListenerFilters(filters []*v3listenerpb.ListenerFilter) (map[string]bool, error) {
+	present := make(map[string]bool)
+	for _, f := range filters {
+		name, ok := noopListenerFilters[f.GetTypedConfig().GetTypeUrl()]
+		if f.GetTypedConfig() == nil {
+			name, ok = f.GetName(), f.GetName() == tlsInspectorName || f.GetName() == httpInspectorName
+		}
+		if !ok {
+			return nil, nackErrorf(ReasonUnsupportedField, "unsupported listener filter %q", f.GetName())
+		}
+		present[name] = true
 	}
-	if useOrigDst := lis.GetUseOriginalDst(); useOrigDst != nil && useOrigDst.GetValue() {
-		return nil, errors.New("unsupported field 'use_original_dst' is present and set to true")
+	return present, nil
+}
+
+func processServerSideListener(lis *v3listenerpb.Listener, opts *UnmarshalOptions) (*ListenerUpdate, error) {
+	inspectors, err := processListenerFilters(lis.GetListenerFilters())
+	if err != nil {
+		return nil, err
+	}
+	useOrigDst := lis.GetUseOriginalDst().GetValue()
+	if useOrigDst && !opts.AllowOriginalDst {
+		return nil, nackErrorf(ReasonUnsupportedField, "unsupported field 'use_original_dst' is present and set to true")
 	}
 	addr := lis.GetAddress()
 	if addr == nil {
-		return nil, fmt.Errorf("no address field in LDS response: %+v", lis)
+		return nil, nackErrorf(ReasonMissingAddress, "no address field in LDS response: %+v", lis)
 	}
 	sockAddr := addr.GetSocketAddress()
 	if sockAddr == nil {
-		return nil, fmt.Errorf("no socket_address field in LDS response: %+v", lis)
+		return nil, nackErrorf(ReasonMissingAddress, "no socket_address field in LDS response: %+v", lis)
+	}
+	host, port, err := parseSocketAddress(sockAddr)
+	if err != nil {
+		return nil, &NACKError{Reason: ReasonInvalidAddress, Err: err}
+	}
+	additional, err := processAdditionalAddresses(lis.GetAdditionalAddresses(), ListenerAddress{Address: host, Port: port})
+	if err != nil {
+		return nil, err
 	}
 	lu := &ListenerUpdate{
 		InboundListenerCfg: &InboundListenerConfig{
-			Address: sockAddr.GetAddress(),
-			Port:    strconv.Itoa(int(sockAddr.GetPortValue())),
+			Address:             host,
+			Port:                port,
+			AdditionalAddresses: additional,
+			UseOriginalDst:      useOrigDst,
+			TLSInspector:        inspectors[tlsInspectorName],
+			DrainType:           drainTypeFromProto(lis.GetDrainType(), opts.Logger),
 		},
 	}
+	lu.InboundListenerCfg.PerConnectionBufferLimitBytes = lis.GetPerConnectionBufferLimitBytes().GetValue()
 
-	//fcMgr, err := NewFilterChainManager(lis, logger)
-	//if err != nil {
-	//	return nil, err
-	//}
-	//lu.InboundListenerCfg.FilterChains = fcMgr
+	fcMgr, err := newFilterChainManager(lis, opts)
+	if err != nil {
+		return nil, &NACKError{Reason: ReasonInvalidFilterChain, Err: err}
+	}
+	lu.InboundListenerCfg.FilterChains = fcMgr
+	lu.InboundListenerCfg.DefaultFilterChain = fcMgr.DefaultFilterChain()
+	if !inspectors[tlsInspectorName] {
+		warnClientHelloMatches(lis, opts.Logger)
+	}
 	return lu, nil
 }
+
+// warnClientHelloMatches warns about the filter chains of lis matching on the
+// TLS ClientHello. Without the TLS inspector the ClientHello isn't read, so
+// connections are matched as raw_buffer without a server name and these
+// filter chains never match.
+func warnClientHelloMatches(lis *v3listenerpb.Listener, logger dubboLogger.Logger) {
+	for _, fc := range lis.GetFilterChains() {
+		m := fc.GetFilterChainMatch()
+		if len(m.GetServerNames()) != 0 || m.GetTransportProtocol() == transportProtocolTLS || len(m.GetApplicationProtocols()) != 0 {
+			logger.Warnf("Filter chain %q of listener %q matches on the TLS ClientHello, but the listener has no TLS inspector: it will never match", fc.GetName(), lis.GetName())
+		}
+	}
+}
+
+// drainTypeFromProto converts the drain_type of a listener. Unknown drain
+// types are treated as DEFAULT.
+func drainTypeFromProto(dt v3listenerpb.Listener_DrainType, logger dubboLogger.Logger) DrainType {
+	switch dt {
+	case v3listenerpb.Listener_DEFAULT:
+		return DrainTypeDefault
+	case v3listenerpb.Listener_MODIFY_ONLY:
+		return DrainTypeModifyOnly
+	default:
+		logger.Debugf("Unknown drain_type %v, using DEFAULT", dt)
+		return DrainTypeDefault
+	}
+}
+
+// trafficDirectionFromProto converts the traffic_direction of a listener.
+// Unknown directions are treated as UNSPECIFIED.
+func trafficDirectionFromProto(td v3corepb.TrafficDirection, logger dubboLogger.Logger) TrafficDirection {
+	switch td {
+	case v3corepb.TrafficDirection_UNSPECIFIED:
+		return TrafficDirectionUnspecified
+	case v3corepb.TrafficDirection_INBOUND:
+		return TrafficDirectionInbound
+	case v3corepb.TrafficDirection_OUTBOUND:
+		return TrafficDirectionOutbound
+	default:
+		logger.Debugf("Unknown traffic_direction %v, using UNSPECIFIED", td)
+		return TrafficDirectionUnspecified
+	}
+}
+
+// processAdditionalAddresses validates the additional_addresses of a
+// server-side listener whose primary address is primary. No two addresses may
+// be the same.
+func processAdditionalAddresses(addrs []*v3listenerpb.AdditionalAddress, primary ListenerAddress) ([]ListenerAddress, error) {
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+	seen := map[ListenerAddress]bool{primary: true}
+	ret := make([]ListenerAddress, 0, len(addrs))
+	for i, a := range addrs {
+		sockAddr := a.GetAddress().GetSocketAddress()
+		if sockAddr == nil {
+			return nil, nackErrorf(ReasonMissingAddress, "no socket_address field in additional address %d: %+v", i, a)
+		}
+		host, port, err := parseSocketAddress(sockAddr)
+		if err != nil {
+			return nil, nackErrorf(ReasonInvalidAddress, "additional address %d: %v", i, err)
+		}
+		la := ListenerAddress{Address: host, Port: port}
+		if seen[la] {
+			return nil, nackErrorf(ReasonInvalidAddress, "additional address %d duplicates address %s", i, net.JoinHostPort(host, port))
+		}
+		seen[la] = true
+		ret = append(ret, la)
+	}
+	return ret, nil
+}
+
+// parseSocketAddress validates the address and port of a server-side
+// listener. The address must be an IP, which is returned in its canonical
+// form, or a host name. The port must be in [1, 65535].
+func parseSocketAddress(sa *v3corepb.SocketAddress) (host, port string, err error) {
+	host = sa.GetAddress()
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	} else if !isValidHostName(host) {
+		return "", "", fmt.Errorf("socket_address %+v: address %q is neither an IP nor a host name", sa, host)
+	}
+	p := sa.GetPortValue()
+	if p == 0 || p > 65535 {
+		return "", "", fmt.Errorf("socket_address %+v: port %d is not in [1, 65535]", sa, p)
+	}
+	return host, strconv.Itoa(int(p)), nil
+}
+
+// isValidHostName reports whether s is a syntactically valid DNS host name.
+func isValidHostName(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
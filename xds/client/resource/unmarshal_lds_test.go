@@ -0,0 +1,898 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+import (
+	v1udpatypepb "github.com/cncf/udpa/go/udpa/type/v1"
+	v3cncftypepb "github.com/cncf/xds/go/xds/type/v3"
+
+	v3accesslogpb "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	v3routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	v3routerpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	v3httppb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+import (
+	dubboLogger "dubbo.apache.org/dubbo-go/v3/common/logger"
+	"dubbo.apache.org/dubbo-go/v3/xds/client/resource/version"
+	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
+	_ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/router"
+	iresolver "dubbo.apache.org/dubbo-go/v3/xds/utils/resolver"
+)
+
+// capturingLogger is a dubboLogger.Logger which records every line logged.
+type capturingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *capturingLogger) log(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, line)
+}
+
+func (l *capturingLogger) hasPrefix(prefix string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *capturingLogger) Info(args ...interface{})  { l.log(fmt.Sprint(args...)) }
+func (l *capturingLogger) Warn(args ...interface{})  { l.log(fmt.Sprint(args...)) }
+func (l *capturingLogger) Error(args ...interface{}) { l.log(fmt.Sprint(args...)) }
+func (l *capturingLogger) Debug(args ...interface{}) { l.log(fmt.Sprint(args...)) }
+func (l *capturingLogger) Fatal(args ...interface{}) { l.log(fmt.Sprint(args...)) }
+
+func (l *capturingLogger) Infof(format string, args ...interface{}) {
+	l.log(fmt.Sprintf(format, args...))
+}
+
+func (l *capturingLogger) Warnf(format string, args ...interface{}) {
+	l.log(fmt.Sprintf(format, args...))
+}
+
+func (l *capturingLogger) Errorf(format string, args ...interface{}) {
+	l.log(fmt.Sprintf(format, args...))
+}
+
+func (l *capturingLogger) Debugf(format string, args ...interface{}) {
+	l.log(fmt.Sprintf(format, args...))
+}
+
+func (l *capturingLogger) Fatalf(format string, args ...interface{}) {
+	l.log(fmt.Sprintf(format, args...))
+}
+
+// clientListenerResource returns a client side Listener resource with a route
+// configuration name and a router filter.
+func clientListenerResource(t *testing.T, name string) *anypb.Any {
+	t.Helper()
+	routerCfg, err := ptypes.MarshalAny(&v3routerpb.Router{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hcm, err := ptypes.MarshalAny(&v3httppb.HttpConnectionManager{
+		RouteSpecifier: &v3httppb.HttpConnectionManager_Rds{
+			Rds: &v3httppb.Rds{
+				ConfigSource: &v3corepb.ConfigSource{
+					ConfigSourceSpecifier: &v3corepb.ConfigSource_Ads{Ads: &v3corepb.AggregatedConfigSource{}},
+				},
+				RouteConfigName: "route",
+			},
+		},
+		HttpFilters: []*v3httppb.HttpFilter{{
+			Name:       "router",
+			ConfigType: &v3httppb.HttpFilter_TypedConfig{TypedConfig: routerCfg},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := ptypes.MarshalAny(&v3listenerpb.Listener{
+		Name:        name,
+		ApiListener: &v3listenerpb.ApiListener{ApiListener: hcm},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lis
+}
+
+func TestUnmarshalListenerUsesInjectedLogger(t *testing.T) {
+	global := &capturingLogger{}
+	oldGlobal := dubboLogger.GetLogger()
+	dubboLogger.SetLogger(global)
+	defer dubboLogger.SetLogger(oldGlobal)
+
+	injected := &capturingLogger{}
+	update, _, err := UnmarshalListener(&UnmarshalOptions{
+		Resources: []*anypb.Any{clientListenerResource(t, "test-listener")},
+		Logger:    injected,
+	})
+	if err != nil {
+		t.Fatalf("UnmarshalListener() failed: %v", err)
+	}
+	if got := update["test-listener"].Update.RouteConfigName; got != "route" {
+		t.Fatalf("RouteConfigName = %q, want %q", got, "route")
+	}
+
+	const prefix = "Resource with name: test-listener"
+	if !injected.hasPrefix(prefix) {
+		t.Errorf("injected logger got lines %q, want a line starting with %q", injected.lines, prefix)
+	}
+	if global.hasPrefix(prefix) {
+		t.Errorf("global logger got a line starting with %q, want none", prefix)
+	}
+}
+
+func TestUnmarshalListenerDisableV2(t *testing.T) {
+	// v2 and v3 Listeners are wire compatible, so the v3 resource is simply
+	// relabeled as v2.
+	v2Lis := clientListenerResource(t, "test-listener")
+	v2Lis.TypeUrl = version.V2ListenerURL
+
+	tests := []struct {
+		name      string
+		disableV2 bool
+		wantErr   bool
+	}{
+		{name: "v2 enabled", disableV2: false},
+		{name: "v2 disabled", disableV2: true, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			update, md, err := UnmarshalListener(&UnmarshalOptions{
+				Resources: []*anypb.Any{v2Lis},
+				Logger:    &capturingLogger{},
+				DisableV2: test.disableV2,
+			})
+			if err != nil {
+				t.Fatalf("UnmarshalListener() failed: %v", err)
+			}
+			got, ok := update["test-listener"]
+			if !ok {
+				t.Fatalf("UnmarshalListener() returned no entry for %q", "test-listener")
+			}
+			if !test.wantErr {
+				if md.Status != ServiceStatusACKed {
+					t.Errorf("Status = %v, want %v", md.Status, ServiceStatusACKed)
+				}
+				if got.Update.RouteConfigName != "route" {
+					t.Errorf("RouteConfigName = %q, want %q", got.Update.RouteConfigName, "route")
+				}
+				return
+			}
+			if got.Err == nil {
+				t.Fatal("UnmarshalListener() returned no error for the listener, want one")
+			}
+			if md.Status != ServiceStatusNACKed {
+				t.Errorf("Status = %v, want %v", md.Status, ServiceStatusNACKed)
+			}
+			if r := NACKReasonOf(got.Err); r != ReasonUnexpectedResourceType {
+				t.Errorf("NACKReasonOf(%v) = %v, want %v", got.Err, r, ReasonUnexpectedResourceType)
+			}
+		})
+	}
+}
+
+func TestUnmarshalListenerRecoversNameOnFailure(t *testing.T) {
+	lis := clientListenerResource(t, "test-listener")
+	// Cutting off the last byte leaves the name, which is serialized first,
+	// intact but makes the api_listener undecodable.
+	lis.Value = lis.Value[:len(lis.Value)-1]
+
+	update, _, err := UnmarshalListener(&UnmarshalOptions{
+		Resources: []*anypb.Any{lis},
+		Logger:    &capturingLogger{},
+	})
+	// The error is attributed to the listener, so it doesn't fail the
+	// response.
+	if err != nil {
+		t.Fatalf("UnmarshalListener() failed: %v", err)
+	}
+	got, ok := update["test-listener"]
+	if !ok {
+		t.Fatalf("UnmarshalListener() returned %v, want an entry for %q", update, "test-listener")
+	}
+	if r := NACKReasonOf(got.Err); r != ReasonUnmarshalFailed {
+		t.Errorf("NACKReasonOf(%v) = %v, want %v", got.Err, r, ReasonUnmarshalFailed)
+	}
+}
+
+func TestUnmarshalListenerPartialFailure(t *testing.T) {
+	bad := clientListenerResource(t, "bad-listener")
+	bad.Value = bad.Value[:len(bad.Value)-1]
+	unnamed := clientListenerResource(t, "")
+	unnamed.Value = unnamed.Value[:len(unnamed.Value)-1]
+
+	tests := []struct {
+		name      string
+		resources []*anypb.Any
+		wantErr   bool
+	}{
+		{
+			name:      "invalid named listener",
+			resources: []*anypb.Any{clientListenerResource(t, "good-listener"), bad},
+		},
+		{
+			name:      "invalid unnamed listener",
+			resources: []*anypb.Any{clientListenerResource(t, "good-listener"), unnamed},
+			wantErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			update, md, err := UnmarshalListener(&UnmarshalOptions{
+				Resources: test.resources,
+				Logger:    &capturingLogger{},
+			})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("UnmarshalListener() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if md.Status != ServiceStatusNACKed || md.ErrState == nil {
+				t.Errorf("UnmarshalListener() returned metadata %+v, want NACKed with an error state", md)
+			}
+			if got := update["good-listener"]; got.Err != nil || got.Update.RouteConfigName != "route" {
+				t.Errorf("good-listener = %+v, want a valid update", got)
+			}
+		})
+	}
+}
+
+// countingMetrics is a MetricsRecorder which counts the recorded NACKs.
+type countingMetrics struct {
+	mu    sync.Mutex
+	nacks map[ResourceType]map[NACKReason]int
+}
+
+func (m *countingMetrics) RecordNACK(rType ResourceType, reason NACKReason) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.nacks == nil {
+		m.nacks = make(map[ResourceType]map[NACKReason]int)
+	}
+	if m.nacks[rType] == nil {
+		m.nacks[rType] = make(map[NACKReason]int)
+	}
+	m.nacks[rType][reason]++
+}
+
+func TestUnmarshalListenerRecordsNACKs(t *testing.T) {
+	bad := clientListenerResource(t, "bad-listener")
+	bad.Value = bad.Value[:len(bad.Value)-1]
+
+	metrics := &countingMetrics{}
+	if _, _, err := UnmarshalListener(&UnmarshalOptions{
+		Resources: []*anypb.Any{clientListenerResource(t, "good-listener"), bad},
+		Logger:    &capturingLogger{},
+		Metrics:   metrics,
+	}); err != nil {
+		t.Fatalf("UnmarshalListener() failed: %v", err)
+	}
+	want := map[ResourceType]map[NACKReason]int{
+		ListenerResource: {ReasonUnmarshalFailed: 1},
+	}
+	if !reflect.DeepEqual(metrics.nacks, want) {
+		t.Errorf("recorded NACKs %v, want %v", metrics.nacks, want)
+	}
+}
+
+func TestParseSocketAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		addr     string
+		port     uint32
+		wantHost string
+		wantErr  bool
+	}{
+		{name: "ipv4", addr: "0.0.0.0", port: 8080, wantHost: "0.0.0.0"},
+		{name: "ipv6 wildcard", addr: "::", port: 8080, wantHost: "::"},
+		{name: "ipv6 canonicalized", addr: "2001:db8:0:0::1", port: 8080, wantHost: "2001:db8::1"},
+		{name: "host name", addr: "localhost", port: 8080, wantHost: "localhost"},
+		{name: "bracketed ipv6", addr: "[::1]", port: 8080, wantErr: true},
+		{name: "empty address", addr: "", port: 8080, wantErr: true},
+		{name: "zero port", addr: "0.0.0.0", port: 0, wantErr: true},
+		{name: "port too large", addr: "0.0.0.0", port: 65536, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			host, port, err := parseSocketAddress(&v3corepb.SocketAddress{
+				Address:       test.addr,
+				PortSpecifier: &v3corepb.SocketAddress_PortValue{PortValue: test.port},
+			})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseSocketAddress() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if host != test.wantHost || port != "8080" {
+				t.Errorf("parseSocketAddress() = (%q, %q), want (%q, %q)", host, port, test.wantHost, "8080")
+			}
+		})
+	}
+}
+
+func TestUnmarshalListenerStreamMaxResourceBytes(t *testing.T) {
+	small, large := clientListenerResource(t, "small"), clientListenerResource(t, "large-listener-name")
+	metrics := &countingMetrics{}
+	got := make(map[string]ListenerUpdateErrTuple)
+	_, err := UnmarshalListenerStream(&UnmarshalOptions{
+		Resources:        []*anypb.Any{small, large},
+		Logger:           &capturingLogger{},
+		MaxResourceBytes: len(small.GetValue()),
+		Metrics:          metrics,
+	}, func(name string, tuple ListenerUpdateErrTuple) error {
+		got[name] = tuple
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UnmarshalListenerStream() failed: %v", err)
+	}
+	if got["small"].Err != nil {
+		t.Errorf("small listener returned err %v, want nil", got["small"].Err)
+	}
+	if r := NACKReasonOf(got["large-listener-name"].Err); r != ReasonResourceTooLarge {
+		t.Errorf("NACKReasonOf(%v) = %v, want %v", got["large-listener-name"].Err, r, ReasonResourceTooLarge)
+	}
+	if n := metrics.nacks[ListenerResource][ReasonResourceTooLarge]; n != 1 {
+		t.Errorf("recorded %d NACKs for ResourceTooLarge, want 1", n)
+	}
+}
+
+func TestUnmarshalListenerMaxResourceBytes(t *testing.T) {
+	lis := clientListenerResource(t, "test-listener")
+	update, _, err := UnmarshalListener(&UnmarshalOptions{
+		Resources:        []*anypb.Any{lis},
+		Logger:           &capturingLogger{},
+		MaxResourceBytes: len(lis.GetValue()) - 1,
+	})
+	if err != nil {
+		t.Fatalf("UnmarshalListener() failed: %v", err)
+	}
+	got, ok := update["test-listener"]
+	if !ok {
+		t.Fatalf("UnmarshalListener() returned %v, want an entry for %q", update, "test-listener")
+	}
+	if r := NACKReasonOf(got.Err); r != ReasonResourceTooLarge {
+		t.Errorf("NACKReasonOf(%v) = %v, want %v", got.Err, r, ReasonResourceTooLarge)
+	}
+}
+
+func TestUnwrapHTTPFilterConfigNestedTypedStruct(t *testing.T) {
+	const (
+		typedStructURL = "type.googleapis.com/xds.type.v3.TypedStruct"
+		filterURL      = "type.googleapis.com/test.Filter"
+	)
+	// nested returns the fields of a TypedStruct of type typeURL, as encoded
+	// in the value of a TypedStruct wrapping it.
+	nested := func(typeURL string, value *structpb.Struct) *structpb.Struct {
+		return &structpb.Struct{Fields: map[string]*structpb.Value{
+			"type_url": structpb.NewStringValue(typeURL),
+			"value":    structpb.NewStructValue(value),
+		}}
+	}
+	tests := []struct {
+		name        string
+		ts          *v3cncftypepb.TypedStruct
+		wantTypeURL string
+		wantErr     bool
+	}{
+		{
+			name:        "one level",
+			ts:          &v3cncftypepb.TypedStruct{TypeUrl: filterURL},
+			wantTypeURL: filterURL,
+		},
+		{
+			name:        "two levels",
+			ts:          &v3cncftypepb.TypedStruct{TypeUrl: typedStructURL, Value: nested(filterURL, nil)},
+			wantTypeURL: filterURL,
+		},
+		{
+			name: "three levels",
+			ts: &v3cncftypepb.TypedStruct{
+				TypeUrl: typedStructURL,
+				Value:   nested(typedStructURL, nested(filterURL, nil)),
+			},
+			wantErr: true,
+		},
+		{
+			name:    "nested without type_url",
+			ts:      &v3cncftypepb.TypedStruct{TypeUrl: typedStructURL, Value: &structpb.Struct{}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := ptypes.MarshalAny(test.ts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, typeURL, err := unwrapHTTPFilterConfig(cfg)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unwrapHTTPFilterConfig() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if typeURL != test.wantTypeURL {
+				t.Errorf("unwrapHTTPFilterConfig() returned type URL %q, want %q", typeURL, test.wantTypeURL)
+			}
+		})
+	}
+}
+
+func TestUnmarshalListenerNotFound(t *testing.T) {
+	bad := clientListenerResource(t, "bad-listener")
+	bad.Value = bad.Value[:len(bad.Value)-1]
+
+	update, md, err := UnmarshalListener(&UnmarshalOptions{
+		Resources:     []*anypb.Any{clientListenerResource(t, "good-listener"), bad},
+		Logger:        &capturingLogger{},
+		ResourceNames: []string{"good-listener", "bad-listener", "missing-listener"},
+	})
+	if err != nil {
+		t.Fatalf("UnmarshalListener() failed: %v", err)
+	}
+	if got := update["good-listener"]; got.Err != nil {
+		t.Errorf("good-listener returned err %v, want nil", got.Err)
+	}
+	if got := update["bad-listener"].Err; !errors.Is(got, ErrResourceNACKed) || errors.Is(got, ErrResourceNotFound) {
+		t.Errorf("bad-listener returned err %v, want a NACK error", got)
+	}
+	got, ok := update["missing-listener"]
+	if !ok {
+		t.Fatalf("UnmarshalListener() returned %v, want an entry for %q", update, "missing-listener")
+	}
+	if !errors.Is(got.Err, ErrResourceNotFound) || errors.Is(got.Err, ErrResourceNACKed) || ErrType(got.Err) != ErrorTypeResourceNotFound {
+		t.Errorf("missing-listener returned err %v, want a not found error", got.Err)
+	}
+	// The missing listener doesn't cause the response to be NACKed, the
+	// invalid one does.
+	if md.ErrState == nil || strings.Contains(md.ErrState.Err.Error(), "missing-listener") {
+		t.Errorf("UnmarshalListener() returned error state %+v, want one for bad-listener only", md.ErrState)
+	}
+}
+
+func TestListenerUpdateEqual(t *testing.T) {
+	update, _, err := UnmarshalListener(&UnmarshalOptions{
+		Resources: []*anypb.Any{clientListenerResource(t, "a"), clientListenerResource(t, "b")},
+		Logger:    &capturingLogger{},
+	})
+	if err != nil {
+		t.Fatalf("UnmarshalListener() failed: %v", err)
+	}
+	a, b := update["a"].Update, update["b"].Update
+	// The listeners differ only by name, which is not part of the update.
+	if !a.Equal(b) {
+		t.Errorf("Equal() = false for listeners with the same config")
+	}
+	c := b.Clone()
+	c.RouteConfigName = "other-route"
+	if a.Equal(c) {
+		t.Errorf("Equal() = true for listeners with different route config names")
+	}
+	c = b.Clone()
+	c.HTTPFilters[0].RawConfig = nil
+	c.HTTPFilters[0].Config = nil
+	if a.Equal(c) {
+		t.Errorf("Equal() = true for listeners with different filter configs")
+	}
+	c = b.Clone()
+	c.Raw = nil
+	c.FilterMetadata = map[string]*structpb.Struct{"f": {}}
+	if !a.Equal(c) {
+		t.Errorf("Equal() = false for listeners differing only in Raw and FilterMetadata")
+	}
+}
+
+func TestUnwrapHTTPFilterConfigUDPATypedStruct(t *testing.T) {
+	const filterURL = "type.googleapis.com/test.Filter"
+	value := &structpb.Struct{Fields: map[string]*structpb.Value{"key": structpb.NewStringValue("v")}}
+	cfg, err := ptypes.MarshalAny(&v1udpatypepb.TypedStruct{TypeUrl: filterURL, Value: value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, typeURL, err := unwrapHTTPFilterConfig(cfg)
+	if err != nil {
+		t.Fatalf("unwrapHTTPFilterConfig() failed: %v", err)
+	}
+	// The old TypedStruct is passed to filters as the new one.
+	ts, ok := got.(*v3cncftypepb.TypedStruct)
+	if !ok {
+		t.Fatalf("unwrapHTTPFilterConfig() returned %T, want *v3cncftypepb.TypedStruct", got)
+	}
+	if typeURL != filterURL || ts.GetTypeUrl() != filterURL {
+		t.Errorf("unwrapHTTPFilterConfig() returned type URL %q (%q), want %q", typeURL, ts.GetTypeUrl(), filterURL)
+	}
+	if ts.GetValue().GetFields()["key"].GetStringValue() != "v" {
+		t.Errorf("unwrapHTTPFilterConfig() returned value %v, want %v", ts.GetValue(), value)
+	}
+}
+
+func TestProcessLocalReplyConfig(t *testing.T) {
+	statusFilter := &v3accesslogpb.AccessLogFilter{FilterSpecifier: &v3accesslogpb.AccessLogFilter_StatusCodeFilter{
+		StatusCodeFilter: &v3accesslogpb.StatusCodeFilter{Comparison: &v3accesslogpb.ComparisonFilter{
+			Op:    v3accesslogpb.ComparisonFilter_GE,
+			Value: &v3corepb.RuntimeUInt32{DefaultValue: 500},
+		}},
+	}}
+	// Traceable filters can't be evaluated.
+	unsupportedFilter := &v3accesslogpb.AccessLogFilter{FilterSpecifier: &v3accesslogpb.AccessLogFilter_TraceableFilter{
+		TraceableFilter: &v3accesslogpb.TraceableFilter{},
+	}}
+	tests := []struct {
+		name       string
+		mapper     *v3httppb.ResponseMapper
+		wantFilter bool
+		wantErr    bool
+	}{
+		{
+			name:       "supported filter",
+			mapper:     &v3httppb.ResponseMapper{Filter: statusFilter, StatusCode: wrapperspb.UInt32(503)},
+			wantFilter: true,
+		},
+		{
+			name:   "unsupported filter without status remap",
+			mapper: &v3httppb.ResponseMapper{Filter: unsupportedFilter, Body: &v3corepb.DataSource{Specifier: &v3corepb.DataSource_InlineString{InlineString: "error"}}},
+		},
+		{
+			name:    "unsupported filter with status remap",
+			mapper:  &v3httppb.ResponseMapper{Filter: unsupportedFilter, StatusCode: wrapperspb.UInt32(503)},
+			wantErr: true,
+		},
+		{
+			name:    "status code out of range",
+			mapper:  &v3httppb.ResponseMapper{Filter: statusFilter, StatusCode: wrapperspb.UInt32(100)},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lrc := &v3httppb.LocalReplyConfig{Mappers: []*v3httppb.ResponseMapper{test.mapper}}
+			got, err := processLocalReplyConfig(lrc, &capturingLogger{})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("processLocalReplyConfig() = %v, wantErr %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if len(got.Mappers) != 1 {
+				t.Fatalf("processLocalReplyConfig() returned %d mappers, want 1", len(got.Mappers))
+			}
+			if gotFilter := got.Mappers[0].Filter != nil; gotFilter != test.wantFilter {
+				t.Errorf("mapper has filter %v, want %v", gotFilter, test.wantFilter)
+			}
+		})
+	}
+}
+
+// terminalFilterTypeURL is the type URL of the configs of terminalFilter.
+const terminalFilterTypeURL = "type.googleapis.com/google.protobuf.BoolValue"
+
+// terminalFilter is a terminal client-side HTTP filter other than the router.
+type terminalFilter struct{}
+
+type terminalConfig struct {
+	httpfilter.FilterConfig
+}
+
+func (terminalFilter) TypeURLs() []string { return []string{terminalFilterTypeURL} }
+
+func (terminalFilter) ParseFilterConfig(proto.Message) (httpfilter.FilterConfig, error) {
+	return terminalConfig{}, nil
+}
+
+func (terminalFilter) ParseFilterConfigOverride(proto.Message) (httpfilter.FilterConfig, error) {
+	return terminalConfig{}, nil
+}
+
+func (terminalFilter) IsTerminal() bool { return true }
+
+func (terminalFilter) BuildClientInterceptor(_, _ httpfilter.FilterConfig) (iresolver.ClientInterceptor, error) {
+	return nil, nil
+}
+
+func TestProcessHTTPFiltersOptionalTerminalFilter(t *testing.T) {
+	httpfilter.Register(terminalFilter{})
+	defer httpfilter.UnregisterForTesting(terminalFilterTypeURL)
+
+	terminalCfg, err := ptypes.MarshalAny(wrapperspb.Bool(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	routerCfg, err := ptypes.MarshalAny(&v3routerpb.Router{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	terminal := func(name string, optional bool) *v3httppb.HttpFilter {
+		return &v3httppb.HttpFilter{
+			Name:       name,
+			ConfigType: &v3httppb.HttpFilter_TypedConfig{TypedConfig: terminalCfg},
+			IsOptional: optional,
+		}
+	}
+	router := &v3httppb.HttpFilter{
+		Name:       "router",
+		ConfigType: &v3httppb.HttpFilter_TypedConfig{TypedConfig: routerCfg},
+	}
+	tests := []struct {
+		name       string
+		filters    []*v3httppb.HttpFilter
+		wantNames  []string
+		wantReason NACKReason
+	}{
+		{
+			name:      "optional terminal mid-chain",
+			filters:   []*v3httppb.HttpFilter{terminal("ext-proc", true), router},
+			wantNames: []string{"router"},
+		},
+		{
+			name:      "several optional terminals mid-chain",
+			filters:   []*v3httppb.HttpFilter{terminal("a", true), terminal("b", true), router},
+			wantNames: []string{"router"},
+		},
+		{
+			name:       "required terminal mid-chain",
+			filters:    []*v3httppb.HttpFilter{terminal("ext-proc", false), router},
+			wantReason: ReasonTerminalFilterNotLast,
+		},
+		{
+			name:       "optional terminal last",
+			filters:    []*v3httppb.HttpFilter{router, terminal("ext-proc", true)},
+			wantReason: ReasonTerminalFilterNotLast,
+		},
+		{
+			name:       "only optional terminal",
+			filters:    []*v3httppb.HttpFilter{terminal("ext-proc", true)},
+			wantReason: ReasonMissingTerminalFilter,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := processHTTPFilters(test.filters, false, false, nil, false, &capturingLogger{})
+			if test.wantReason != ReasonUnknown {
+				if r := NACKReasonOf(err); r != test.wantReason {
+					t.Fatalf("processHTTPFilters() = %v, want a NACK with reason %v", err, test.wantReason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("processHTTPFilters() failed: %v", err)
+			}
+			var names []string
+			for _, f := range got {
+				names = append(names, f.Name)
+			}
+			if !reflect.DeepEqual(names, test.wantNames) {
+				t.Errorf("processHTTPFilters() returned filters %v, want %v", names, test.wantNames)
+			}
+		})
+	}
+}
+
+func TestProcessHTTPFiltersWrappedOptionalUnsupportedServerSide(t *testing.T) {
+	httpfilter.Register(terminalFilter{})
+	defer httpfilter.UnregisterForTesting(terminalFilterTypeURL)
+
+	terminalCfg, err := ptypes.MarshalAny(wrapperspb.Bool(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	routerCfg, err := ptypes.MarshalAny(&v3routerpb.Router{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped := func(optional bool) *v3httppb.HttpFilter {
+		cfg, err := ptypes.MarshalAny(&v3routepb.FilterConfig{Config: terminalCfg, IsOptional: optional})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &v3httppb.HttpFilter{
+			Name:       "client-only",
+			ConfigType: &v3httppb.HttpFilter_TypedConfig{TypedConfig: cfg},
+		}
+	}
+	router := &v3httppb.HttpFilter{
+		Name:       "router",
+		ConfigType: &v3httppb.HttpFilter_TypedConfig{TypedConfig: routerCfg},
+	}
+
+	// terminalFilter has no server interceptor, so it is skipped only if the
+	// wrapping FilterConfig marks it optional.
+	got, err := processHTTPFilters([]*v3httppb.HttpFilter{wrapped(true), router}, true, false, nil, false, &capturingLogger{})
+	if err != nil {
+		t.Fatalf("processHTTPFilters() failed: %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "router" {
+		t.Errorf("processHTTPFilters() returned %v, want only the router", got)
+	}
+
+	_, err = processHTTPFilters([]*v3httppb.HttpFilter{wrapped(false), router}, true, false, nil, false, &capturingLogger{})
+	if r := NACKReasonOf(err); r != ReasonInvalidHTTPFilter {
+		t.Errorf("processHTTPFilters() = %v, want a NACK with reason %v", err, ReasonInvalidHTTPFilter)
+	}
+}
+
+func TestUnmarshalListenerServerSide(t *testing.T) {
+	routerCfg, err := ptypes.MarshalAny(&v3routerpb.Router{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hcm, err := ptypes.MarshalAny(&v3httppb.HttpConnectionManager{
+		RouteSpecifier: &v3httppb.HttpConnectionManager_Rds{
+			Rds: &v3httppb.Rds{
+				ConfigSource: &v3corepb.ConfigSource{
+					ConfigSourceSpecifier: &v3corepb.ConfigSource_Ads{Ads: &v3corepb.AggregatedConfigSource{}},
+				},
+				RouteConfigName: "route",
+			},
+		},
+		HttpFilters: []*v3httppb.HttpFilter{{
+			Name:       "router",
+			ConfigType: &v3httppb.HttpFilter_TypedConfig{TypedConfig: routerCfg},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverListener := func(filters []*v3listenerpb.Filter) *anypb.Any {
+		lis, err := ptypes.MarshalAny(&v3listenerpb.Listener{
+			Name: "server-listener",
+			Address: &v3corepb.Address{
+				Address: &v3corepb.Address_SocketAddress{
+					SocketAddress: &v3corepb.SocketAddress{
+						Address:       "0.0.0.0",
+						PortSpecifier: &v3corepb.SocketAddress_PortValue{PortValue: 8080},
+					},
+				},
+			},
+			FilterChains: []*v3listenerpb.FilterChain{{
+				Name:    "filter-chain",
+				Filters: filters,
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lis
+	}
+
+	t.Run("with HCM", func(t *testing.T) {
+		update, _, err := UnmarshalListener(&UnmarshalOptions{
+			Resources: []*anypb.Any{serverListener([]*v3listenerpb.Filter{{
+				Name:       "hcm",
+				ConfigType: &v3listenerpb.Filter_TypedConfig{TypedConfig: hcm},
+			}})},
+			Logger: &capturingLogger{},
+		})
+		if err != nil {
+			t.Fatalf("UnmarshalListener() failed: %v", err)
+		}
+		got := update["server-listener"]
+		if got.Err != nil {
+			t.Fatalf("server-listener = %+v, want a valid update", got)
+		}
+		ilc := got.Update.InboundListenerCfg
+		if ilc == nil || ilc.FilterChains == nil {
+			t.Fatalf("InboundListenerCfg = %+v, want filter chains", ilc)
+		}
+		if ilc.HostPort() != "0.0.0.0:8080" {
+			t.Errorf("HostPort() = %q, want %q", ilc.HostPort(), "0.0.0.0:8080")
+		}
+		if !ilc.FilterChains.RouteConfigNames["route"] {
+			t.Errorf("RouteConfigNames = %v, want %q", ilc.FilterChains.RouteConfigNames, "route")
+		}
+	})
+
+	t.Run("without HCM", func(t *testing.T) {
+		update, md, err := UnmarshalListener(&UnmarshalOptions{
+			Resources: []*anypb.Any{serverListener(nil)},
+			Logger:    &capturingLogger{},
+		})
+		if err != nil {
+			t.Fatalf("UnmarshalListener() failed: %v", err)
+		}
+		if md.Status != ServiceStatusNACKed {
+			t.Errorf("UnmarshalListener() returned metadata %+v, want NACKed", md)
+		}
+		if r := NACKReasonOf(update["server-listener"].Err); r != ReasonInvalidFilterChain {
+			t.Errorf("server-listener error = %v, want a NACK with reason %v", update["server-listener"].Err, ReasonInvalidFilterChain)
+		}
+	})
+}
+
+func TestUnmarshalListenerWarnsClientHelloMatchWithoutTLSInspector(t *testing.T) {
+	filters := benchmarkNetworkFilters(t)
+	listener := func(listenerFilters []*v3listenerpb.ListenerFilter) *anypb.Any {
+		lis, err := ptypes.MarshalAny(&v3listenerpb.Listener{
+			Name: "server-listener",
+			Address: &v3corepb.Address{
+				Address: &v3corepb.Address_SocketAddress{
+					SocketAddress: &v3corepb.SocketAddress{
+						Address:       "0.0.0.0",
+						PortSpecifier: &v3corepb.SocketAddress_PortValue{PortValue: 8080},
+					},
+				},
+			},
+			ListenerFilters: listenerFilters,
+			FilterChains: []*v3listenerpb.FilterChain{{
+				Name: "sni",
+				FilterChainMatch: &v3listenerpb.FilterChainMatch{
+					ServerNames:       []string{"foo.example.com"},
+					TransportProtocol: "tls",
+				},
+				Filters: filters,
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lis
+	}
+	tests := []struct {
+		name            string
+		listenerFilters []*v3listenerpb.ListenerFilter
+		wantWarning     bool
+	}{
+		{
+			name:        "without TLS inspector",
+			wantWarning: true,
+		},
+		{
+			name:            "with TLS inspector",
+			listenerFilters: []*v3listenerpb.ListenerFilter{{Name: "envoy.filters.listener.tls_inspector"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logger := &capturingLogger{}
+			update, _, err := UnmarshalListener(&UnmarshalOptions{
+				Resources: []*anypb.Any{listener(test.listenerFilters)},
+				Logger:    logger,
+			})
+			if err != nil || update["server-listener"].Err != nil {
+				t.Fatalf("UnmarshalListener() = (%+v, %v), want a valid update", update, err)
+			}
+			if got := logger.hasPrefix(`Filter chain "sni"`); got != test.wantWarning {
+				t.Errorf("warned about the filter chain: %v, want %v", got, test.wantWarning)
+			}
+		})
+	}
+}
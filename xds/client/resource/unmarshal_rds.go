@@ -26,15 +26,19 @@ package resource
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
 import (
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	v3routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	v3matcherpb "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
 	v3typepb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 
 	"google.golang.org/grpc/codes"
 
@@ -45,7 +49,9 @@ import (
 	dubboLogger "dubbo.apache.org/dubbo-go/v3/common/logger"
 	"dubbo.apache.org/dubbo-go/v3/xds/client/resource/version"
 	"dubbo.apache.org/dubbo-go/v3/xds/clusterspecifier"
+	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
 	"dubbo.apache.org/dubbo-go/v3/xds/utils/envconfig"
+	"dubbo.apache.org/dubbo-go/v3/xds/utils/matcher"
 	"dubbo.apache.org/dubbo-go/v3/xds/utils/pretty"
 )
 
@@ -54,23 +60,26 @@ import (
 // fields we are interested in. The provided hostname determines the route
 // configuration resources of interest.
 func UnmarshalRouteConfig(opts *UnmarshalOptions) (map[string]RouteConfigUpdateErrTuple, UpdateMetadata, error) {
+	opts = opts.withNodeLogger()
 	update := make(map[string]RouteConfigUpdateErrTuple)
 	md, err := processAllResources(opts, update)
 	return update, md, err
 }
 
-func unmarshalRouteConfigResource(r *anypb.Any, logger dubboLogger.Logger) (string, RouteConfigUpdate, error) {
-	if !IsRouteConfigResource(r.GetTypeUrl()) {
-		return "", RouteConfigUpdate{}, fmt.Errorf("unexpected resource type: %q ", r.GetTypeUrl())
+func unmarshalRouteConfigResource(r *anypb.Any, logger dubboLogger.Logger, api version.TransportAPI) (string, RouteConfigUpdate, error) {
+	if err := ValidateResourceType(r.GetTypeUrl(), RouteConfigResource); err != nil {
+		return "", RouteConfigUpdate{}, err
+	}
+	v2, err := isV2Resource(api, r.GetTypeUrl(), version.V2RouteConfigURL)
+	if err != nil {
+		return "", RouteConfigUpdate{}, err
 	}
 	rc := &v3routepb.RouteConfiguration{}
 	if err := proto.Unmarshal(r.GetValue(), rc); err != nil {
 		return "", RouteConfigUpdate{}, fmt.Errorf("failed to unmarshal resource: %v", err)
 	}
-	dubboLogger.Debugf("Resource with name: %v, type: %T, contains: %v.", rc.GetName(), rc, pretty.ToJSON(rc))
+	logger.Debugf("Resource with name: %v, type: %T, contains: %v.", rc.GetName(), rc, pretty.Lazy(rc))
 
-	// TODO: Pass version.TransportAPI instead of relying upon the type URL
-	v2 := r.GetTypeUrl() == version.V2RouteConfigURL
 	u, err := generateRDSUpdateFromRouteConfiguration(rc, logger, v2)
 	if err != nil {
 		return rc.GetName(), RouteConfigUpdate{}, err
@@ -109,29 +118,70 @@ func generateRDSUpdateFromRouteConfiguration(rc *v3routepb.RouteConfiguration, l
 	// Actions - any cluster specifiers not referenced by a Route Action can be
 	// ignored and not emitted by the xdsclient.
 	var cspNames = make(map[string]bool)
-	for _, vh := range rc.GetVirtualHosts() {
+	rcMutations, err := headerMutationsFromProto(rc)
+	if err != nil {
+		return RouteConfigUpdate{}, fmt.Errorf("route configuration %q: %v", rc.GetName(), err)
+	}
+	vhds, err := vhdsConfigFromProto(rc.GetVhds(), v2)
+	if err != nil {
+		return RouteConfigUpdate{}, fmt.Errorf("route configuration %q: %v", rc.GetName(), err)
+	}
+	// domains maps each domain, lower cased as hosts are case insensitive, to
+	// the index of the virtual host claiming it.
+	domains := make(map[string]int)
+	for i, vh := range rc.GetVirtualHosts() {
+		for _, d := range vh.GetDomains() {
+			key := strings.ToLower(d)
+			if j, ok := domains[key]; ok && j != i {
+				return RouteConfigUpdate{}, fmt.Errorf("domain %q of virtual host %q is also in virtual host %q", d, vh.GetName(), rc.GetVirtualHosts()[j].GetName())
+			}
+			domains[key] = i
+		}
 		routes, cspNs, err := routesProtoToSlice(vh.Routes, csps, logger, v2)
 		if err != nil {
 			return RouteConfigUpdate{}, fmt.Errorf("received route is invalid: %v", err)
 		}
+		vhMutations, err := headerMutationsFromProto(vh)
+		if err != nil {
+			return RouteConfigUpdate{}, fmt.Errorf("virtual host %q: %v", vh.GetName(), err)
+		}
+		vhMutations = rcMutations.merge(vhMutations)
+		for _, r := range routes {
+			r.HeaderMutations = vhMutations.merge(r.HeaderMutations)
+		}
 		for n := range cspNs {
 			cspNames[n] = true
 		}
-		rc, err := generateRetryConfig(vh.GetRetryPolicy())
+		rc, err := generateRetryConfig(vh.GetRetryPolicy(), logger)
 		if err != nil {
 			return RouteConfigUpdate{}, fmt.Errorf("received route is invalid: %v", err)
 		}
+		for _, r := range routes {
+			// The route's retry policy overrides the virtual host's.
+			if r.ActionType == RouteActionRoute && r.RetryConfig == nil {
+				r.RetryConfig = rc
+			}
+		}
 		vhOut := &VirtualHost{
-			Domains:     vh.GetDomains(),
-			Routes:      routes,
-			RetryConfig: rc,
+			Domains:                       vh.GetDomains(),
+			Routes:                        routes,
+			RetryConfig:                   rc,
+			HeaderMutations:               vhMutations,
+			IncludeRequestAttemptCount:    vh.GetIncludeRequestAttemptCount(),
+			IncludeAttemptCountInResponse: vh.GetIncludeAttemptCountInResponse(),
 		}
 		if !v2 {
-			cfgs, err := processHTTPFilterOverrides(vh.GetTypedPerFilterConfig())
+			cfgs, err := processHTTPFilterOverrides(vh.GetTypedPerFilterConfig(), logger)
 			if err != nil {
 				return RouteConfigUpdate{}, fmt.Errorf("virtual host %+v: %v", vh, err)
 			}
 			vhOut.HTTPFilterConfigOverride = cfgs
+			for _, r := range routes {
+				r.EffectiveHTTPFilterConfigOverride = mergeFilterConfigs(cfgs, r.HTTPFilterConfigOverride)
+			}
+			if vhOut.CORSPolicy, err = corsPolicyFromFilterOverrides(vh.GetTypedPerFilterConfig()); err != nil {
+				return RouteConfigUpdate{}, fmt.Errorf("virtual host %+v: %v", vh, err)
+			}
 		}
 		vhs = append(vhs, vhOut)
 	}
@@ -145,7 +195,48 @@ func generateRDSUpdateFromRouteConfiguration(rc *v3routepb.RouteConfiguration, l
 		}
 	}
 
-	return RouteConfigUpdate{VirtualHosts: vhs, ClusterSpecifierPlugins: csps}, nil
+	return RouteConfigUpdate{VirtualHosts: vhs, ClusterSpecifierPlugins: csps, VHDS: vhds, domainOrder: domainMatchOrder(vhs)}, nil
+}
+
+// vhdsConfigFromProto returns the VHDS config of a route configuration, nil if
+// vhds is unset. The virtual hosts can only be fetched on the ADS stream, so a
+// config source other than ADS is rejected.
+func vhdsConfigFromProto(vhds *v3routepb.Vhds, v2 bool) (*VHDSConfig, error) {
+	if vhds == nil {
+		return nil, nil
+	}
+	cs := vhds.GetConfigSource()
+	if cs.GetAds() == nil {
+		return nil, fmt.Errorf("vhds config source %+v is not ADS", cs)
+	}
+	ret := &VHDSConfig{ResourceAPIVersion: version.TransportV3}
+	switch cs.GetResourceApiVersion() {
+	case v3corepb.ApiVersion_V2:
+		ret.ResourceAPIVersion = version.TransportV2
+	case v3corepb.ApiVersion_AUTO:
+		// The resources follow the version of the transport they come on.
+		if v2 {
+			ret.ResourceAPIVersion = version.TransportV2
+		}
+	}
+	return ret, nil
+}
+
+// mergeFilterConfigs returns the filter config overrides of over merged over
+// those of base: an override in over shadows the one in base for the same
+// filter. It returns nil if both are empty.
+func mergeFilterConfigs(base, over map[string]httpfilter.FilterConfig) map[string]httpfilter.FilterConfig {
+	if len(base) == 0 && len(over) == 0 {
+		return nil
+	}
+	ret := make(map[string]httpfilter.FilterConfig, len(base)+len(over))
+	for name, cfg := range base {
+		ret[name] = cfg
+	}
+	for name, cfg := range over {
+		ret[name] = cfg
+	}
+	return ret
 }
 
 func processClusterSpecifierPlugins(csps []*v3routepb.ClusterSpecifierPlugin) (map[string]clusterspecifier.BalancerConfig, error) {
@@ -175,14 +266,15 @@ func processClusterSpecifierPlugins(csps []*v3routepb.ClusterSpecifierPlugin) (m
 	return cspCfgs, nil
 }
 
-func generateRetryConfig(rp *v3routepb.RetryPolicy) (*RetryConfig, error) {
+func generateRetryConfig(rp *v3routepb.RetryPolicy, logger dubboLogger.Logger) (*RetryConfig, error) {
 	if rp == nil {
 		return nil, nil
 	}
 
 	cfg := &RetryConfig{RetryOn: make(map[codes.Code]bool)}
+	var onStatusCodes, onHeaders bool
 	for _, s := range strings.Split(rp.GetRetryOn(), ",") {
-		switch strings.TrimSpace(strings.ToLower(s)) {
+		switch token := strings.TrimSpace(strings.ToLower(s)); token {
 		// FIXME, is this misspelled by grpc?
 		case "cancel" + "led":
 			cfg.RetryOn[codes.Canceled] = true
@@ -194,6 +286,13 @@ func generateRetryConfig(rp *v3routepb.RetryPolicy) (*RetryConfig, error) {
 			cfg.RetryOn[codes.ResourceExhausted] = true
 		case "unavailable":
 			cfg.RetryOn[codes.Unavailable] = true
+		case "retriable-status-codes":
+			onStatusCodes = true
+		case "retriable-headers":
+			onHeaders = true
+		case "":
+		default:
+			logger.Debugf("Ignoring unsupported retry_on condition %q in retry policy %+v", token, rp)
 		}
 	}
 
@@ -223,13 +322,286 @@ func generateRetryConfig(rp *v3routepb.RetryPolicy) (*RetryConfig, error) {
 			return nil, fmt.Errorf("retry_policy.max_interval = %v; must be > 0", cfg.RetryBackoff.MaxInterval)
 		}
 	}
+	if cfg.RetryBackoff.BaseInterval > cfg.RetryBackoff.MaxInterval {
+		return nil, fmt.Errorf("retry_policy.base_interval = %v; must be <= max_interval = %v", cfg.RetryBackoff.BaseInterval, cfg.RetryBackoff.MaxInterval)
+	}
 
-	if len(cfg.RetryOn) == 0 {
+	if ptt := rp.GetPerTryTimeout(); ptt != nil {
+		cfg.PerTryTimeout = ptt.AsDuration()
+		if cfg.PerTryTimeout < 0 {
+			return nil, fmt.Errorf("retry_policy.per_try_timeout = %v; must be >= 0", cfg.PerTryTimeout)
+		}
+	}
+
+	if onStatusCodes {
+		for _, c := range rp.GetRetriableStatusCodes() {
+			if c < 100 || c > 599 {
+				logger.Debugf("Ignoring retriable status code %d outside [100, 599] in retry policy %+v", c, rp)
+				continue
+			}
+			cfg.RetriableStatusCodes = append(cfg.RetriableStatusCodes, c)
+		}
+	}
+	if onHeaders {
+		hms, err := headerMatchersProtoToSlice(rp.GetRetriableHeaders())
+		if err != nil {
+			return nil, fmt.Errorf("retry_policy.retriable_headers: %v", err)
+		}
+		cfg.RetriableHeaders = hms
+	}
+	hms, err := headerMatchersProtoToSlice(rp.GetRetriableRequestHeaders())
+	if err != nil {
+		return nil, fmt.Errorf("retry_policy.retriable_request_headers: %v", err)
+	}
+	cfg.RetriableRequestHeaders = hms
+
+	if len(cfg.RetryOn) == 0 && len(cfg.RetriableStatusCodes) == 0 && len(cfg.RetriableHeaders) == 0 {
 		return &RetryConfig{}, nil
 	}
 	return cfg, nil
 }
 
+// headerMatchersProtoToSlice converts the header matchers of a RouteMatch. A
+// regex which fails to compile is reported as a skipRouteError.
+func headerMatchersProtoToSlice(hs []*v3routepb.HeaderMatcher) ([]*HeaderMatcher, error) {
+	var ret []*HeaderMatcher
+	for _, h := range hs {
+		var header HeaderMatcher
+		switch ht := h.GetHeaderMatchSpecifier().(type) {
+		case *v3routepb.HeaderMatcher_ExactMatch:
+			header.ExactMatch = &ht.ExactMatch
+		case *v3routepb.HeaderMatcher_SafeRegexMatch:
+			regex := ht.SafeRegexMatch.GetRegex()
+			if regex == "" {
+				return nil, fmt.Errorf("header matcher %q has an empty safe_regex_match regex", h.GetName())
+			}
+			re, err := regexp.Compile(regex)
+			if err != nil {
+				return nil, skipRouteError{fmt.Errorf("header matcher %q contains an invalid regex %q", h.GetName(), regex)}
+			}
+			header.RegexMatch = re
+		case *v3routepb.HeaderMatcher_RangeMatch:
+			header.RangeMatch = &Int64Range{
+				Start: ht.RangeMatch.Start,
+				End:   ht.RangeMatch.End,
+			}
+		case *v3routepb.HeaderMatcher_PresentMatch:
+			header.PresentMatch = &ht.PresentMatch
+		case *v3routepb.HeaderMatcher_PrefixMatch:
+			header.PrefixMatch = &ht.PrefixMatch
+		case *v3routepb.HeaderMatcher_SuffixMatch:
+			header.SuffixMatch = &ht.SuffixMatch
+		case *v3routepb.HeaderMatcher_ContainsMatch:
+			header.ContainsMatch = &ht.ContainsMatch
+		default:
+			return nil, fmt.Errorf("unrecognized header matcher: %+v", ht)
+		}
+		header.Name = h.GetName()
+		invert := h.GetInvertMatch()
+		header.InvertMatch = &invert
+		ret = append(ret, &header)
+	}
+	return ret, nil
+}
+
+// queryParamMatchersProtoToSlice converts the query parameter matchers of a
+// RouteMatch. Any error means the route should be skipped.
+func queryParamMatchersProtoToSlice(qs []*v3routepb.QueryParameterMatcher) ([]QueryParamMatcher, error) {
+	var ret []QueryParamMatcher
+	for _, q := range qs {
+		if q.GetName() == "" {
+			return nil, fmt.Errorf("query parameter matcher %+v has no name", q)
+		}
+		qm := QueryParamMatcher{Name: q.GetName()}
+		switch qt := q.GetQueryParameterMatchSpecifier().(type) {
+		case *v3routepb.QueryParameterMatcher_StringMatch:
+			sm, err := matcher.StringMatcherFromProto(qt.StringMatch)
+			if err != nil {
+				return nil, fmt.Errorf("query parameter matcher %q: %v", q.GetName(), err)
+			}
+			qm.StringMatch = &sm
+		case *v3routepb.QueryParameterMatcher_PresentMatch:
+			present := qt.PresentMatch
+			qm.PresentMatch = &present
+		default:
+			return nil, fmt.Errorf("query parameter matcher %q has an unrecognized match specifier: %+v", q.GetName(), qt)
+		}
+		ret = append(ret, qm)
+	}
+	return ret, nil
+}
+
+// substitutionToTemplate converts an Envoy regex substitution, which refers to
+// capture groups as \N, into a regexp.Expand template.
+func substitutionToTemplate(sub string) string {
+	var b strings.Builder
+	for i := 0; i < len(sub); i++ {
+		switch c := sub[i]; {
+		case c == '$':
+			b.WriteString("$$")
+		case c == '\\' && i+1 < len(sub) && sub[i+1] >= '0' && sub[i+1] <= '9':
+			b.WriteString("${")
+			b.WriteByte(sub[i+1])
+			b.WriteString("}")
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// headerMutationSource is implemented by the RouteConfiguration, VirtualHost
+// and Route protos, which all carry header mutations.
+type headerMutationSource interface {
+	GetRequestHeadersToAdd() []*v3corepb.HeaderValueOption
+	GetRequestHeadersToRemove() []string
+	GetResponseHeadersToAdd() []*v3corepb.HeaderValueOption
+	GetResponseHeadersToRemove() []string
+}
+
+func headerMutationsFromProto(src headerMutationSource) (HeaderMutations, error) {
+	var (
+		ret HeaderMutations
+		err error
+	)
+	if ret.RequestHeadersToAdd, err = headerValueOptionsFromProto(src.GetRequestHeadersToAdd()); err != nil {
+		return HeaderMutations{}, fmt.Errorf("request_headers_to_add: %v", err)
+	}
+	if ret.ResponseHeadersToAdd, err = headerValueOptionsFromProto(src.GetResponseHeadersToAdd()); err != nil {
+		return HeaderMutations{}, fmt.Errorf("response_headers_to_add: %v", err)
+	}
+	for _, name := range src.GetRequestHeadersToRemove() {
+		if !isValidHeaderName(name) {
+			return HeaderMutations{}, fmt.Errorf("request_headers_to_remove: invalid header name %q", name)
+		}
+		ret.RequestHeadersToRemove = append(ret.RequestHeadersToRemove, name)
+	}
+	for _, name := range src.GetResponseHeadersToRemove() {
+		if !isValidHeaderName(name) {
+			return HeaderMutations{}, fmt.Errorf("response_headers_to_remove: invalid header name %q", name)
+		}
+		ret.ResponseHeadersToRemove = append(ret.ResponseHeadersToRemove, name)
+	}
+	return ret, nil
+}
+
+func headerValueOptionsFromProto(opts []*v3corepb.HeaderValueOption) ([]HeaderValueOption, error) {
+	var ret []HeaderValueOption
+	for _, o := range opts {
+		key := o.GetHeader().GetKey()
+		if !isValidHeaderName(key) {
+			return nil, fmt.Errorf("invalid header name %q", key)
+		}
+		// The value may contain %-style formatting tokens, which are kept
+		// as is.
+		hvo := HeaderValueOption{Key: key, Value: o.GetHeader().GetValue(), Append: true}
+		if a := o.GetAppend(); a != nil {
+			hvo.Append = a.GetValue()
+		}
+		ret = append(ret, hvo)
+	}
+	return ret, nil
+}
+
+// isValidHeaderName reports whether name is a non-empty HTTP token
+// (RFC 7230, section 3.2.6).
+func isValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// corsPolicyTypeURL is the type of the CORS policy carried in
+// typed_per_filter_config. It matches cors.PolicyTypeURL.
+const corsPolicyTypeURL = "type.googleapis.com/envoy.config.route.v3.CorsPolicy"
+
+// corsPolicyFromFilterOverrides returns the CORS policy found in the
+// typed_per_filter_config of a virtual host or route, or nil if there is none
+// or the CORS filter is not registered. The route configuration doesn't know
+// the name of the CORS filter of the listener, so more than one CORS policy
+// is rejected rather than picking one.
+func corsPolicyFromFilterOverrides(cfgs map[string]*anypb.Any) (*CORSPolicy, error) {
+	if httpfilter.Get(corsPolicyTypeURL) == nil {
+		return nil, nil
+	}
+	var (
+		ret     *CORSPolicy
+		retName string
+	)
+	for name, cfg := range cfgs {
+		if fc := new(v3routepb.FilterConfig); ptypes.Is(cfg, fc) {
+			if err := ptypes.UnmarshalAny(cfg, fc); err != nil {
+				return nil, fmt.Errorf("filter override %q: error unmarshaling FilterConfig: %v", name, err)
+			}
+			cfg = fc.GetConfig()
+		}
+		if cfg.GetTypeUrl() != corsPolicyTypeURL {
+			continue
+		}
+		cp := new(v3routepb.CorsPolicy)
+		if err := ptypes.UnmarshalAny(cfg, cp); err != nil {
+			return nil, fmt.Errorf("filter override %q: error unmarshaling CorsPolicy: %v", name, err)
+		}
+		policy, err := corsPolicyFromProto(cp)
+		if err != nil {
+			return nil, fmt.Errorf("filter override %q: %v", name, err)
+		}
+		if ret != nil {
+			return nil, fmt.Errorf("filter overrides %q and %q are both CORS policies", retName, name)
+		}
+		ret, retName = policy, name
+	}
+	return ret, nil
+}
+
+func corsPolicyFromProto(cp *v3routepb.CorsPolicy) (*CORSPolicy, error) {
+	policy := &CORSPolicy{
+		AllowMethods:     splitCORSList(cp.GetAllowMethods()),
+		AllowHeaders:     splitCORSList(cp.GetAllowHeaders()),
+		ExposeHeaders:    splitCORSList(cp.GetExposeHeaders()),
+		AllowCredentials: cp.GetAllowCredentials().GetValue(),
+	}
+	for _, m := range cp.GetAllowOriginStringMatch() {
+		sm, err := matcher.StringMatcherFromProto(m)
+		if err != nil {
+			return nil, fmt.Errorf("cors allow_origin_string_match %+v: %v", m, err)
+		}
+		policy.AllowOrigins = append(policy.AllowOrigins, sm)
+	}
+	if ma := cp.GetMaxAge(); ma != "" {
+		secs, err := strconv.ParseInt(ma, 10, 64)
+		if err != nil || secs < 0 {
+			return nil, fmt.Errorf("cors max_age = %q; must be a non-negative number of seconds", ma)
+		}
+		d := time.Duration(secs) * time.Second
+		policy.MaxAge = &d
+	}
+	return policy, nil
+}
+
+// splitCORSList splits a comma separated CORS header value, dropping empty
+// entries.
+func splitCORSList(s string) []string {
+	var ret []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
 func routesProtoToSlice(routes []*v3routepb.Route, csps map[string]clusterspecifier.BalancerConfig, logger dubboLogger.Logger, v2 bool) ([]*Route, map[string]bool, error) {
 	var routesRet []*Route
 	var cspNames = make(map[string]bool)
@@ -239,12 +611,6 @@ func routesProtoToSlice(routes []*v3routepb.Route, csps map[string]clusterspecif
 			return nil, nil, fmt.Errorf("route %+v doesn't have a match", r)
 		}
 
-		if len(match.GetQueryParameters()) != 0 {
-			// Ignore route with query parameters.
-			logger.Warnf("route %+v has query parameter matchers, the route will be ignored", r)
-			continue
-		}
-
 		pathSp := match.GetPathSpecifier()
 		if pathSp == nil {
 			return nil, nil, fmt.Errorf("route %+v doesn't have a path specifier", r)
@@ -260,8 +626,11 @@ func routesProtoToSlice(routes []*v3routepb.Route, csps map[string]clusterspecif
 			regex := pt.SafeRegex.GetRegex()
 			re, err := regexp.Compile(regex)
 			if err != nil {
-				return nil, nil, fmt.Errorf("route %+v contains an invalid regex %q", r, regex)
+				// An invalid path regex only invalidates its route.
+				logger.Warnf("route %+v contains an invalid path regex %q: %v, the route will be ignored", r, regex, err)
+				continue
 			}
+			// Compiled once here so the router doesn't recompile per request.
 			route.Regex = re
 		default:
 			return nil, nil, fmt.Errorf("route %+v has an unrecognized path specifier: %+v", r, pt)
@@ -271,49 +640,36 @@ func routesProtoToSlice(routes []*v3routepb.Route, csps map[string]clusterspecif
 			route.CaseInsensitive = !caseSensitive.Value
 		}
 
-		for _, h := range match.GetHeaders() {
-			var header HeaderMatcher
-			switch ht := h.GetHeaderMatchSpecifier().(type) {
-			case *v3routepb.HeaderMatcher_ExactMatch:
-				header.ExactMatch = &ht.ExactMatch
-			case *v3routepb.HeaderMatcher_SafeRegexMatch:
-				regex := ht.SafeRegexMatch.GetRegex()
-				re, err := regexp.Compile(regex)
-				if err != nil {
-					return nil, nil, fmt.Errorf("route %+v contains an invalid regex %q", r, regex)
-				}
-				header.RegexMatch = re
-			case *v3routepb.HeaderMatcher_RangeMatch:
-				header.RangeMatch = &Int64Range{
-					Start: ht.RangeMatch.Start,
-					End:   ht.RangeMatch.End,
-				}
-			case *v3routepb.HeaderMatcher_PresentMatch:
-				header.PresentMatch = &ht.PresentMatch
-			case *v3routepb.HeaderMatcher_PrefixMatch:
-				header.PrefixMatch = &ht.PrefixMatch
-			case *v3routepb.HeaderMatcher_SuffixMatch:
-				header.SuffixMatch = &ht.SuffixMatch
-			default:
-				return nil, nil, fmt.Errorf("route %+v has an unrecognized header matcher: %+v", r, ht)
+		headers, err := headerMatchersProtoToSlice(match.GetHeaders())
+		if err != nil {
+			if _, ok := err.(skipRouteError); ok {
+				// An invalid header regex only invalidates its route.
+				logger.Warnf("route %+v: %v, the route will be ignored", r, err)
+				continue
 			}
-			header.Name = h.GetName()
-			invert := h.GetInvertMatch()
-			header.InvertMatch = &invert
-			route.Headers = append(route.Headers, &header)
+			return nil, nil, fmt.Errorf("route %+v: %v", r, err)
+		}
+		route.Headers = headers
+
+		queryParams, err := queryParamMatchersProtoToSlice(match.GetQueryParameters())
+		if err != nil {
+			// Malformed query parameter matchers only invalidate their route.
+			logger.Warnf("route %+v: %v, the route will be ignored", r, err)
+			continue
+		}
+		route.QueryParams = queryParams
+
+		if route.HeaderMutations, err = headerMutationsFromProto(r); err != nil {
+			return nil, nil, fmt.Errorf("route %+v: %v", r, err)
 		}
 
 		if fr := match.GetRuntimeFraction(); fr != nil {
-			d := fr.GetDefaultValue()
-			n := d.GetNumerator()
-			switch d.GetDenominator() {
-			case v3typepb.FractionalPercent_HUNDRED:
-				n *= 10000
-			case v3typepb.FractionalPercent_TEN_THOUSAND:
-				n *= 100
-			case v3typepb.FractionalPercent_MILLION:
+			if err := validateFractionalPercent(fr.GetDefaultValue()); err != nil {
+				return nil, nil, fmt.Errorf("route %+v, runtime_fraction: %v", r, err)
 			}
+			n := fractionPerMillion(fr.GetDefaultValue())
 			route.Fraction = &n
+			route.FractionRuntimeKey = fr.GetRuntimeKey()
 		}
 
 		switch r.GetAction().(type) {
@@ -325,6 +681,12 @@ func routesProtoToSlice(routes []*v3routepb.Route, csps map[string]clusterspecif
 			if envconfig.XDSRingHash {
 				hp, err := hashPoliciesProtoToSlice(action.HashPolicy, logger)
 				if err != nil {
+					if _, ok := err.(skipRouteError); ok {
+						// "If a hash policy is invalid, the route
+						// containing it is ignored" - A42
+						logger.Warnf("route %+v: %v, the route will be ignored", r, err)
+						continue
+					}
 					return nil, nil, err
 				}
 				route.HashPolicies = hp
@@ -335,15 +697,21 @@ func routesProtoToSlice(routes []*v3routepb.Route, csps map[string]clusterspecif
 				route.WeightedClusters[a.Cluster] = WeightedCluster{Weight: 1}
 			case *v3routepb.RouteAction_WeightedClusters:
 				wcs := a.WeightedClusters
+				if len(wcs.Clusters) == 1 && wcs.Clusters[0].GetWeight().GetValue() == 0 {
+					return nil, nil, fmt.Errorf("route %+v, action %+v, the only cluster in WeightedCluster action has zero weight", r, a)
+				}
 				var totalWeight uint32
 				for _, c := range wcs.Clusters {
 					w := c.GetWeight().GetValue()
 					if w == 0 {
 						continue
 					}
+					if _, ok := route.WeightedClusters[c.GetName()]; ok {
+						return nil, nil, fmt.Errorf("route %+v, action %+v, cluster %q appears more than once in WeightedCluster action", r, a, c.GetName())
+					}
 					wc := WeightedCluster{Weight: w}
 					if !v2 {
-						cfgs, err := processHTTPFilterOverrides(c.GetTypedPerFilterConfig())
+						cfgs, err := processHTTPFilterOverrides(c.GetTypedPerFilterConfig(), logger)
 						if err != nil {
 							return nil, nil, fmt.Errorf("route %+v, action %+v: %v", r, a, err)
 						}
@@ -365,6 +733,12 @@ func routesProtoToSlice(routes []*v3routepb.Route, csps map[string]clusterspecif
 					return nil, nil, fmt.Errorf("route %+v, action %+v, has no valid cluster in WeightedCluster action", r, a)
 				}
 			case *v3routepb.RouteAction_ClusterHeader:
+				if a.ClusterHeader == "" {
+					return nil, nil, fmt.Errorf("route %+v, action %+v, has an empty cluster_header", r, a)
+				}
+				// The resolver only routes to clusters known when the route
+				// is parsed, so matching RPCs fall through to later routes.
+				logger.Warnf("route %+v: cluster_header %q is not supported, the route will be ignored", r, a.ClusterHeader)
 				continue
 			case *v3routepb.RouteAction_ClusterSpecifierPlugin:
 				if !envconfig.XDSRLS {
@@ -390,37 +764,244 @@ func routesProtoToSlice(routes []*v3routepb.Route, csps map[string]clusterspecif
 				dur = msd.GetMaxStreamDuration()
 			}
 			if dur != nil {
+				if err := dur.CheckValid(); err != nil {
+					return nil, nil, fmt.Errorf("route %+v, action %+v: invalid max_stream_duration %v: %v", r, action, dur, err)
+				}
 				d := dur.AsDuration()
 				route.MaxStreamDuration = &d
 			}
 
+			if action.GetPrefixRewrite() != "" && action.GetRegexRewrite() != nil {
+				return nil, nil, fmt.Errorf("route %+v, action %+v: prefix_rewrite and regex_rewrite are both set", r, action)
+			}
+			route.PrefixRewrite = action.GetPrefixRewrite()
+			if rr := action.GetRegexRewrite(); rr != nil {
+				var err error
+				if route.RegexRewrite, err = regexRewriteFromProto(rr); err != nil {
+					return nil, nil, fmt.Errorf("route %+v, action %+v: %v", r, action, err)
+				}
+			}
+
+			if t := action.GetTimeout(); t != nil {
+				if err := t.CheckValid(); err != nil {
+					return nil, nil, fmt.Errorf("route %+v, action %+v: invalid timeout %v: %v", r, action, t, err)
+				}
+				d := t.AsDuration()
+				if d < 0 {
+					return nil, nil, fmt.Errorf("route %+v, action %+v: timeout = %v; must be >= 0", r, action, d)
+				}
+				route.Timeout = &d
+			}
+
 			var err error
-			route.RetryConfig, err = generateRetryConfig(action.GetRetryPolicy())
+			route.RetryConfig, err = generateRetryConfig(action.GetRetryPolicy(), logger)
 			if err != nil {
 				return nil, nil, fmt.Errorf("route %+v, action %+v: %v", r, action, err)
 			}
 
+			for _, mp := range action.GetRequestMirrorPolicies() {
+				m, err := mirrorPolicyFromProto(mp)
+				if err != nil {
+					return nil, nil, fmt.Errorf("route %+v, action %+v: %v", r, action, err)
+				}
+				route.MirrorPolicies = append(route.MirrorPolicies, m)
+			}
+
+			for _, uc := range action.GetUpgradeConfigs() {
+				t := strings.ToLower(uc.GetUpgradeType())
+				if t == "" {
+					return nil, nil, fmt.Errorf("route %+v, action %+v: upgrade_configs entry without an upgrade_type", r, action)
+				}
+				if _, ok := route.UpgradeConfigs[t]; ok {
+					return nil, nil, fmt.Errorf("route %+v, action %+v: duplicate upgrade_type %q in upgrade_configs", r, action, t)
+				}
+				if route.UpgradeConfigs == nil {
+					route.UpgradeConfigs = make(map[string]bool)
+				}
+				// "Determines if upgrades are available on this route.
+				// Defaults to true."
+				route.UpgradeConfigs[t] = uc.GetEnabled() == nil || uc.GetEnabled().GetValue()
+			}
+
 			route.ActionType = RouteActionRoute
 
 		case *v3routepb.Route_NonForwardingAction:
 			// Expected to be used on server side.
 			route.ActionType = RouteActionNonForwardingAction
+		case *v3routepb.Route_DirectResponse:
+			dr, err := directResponseFromProto(r.GetDirectResponse())
+			if err != nil {
+				return nil, nil, fmt.Errorf("route %+v: %v", r, err)
+			}
+			route.DirectResponse = dr
+			route.ActionType = RouteActionDirectResponse
+		case *v3routepb.Route_Redirect:
+			rd, err := redirectFromProto(r.GetRedirect())
+			if err != nil {
+				return nil, nil, fmt.Errorf("route %+v: %v", r, err)
+			}
+			route.Redirect = rd
+			route.ActionType = RouteActionRedirect
+		case nil:
+			return nil, nil, fmt.Errorf("route %+v doesn't have an action", r)
 		default:
 			route.ActionType = RouteActionUnsupported
 		}
 
 		if !v2 {
-			cfgs, err := processHTTPFilterOverrides(r.GetTypedPerFilterConfig())
+			cfgs, err := processHTTPFilterOverrides(r.GetTypedPerFilterConfig(), logger)
 			if err != nil {
 				return nil, nil, fmt.Errorf("route %+v: %v", r, err)
 			}
 			route.HTTPFilterConfigOverride = cfgs
+			if route.CORSPolicy, err = corsPolicyFromFilterOverrides(r.GetTypedPerFilterConfig()); err != nil {
+				return nil, nil, fmt.Errorf("route %+v: %v", r, err)
+			}
 		}
 		routesRet = append(routesRet, &route)
 	}
 	return routesRet, cspNames, nil
 }
 
+// validateFractionalPercent checks that the numerator of fp doesn't exceed its
+// denominator.
+func validateFractionalPercent(fp *v3typepb.FractionalPercent) error {
+	var max uint32
+	switch fp.GetDenominator() {
+	case v3typepb.FractionalPercent_HUNDRED:
+		max = 100
+	case v3typepb.FractionalPercent_TEN_THOUSAND:
+		max = 10000
+	case v3typepb.FractionalPercent_MILLION:
+		max = 1000000
+	default:
+		return fmt.Errorf("unknown denominator %v", fp.GetDenominator())
+	}
+	if fp.GetNumerator() > max {
+		return fmt.Errorf("numerator %d exceeds denominator %v", fp.GetNumerator(), fp.GetDenominator())
+	}
+	return nil
+}
+
+// fractionPerMillion converts a FractionalPercent to parts per million.
+func fractionPerMillion(fp *v3typepb.FractionalPercent) uint32 {
+	n := fp.GetNumerator()
+	switch fp.GetDenominator() {
+	case v3typepb.FractionalPercent_HUNDRED:
+		n *= 10000
+	case v3typepb.FractionalPercent_TEN_THOUSAND:
+		n *= 100
+	case v3typepb.FractionalPercent_MILLION:
+	}
+	return n
+}
+
+// mirrorPolicyFromProto converts a request_mirror_policies entry of a route
+// action.
+func mirrorPolicyFromProto(mp *v3routepb.RouteAction_RequestMirrorPolicy) (MirrorPolicy, error) {
+	ret := MirrorPolicy{
+		Cluster:       mp.GetCluster(),
+		ClusterHeader: mp.GetClusterHeader(),
+		Fraction:      1000000,
+	}
+	if (ret.Cluster == "") == (ret.ClusterHeader == "") {
+		return MirrorPolicy{}, fmt.Errorf("request mirror policy %+v must set exactly one of cluster and cluster_header", mp)
+	}
+	if fr := mp.GetRuntimeFraction(); fr != nil {
+		if err := validateFractionalPercent(fr.GetDefaultValue()); err != nil {
+			return MirrorPolicy{}, fmt.Errorf("request mirror policy %+v, runtime_fraction: %v", mp, err)
+		}
+		ret.Fraction = fractionPerMillion(fr.GetDefaultValue())
+	}
+	return ret, nil
+}
+
+// directResponseFromProto converts a route's direct_response action. Only
+// inline bodies are supported, as reading the body from a file isn't.
+func directResponseFromProto(dr *v3routepb.DirectResponseAction) (*DirectResponse, error) {
+	status := dr.GetStatus()
+	if status < 100 || status > 599 {
+		return nil, fmt.Errorf("direct_response status %d is not a valid HTTP status", status)
+	}
+	ret := &DirectResponse{Status: status}
+	switch body := dr.GetBody(); body.GetSpecifier().(type) {
+	case nil:
+	case *v3corepb.DataSource_InlineBytes:
+		ret.Body = string(body.GetInlineBytes())
+	case *v3corepb.DataSource_InlineString:
+		ret.Body = body.GetInlineString()
+	default:
+		return nil, fmt.Errorf("direct_response body %+v: only inline bodies are supported", body)
+	}
+	return ret, nil
+}
+
+// regexRewriteFromProto converts a regex_rewrite of a route or redirect action.
+func regexRewriteFromProto(rr *v3matcherpb.RegexMatchAndSubstitute) (*RegexRewrite, error) {
+	regex := rr.GetPattern().GetRegex()
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, fmt.Errorf("regex_rewrite contains an invalid regex %q", regex)
+	}
+	return &RegexRewrite{
+		Regex:        re,
+		Substitution: substitutionToTemplate(rr.GetSubstitution()),
+	}, nil
+}
+
+// redirectResponseCodes maps the redirect response codes to HTTP statuses.
+var redirectResponseCodes = map[v3routepb.RedirectAction_RedirectResponseCode]uint32{
+	v3routepb.RedirectAction_MOVED_PERMANENTLY:  301,
+	v3routepb.RedirectAction_FOUND:              302,
+	v3routepb.RedirectAction_SEE_OTHER:          303,
+	v3routepb.RedirectAction_TEMPORARY_REDIRECT: 307,
+	v3routepb.RedirectAction_PERMANENT_REDIRECT: 308,
+}
+
+// redirectFromProto converts a route's redirect action. path_redirect,
+// prefix_rewrite and regex_rewrite are a oneof in the proto, so at most one of
+// them can be set, as Envoy requires.
+func redirectFromProto(ra *v3routepb.RedirectAction) (*Redirect, error) {
+	code, ok := redirectResponseCodes[ra.GetResponseCode()]
+	if !ok {
+		return nil, fmt.Errorf("redirect has unknown response_code %v", ra.GetResponseCode())
+	}
+	ret := &Redirect{
+		Scheme:       ra.GetSchemeRedirect(),
+		Host:         ra.GetHostRedirect(),
+		Port:         ra.GetPortRedirect(),
+		ResponseCode: code,
+		StripQuery:   ra.GetStripQuery(),
+	}
+	if ra.GetHttpsRedirect() {
+		ret.Scheme = "https"
+	}
+	switch ra.GetPathRewriteSpecifier().(type) {
+	case *v3routepb.RedirectAction_PathRedirect:
+		ret.PathRedirect = ra.GetPathRedirect()
+	case *v3routepb.RedirectAction_PrefixRewrite:
+		ret.PrefixRewrite = ra.GetPrefixRewrite()
+	case *v3routepb.RedirectAction_RegexRewrite:
+		rr, err := regexRewriteFromProto(ra.GetRegexRewrite())
+		if err != nil {
+			return nil, fmt.Errorf("redirect: %v", err)
+		}
+		ret.RegexRewrite = rr
+	}
+	return ret, nil
+}
+
+// skipRouteError is returned by the route parsing helpers when a route is
+// invalid in a way which only affects that route. Such routes are ignored
+// instead of NACKing the whole RouteConfiguration.
+type skipRouteError struct {
+	err error
+}
+
+func (e skipRouteError) Error() string {
+	return e.err.Error()
+}
+
 func hashPoliciesProtoToSlice(policies []*v3routepb.RouteAction_HashPolicy, logger dubboLogger.Logger) ([]*HashPolicy, error) {
 	var hashPoliciesRet []*HashPolicy
 	for _, p := range policies {
@@ -438,6 +1019,24 @@ func hashPoliciesProtoToSlice(policies []*v3routepb.RouteAction_HashPolicy, logg
 				policy.Regex = re
 				policy.RegexSubstitution = rr.GetSubstitution()
 			}
+		case *v3routepb.RouteAction_HashPolicy_Cookie_:
+			cookie := p.GetCookie()
+			if cookie.GetName() == "" {
+				return nil, skipRouteError{err: fmt.Errorf("hash policy %+v contains a cookie policy with an empty name", p)}
+			}
+			policy.HashPolicyType = HashPolicyTypeCookie
+			policy.CookieName = cookie.GetName()
+			policy.CookiePath = cookie.GetPath()
+			if ttl := cookie.GetTtl(); ttl != nil {
+				d := ttl.AsDuration()
+				policy.CookieTTL = &d
+			}
+		case *v3routepb.RouteAction_HashPolicy_ConnectionProperties_:
+			if !p.GetConnectionProperties().GetSourceIp() {
+				logger.Infof("hash policy %+v contains a connection properties policy without source_ip", p)
+				continue
+			}
+			policy.HashPolicyType = HashPolicyTypeSourceIP
 		case *v3routepb.RouteAction_HashPolicy_FilterState_:
 			if p.GetFilterState().GetKey() != "io.grpc.channel_id" {
 				logger.Infof("hash policy %+v contains an invalid key for filter state policy %q", p, p.GetFilterState().GetKey())
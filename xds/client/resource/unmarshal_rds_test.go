@@ -0,0 +1,1087 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+import (
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	v3matcherpb "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	v3typepb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/grpc/codes"
+
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+import (
+	"dubbo.apache.org/dubbo-go/v3/xds/client/resource/version"
+	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
+	_ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/cors"
+)
+
+// overrideFilterTypeURL is the type URL of the configs of overrideFilter.
+const overrideFilterTypeURL = "type.googleapis.com/google.protobuf.StringValue"
+
+// overrideFilter is an HTTP filter whose configs are StringValues, parsed into
+// overrideConfigs holding the string.
+type overrideFilter struct{}
+
+type overrideConfig struct {
+	httpfilter.FilterConfig
+	value string
+}
+
+func (overrideFilter) TypeURLs() []string { return []string{overrideFilterTypeURL} }
+
+func (overrideFilter) ParseFilterConfig(cfg proto.Message) (httpfilter.FilterConfig, error) {
+	return parseOverrideConfig(cfg)
+}
+
+func (overrideFilter) ParseFilterConfigOverride(cfg proto.Message) (httpfilter.FilterConfig, error) {
+	return parseOverrideConfig(cfg)
+}
+
+func (overrideFilter) IsTerminal() bool { return false }
+
+func parseOverrideConfig(cfg proto.Message) (httpfilter.FilterConfig, error) {
+	s := &wrapperspb.StringValue{}
+	if err := ptypes.UnmarshalAny(cfg.(*anypb.Any), s); err != nil {
+		return nil, err
+	}
+	return overrideConfig{value: s.GetValue()}, nil
+}
+
+func overrideConfigs(t *testing.T, values map[string]string) map[string]*anypb.Any {
+	t.Helper()
+	if values == nil {
+		return nil
+	}
+	ret := make(map[string]*anypb.Any, len(values))
+	for name, v := range values {
+		a, err := ptypes.MarshalAny(wrapperspb.String(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret[name] = a
+	}
+	return ret
+}
+
+func TestEffectiveHTTPFilterConfigOverride(t *testing.T) {
+	httpfilter.Register(overrideFilter{})
+	defer httpfilter.UnregisterForTesting(overrideFilterTypeURL)
+
+	// The listener has the filters "a", "b" and "c".
+	filters := []HTTPFilter{
+		{Name: "a", Filter: overrideFilter{}, Config: overrideConfig{value: "listener-a"}},
+		{Name: "b", Filter: overrideFilter{}, Config: overrideConfig{value: "listener-b"}},
+		{Name: "c", Filter: overrideFilter{}, Config: overrideConfig{value: "listener-c"}},
+	}
+	tests := []struct {
+		name        string
+		vhOverrides map[string]string
+		rOverrides  map[string]string
+		// want is the effective config of each filter.
+		want map[string]string
+	}{
+		{
+			name: "no overrides",
+			want: map[string]string{"a": "listener-a", "b": "listener-b", "c": "listener-c"},
+		},
+		{
+			name:        "inherited from virtual host",
+			vhOverrides: map[string]string{"a": "vh-a"},
+			want:        map[string]string{"a": "vh-a", "b": "listener-b", "c": "listener-c"},
+		},
+		{
+			name:       "route only",
+			rOverrides: map[string]string{"b": "route-b"},
+			want:       map[string]string{"a": "listener-a", "b": "route-b", "c": "listener-c"},
+		},
+		{
+			name:        "all three levels",
+			vhOverrides: map[string]string{"a": "vh-a", "b": "vh-b"},
+			rOverrides:  map[string]string{"b": "route-b"},
+			want:        map[string]string{"a": "vh-a", "b": "route-b", "c": "listener-c"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rc := &v3routepb.RouteConfiguration{
+				Name: "route",
+				VirtualHosts: []*v3routepb.VirtualHost{{
+					Domains: []string{"*"},
+					Routes: []*v3routepb.Route{{
+						Match: &v3routepb.RouteMatch{PathSpecifier: &v3routepb.RouteMatch_Prefix{Prefix: "/"}},
+						Action: &v3routepb.Route_Route{Route: &v3routepb.RouteAction{
+							ClusterSpecifier: &v3routepb.RouteAction_Cluster{Cluster: "cluster"},
+						}},
+						TypedPerFilterConfig: overrideConfigs(t, test.rOverrides),
+					}},
+					TypedPerFilterConfig: overrideConfigs(t, test.vhOverrides),
+				}},
+			}
+			u, err := generateRDSUpdateFromRouteConfiguration(rc, &capturingLogger{}, false)
+			if err != nil {
+				t.Fatalf("generateRDSUpdateFromRouteConfiguration() failed: %v", err)
+			}
+			r := u.VirtualHosts[0].Routes[0]
+			for _, f := range filters {
+				config, override := r.FilterConfigs(f)
+				effective := config
+				if override != nil {
+					effective = override
+				}
+				if got := effective.(overrideConfig).value; got != test.want[f.Name] {
+					t.Errorf("effective config of filter %q = %q, want %q", f.Name, got, test.want[f.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestRouteConfigurationVHDS(t *testing.T) {
+	tests := []struct {
+		name    string
+		vhds    *v3routepb.Vhds
+		v2      bool
+		want    *VHDSConfig
+		wantErr bool
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name: "ads",
+			vhds: &v3routepb.Vhds{ConfigSource: &v3corepb.ConfigSource{
+				ConfigSourceSpecifier: &v3corepb.ConfigSource_Ads{Ads: &v3corepb.AggregatedConfigSource{}},
+				ResourceApiVersion:    v3corepb.ApiVersion_V3,
+			}},
+			want: &VHDSConfig{ResourceAPIVersion: version.TransportV3},
+		},
+		{
+			name: "auto version on v2 transport",
+			vhds: &v3routepb.Vhds{ConfigSource: &v3corepb.ConfigSource{
+				ConfigSourceSpecifier: &v3corepb.ConfigSource_Ads{Ads: &v3corepb.AggregatedConfigSource{}},
+			}},
+			v2:   true,
+			want: &VHDSConfig{ResourceAPIVersion: version.TransportV2},
+		},
+		{
+			name: "not ads",
+			vhds: &v3routepb.Vhds{ConfigSource: &v3corepb.ConfigSource{
+				ConfigSourceSpecifier: &v3corepb.ConfigSource_Self{Self: &v3corepb.SelfConfigSource{}},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The virtual hosts are all fetched on demand, if at all.
+			rc := &v3routepb.RouteConfiguration{Name: "route", Vhds: test.vhds}
+			u, err := generateRDSUpdateFromRouteConfiguration(rc, &capturingLogger{}, test.v2)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("generateRDSUpdateFromRouteConfiguration() = %v, wantErr %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if (u.VHDS == nil) != (test.want == nil) || (u.VHDS != nil && *u.VHDS != *test.want) {
+				t.Errorf("VHDS = %+v, want %+v", u.VHDS, test.want)
+			}
+			if len(u.VirtualHosts) != 0 {
+				t.Errorf("VirtualHosts = %v, want empty", u.VirtualHosts)
+			}
+		})
+	}
+}
+
+func TestRoutesProtoToSliceClusterHeader(t *testing.T) {
+	route := func(prefix, header string) *v3routepb.Route {
+		return &v3routepb.Route{
+			Match: &v3routepb.RouteMatch{PathSpecifier: &v3routepb.RouteMatch_Prefix{Prefix: prefix}},
+			Action: &v3routepb.Route_Route{Route: &v3routepb.RouteAction{
+				ClusterSpecifier: &v3routepb.RouteAction_ClusterHeader{ClusterHeader: header},
+			}},
+		}
+	}
+	fallback := &v3routepb.Route{
+		Match: &v3routepb.RouteMatch{PathSpecifier: &v3routepb.RouteMatch_Prefix{Prefix: "/"}},
+		Action: &v3routepb.Route_Route{Route: &v3routepb.RouteAction{
+			ClusterSpecifier: &v3routepb.RouteAction_Cluster{Cluster: "cluster"},
+		}},
+	}
+
+	// The cluster_header route is skipped, so its RPCs fall through to the
+	// next route.
+	logger := &capturingLogger{}
+	got, _, err := routesProtoToSlice([]*v3routepb.Route{route("/svc", "x-cluster"), fallback}, nil, logger, false)
+	if err != nil {
+		t.Fatalf("routesProtoToSlice() failed: %v", err)
+	}
+	if len(got) != 1 || got[0].WeightedClusters["cluster"].Weight != 1 {
+		t.Errorf("routesProtoToSlice() returned %+v, want only the fallback route", got)
+	}
+	if !logger.hasPrefix("route ") {
+		t.Errorf("logger got lines %q, want a warning for the skipped route", logger.lines)
+	}
+
+	if _, _, err := routesProtoToSlice([]*v3routepb.Route{route("/svc", "")}, nil, logger, false); err == nil {
+		t.Error("routesProtoToSlice() succeeded with an empty cluster_header, want an error")
+	}
+}
+
+func TestMirrorPolicyFromProto(t *testing.T) {
+	fraction := func(n uint32, d v3typepb.FractionalPercent_DenominatorType) *v3corepb.RuntimeFractionalPercent {
+		return &v3corepb.RuntimeFractionalPercent{DefaultValue: &v3typepb.FractionalPercent{Numerator: n, Denominator: d}}
+	}
+	tests := []struct {
+		name    string
+		mp      *v3routepb.RouteAction_RequestMirrorPolicy
+		want    MirrorPolicy
+		wantErr bool
+	}{
+		{
+			name: "cluster without fraction",
+			mp:   &v3routepb.RouteAction_RequestMirrorPolicy{Cluster: "shadow"},
+			want: MirrorPolicy{Cluster: "shadow", Fraction: 1000000},
+		},
+		{
+			name: "cluster header",
+			mp:   &v3routepb.RouteAction_RequestMirrorPolicy{ClusterHeader: "x-shadow"},
+			want: MirrorPolicy{ClusterHeader: "x-shadow", Fraction: 1000000},
+		},
+		{
+			name:    "neither cluster nor cluster header",
+			mp:      &v3routepb.RouteAction_RequestMirrorPolicy{},
+			wantErr: true,
+		},
+		{
+			name:    "both cluster and cluster header",
+			mp:      &v3routepb.RouteAction_RequestMirrorPolicy{Cluster: "shadow", ClusterHeader: "x-shadow"},
+			wantErr: true,
+		},
+		{
+			name: "percent fraction",
+			mp:   &v3routepb.RouteAction_RequestMirrorPolicy{Cluster: "shadow", RuntimeFraction: fraction(25, v3typepb.FractionalPercent_HUNDRED)},
+			want: MirrorPolicy{Cluster: "shadow", Fraction: 250000},
+		},
+		{
+			name: "ten thousand fraction",
+			mp:   &v3routepb.RouteAction_RequestMirrorPolicy{Cluster: "shadow", RuntimeFraction: fraction(25, v3typepb.FractionalPercent_TEN_THOUSAND)},
+			want: MirrorPolicy{Cluster: "shadow", Fraction: 2500},
+		},
+		{
+			name: "million fraction",
+			mp:   &v3routepb.RouteAction_RequestMirrorPolicy{Cluster: "shadow", RuntimeFraction: fraction(25, v3typepb.FractionalPercent_MILLION)},
+			want: MirrorPolicy{Cluster: "shadow", Fraction: 25},
+		},
+		{
+			name:    "numerator above denominator",
+			mp:      &v3routepb.RouteAction_RequestMirrorPolicy{Cluster: "shadow", RuntimeFraction: fraction(101, v3typepb.FractionalPercent_HUNDRED)},
+			wantErr: true,
+		},
+		{
+			name:    "numerator overflowing parts per million",
+			mp:      &v3routepb.RouteAction_RequestMirrorPolicy{Cluster: "shadow", RuntimeFraction: fraction(1<<31, v3typepb.FractionalPercent_HUNDRED)},
+			wantErr: true,
+		},
+		{
+			name:    "unknown denominator",
+			mp:      &v3routepb.RouteAction_RequestMirrorPolicy{Cluster: "shadow", RuntimeFraction: fraction(1, 42)},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := mirrorPolicyFromProto(test.mp)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("mirrorPolicyFromProto() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("mirrorPolicyFromProto() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCORSPolicyFromFilterOverrides(t *testing.T) {
+	policy := func(maxAge string) *anypb.Any {
+		a, err := ptypes.MarshalAny(&v3routepb.CorsPolicy{AllowMethods: "GET, POST", MaxAge: maxAge})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	tests := []struct {
+		name       string
+		cfgs       map[string]*anypb.Any
+		wantPolicy bool
+		wantErr    bool
+	}{
+		{
+			name: "no overrides",
+		},
+		{
+			name:       "one CORS policy",
+			cfgs:       map[string]*anypb.Any{"cors": policy("60")},
+			wantPolicy: true,
+		},
+		{
+			name:    "invalid CORS policy",
+			cfgs:    map[string]*anypb.Any{"cors": policy("-1")},
+			wantErr: true,
+		},
+		{
+			name:    "two CORS policies",
+			cfgs:    map[string]*anypb.Any{"cors": policy("60"), "other-cors": policy("120")},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := corsPolicyFromFilterOverrides(test.cfgs)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("corsPolicyFromFilterOverrides() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if (got != nil) != test.wantPolicy {
+				t.Fatalf("corsPolicyFromFilterOverrides() = %+v, want a policy: %v", got, test.wantPolicy)
+			}
+			if got != nil && !reflect.DeepEqual(got.AllowMethods, []string{"GET", "POST"}) {
+				t.Errorf("AllowMethods = %q, want %q", got.AllowMethods, []string{"GET", "POST"})
+			}
+		})
+	}
+}
+
+func TestRoutesProtoToSliceTimeouts(t *testing.T) {
+	invalid := &durationpb.Duration{Seconds: 1, Nanos: -1}
+	tests := []struct {
+		name        string
+		action      *v3routepb.RouteAction
+		wantTimeout *time.Duration
+		wantMSD     *time.Duration
+		wantErr     bool
+	}{
+		{
+			name:   "unset",
+			action: &v3routepb.RouteAction{},
+		},
+		{
+			name:        "zero timeout disables it",
+			action:      &v3routepb.RouteAction{Timeout: durationpb.New(0)},
+			wantTimeout: newDuration(0),
+		},
+		{
+			name: "timeout and max stream duration",
+			action: &v3routepb.RouteAction{
+				Timeout:           durationpb.New(time.Second),
+				MaxStreamDuration: &v3routepb.RouteAction_MaxStreamDuration{MaxStreamDuration: durationpb.New(time.Minute)},
+			},
+			wantTimeout: newDuration(time.Second),
+			wantMSD:     newDuration(time.Minute),
+		},
+		{
+			name: "grpc timeout header max preferred",
+			action: &v3routepb.RouteAction{MaxStreamDuration: &v3routepb.RouteAction_MaxStreamDuration{
+				MaxStreamDuration:    durationpb.New(time.Minute),
+				GrpcTimeoutHeaderMax: durationpb.New(time.Second),
+			}},
+			wantMSD: newDuration(time.Second),
+		},
+		{
+			name:    "negative timeout",
+			action:  &v3routepb.RouteAction{Timeout: durationpb.New(-time.Second)},
+			wantErr: true,
+		},
+		{
+			name:    "invalid timeout",
+			action:  &v3routepb.RouteAction{Timeout: invalid},
+			wantErr: true,
+		},
+		{
+			name:    "invalid max stream duration",
+			action:  &v3routepb.RouteAction{MaxStreamDuration: &v3routepb.RouteAction_MaxStreamDuration{MaxStreamDuration: invalid}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.action.ClusterSpecifier = &v3routepb.RouteAction_Cluster{Cluster: "cluster"}
+			routes, _, err := routesProtoToSlice([]*v3routepb.Route{{
+				Match:  &v3routepb.RouteMatch{PathSpecifier: &v3routepb.RouteMatch_Prefix{Prefix: "/"}},
+				Action: &v3routepb.Route_Route{Route: test.action},
+			}}, nil, &capturingLogger{}, false)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("routesProtoToSlice() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := routes[0].Timeout; !reflect.DeepEqual(got, test.wantTimeout) {
+				t.Errorf("Timeout = %v, want %v", got, test.wantTimeout)
+			}
+			if got := routes[0].MaxStreamDuration; !reflect.DeepEqual(got, test.wantMSD) {
+				t.Errorf("MaxStreamDuration = %v, want %v", got, test.wantMSD)
+			}
+		})
+	}
+}
+
+func newDuration(d time.Duration) *time.Duration {
+	return &d
+}
+
+// clusterRoute returns a route matching prefix and sending RPCs to the cluster
+// "cluster".
+func clusterRoute(prefix string) *v3routepb.Route {
+	return &v3routepb.Route{
+		Match: &v3routepb.RouteMatch{PathSpecifier: &v3routepb.RouteMatch_Prefix{Prefix: prefix}},
+		Action: &v3routepb.Route_Route{Route: &v3routepb.RouteAction{
+			ClusterSpecifier: &v3routepb.RouteAction_Cluster{Cluster: "cluster"},
+		}},
+	}
+}
+
+func TestRoutesProtoToSliceActions(t *testing.T) {
+	withAction := func(r *v3routepb.Route) *v3routepb.Route {
+		r.Match = &v3routepb.RouteMatch{PathSpecifier: &v3routepb.RouteMatch_Prefix{Prefix: "/"}}
+		return r
+	}
+	directResponse := func(status uint32, body *v3corepb.DataSource) *v3routepb.Route {
+		return withAction(&v3routepb.Route{Action: &v3routepb.Route_DirectResponse{DirectResponse: &v3routepb.DirectResponseAction{Status: status, Body: body}}})
+	}
+	redirect := func(ra *v3routepb.RedirectAction) *v3routepb.Route {
+		return withAction(&v3routepb.Route{Action: &v3routepb.Route_Redirect{Redirect: ra}})
+	}
+	tests := []struct {
+		name         string
+		route        *v3routepb.Route
+		wantType     RouteActionType
+		wantDirect   *DirectResponse
+		wantRedirect *Redirect
+		wantErr      bool
+	}{
+		{
+			name:     "cluster",
+			route:    clusterRoute("/"),
+			wantType: RouteActionRoute,
+		},
+		{
+			name:       "direct response without body",
+			route:      directResponse(503, nil),
+			wantType:   RouteActionDirectResponse,
+			wantDirect: &DirectResponse{Status: 503},
+		},
+		{
+			name:       "direct response with inline string",
+			route:      directResponse(200, &v3corepb.DataSource{Specifier: &v3corepb.DataSource_InlineString{InlineString: "down for maintenance"}}),
+			wantType:   RouteActionDirectResponse,
+			wantDirect: &DirectResponse{Status: 200, Body: "down for maintenance"},
+		},
+		{
+			name:       "direct response with inline bytes",
+			route:      directResponse(200, &v3corepb.DataSource{Specifier: &v3corepb.DataSource_InlineBytes{InlineBytes: []byte("ok")}}),
+			wantType:   RouteActionDirectResponse,
+			wantDirect: &DirectResponse{Status: 200, Body: "ok"},
+		},
+		{
+			name:    "direct response with file body",
+			route:   directResponse(200, &v3corepb.DataSource{Specifier: &v3corepb.DataSource_Filename{Filename: "/etc/body"}}),
+			wantErr: true,
+		},
+		{
+			name:    "direct response with invalid status",
+			route:   directResponse(42, nil),
+			wantErr: true,
+		},
+		{
+			name:         "redirect defaults to moved permanently",
+			route:        redirect(&v3routepb.RedirectAction{HostRedirect: "example.com"}),
+			wantType:     RouteActionRedirect,
+			wantRedirect: &Redirect{Host: "example.com", ResponseCode: 301},
+		},
+		{
+			name: "https redirect",
+			route: redirect(&v3routepb.RedirectAction{
+				SchemeRewriteSpecifier: &v3routepb.RedirectAction_HttpsRedirect{HttpsRedirect: true},
+				PortRedirect:           8443,
+				ResponseCode:           v3routepb.RedirectAction_PERMANENT_REDIRECT,
+			}),
+			wantType:     RouteActionRedirect,
+			wantRedirect: &Redirect{Scheme: "https", Port: 8443, ResponseCode: 308},
+		},
+		{
+			name: "path redirect",
+			route: redirect(&v3routepb.RedirectAction{
+				PathRewriteSpecifier: &v3routepb.RedirectAction_PathRedirect{PathRedirect: "/new"},
+				ResponseCode:         v3routepb.RedirectAction_TEMPORARY_REDIRECT,
+				StripQuery:           true,
+			}),
+			wantType:     RouteActionRedirect,
+			wantRedirect: &Redirect{PathRedirect: "/new", ResponseCode: 307, StripQuery: true},
+		},
+		{
+			name: "prefix rewrite redirect",
+			route: redirect(&v3routepb.RedirectAction{
+				PathRewriteSpecifier: &v3routepb.RedirectAction_PrefixRewrite{PrefixRewrite: "/v2/"},
+				ResponseCode:         v3routepb.RedirectAction_FOUND,
+			}),
+			wantType:     RouteActionRedirect,
+			wantRedirect: &Redirect{PrefixRewrite: "/v2/", ResponseCode: 302},
+		},
+		{
+			name:    "redirect with unknown response code",
+			route:   redirect(&v3routepb.RedirectAction{ResponseCode: 42}),
+			wantErr: true,
+		},
+		{
+			name:    "no action",
+			route:   withAction(&v3routepb.Route{}),
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			routes, _, err := routesProtoToSlice([]*v3routepb.Route{test.route}, nil, &capturingLogger{}, false)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("routesProtoToSlice() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			r := routes[0]
+			if r.ActionType != test.wantType {
+				t.Errorf("ActionType = %v, want %v", r.ActionType, test.wantType)
+			}
+			if !reflect.DeepEqual(r.DirectResponse, test.wantDirect) {
+				t.Errorf("DirectResponse = %+v, want %+v", r.DirectResponse, test.wantDirect)
+			}
+			if !reflect.DeepEqual(r.Redirect, test.wantRedirect) {
+				t.Errorf("Redirect = %+v, want %+v", r.Redirect, test.wantRedirect)
+			}
+		})
+	}
+}
+
+func TestRoutesProtoToSliceWeightedClusters(t *testing.T) {
+	weighted := func(total *wrapperspb.UInt32Value, cs ...*v3routepb.WeightedCluster_ClusterWeight) *v3routepb.Route {
+		r := clusterRoute("/")
+		r.GetRoute().ClusterSpecifier = &v3routepb.RouteAction_WeightedClusters{WeightedClusters: &v3routepb.WeightedCluster{
+			Clusters:    cs,
+			TotalWeight: total,
+		}}
+		return r
+	}
+	cluster := func(name string, weight uint32) *v3routepb.WeightedCluster_ClusterWeight {
+		return &v3routepb.WeightedCluster_ClusterWeight{Name: name, Weight: wrapperspb.UInt32(weight)}
+	}
+	tests := []struct {
+		name    string
+		route   *v3routepb.Route
+		want    map[string]uint32
+		wantErr bool
+	}{
+		{
+			name:  "total weight defaults to 100",
+			route: weighted(nil, cluster("a", 90), cluster("b", 10)),
+			want:  map[string]uint32{"a": 90, "b": 10},
+		},
+		{
+			name:  "explicit total weight",
+			route: weighted(wrapperspb.UInt32(3), cluster("a", 1), cluster("b", 2)),
+			want:  map[string]uint32{"a": 1, "b": 2},
+		},
+		{
+			name:  "zero weight cluster dropped",
+			route: weighted(nil, cluster("a", 100), cluster("b", 0)),
+			want:  map[string]uint32{"a": 100},
+		},
+		{
+			name:    "weights not adding up to the total",
+			route:   weighted(nil, cluster("a", 50), cluster("b", 10)),
+			wantErr: true,
+		},
+		{
+			name:    "only cluster has zero weight",
+			route:   weighted(wrapperspb.UInt32(0), cluster("a", 0)),
+			wantErr: true,
+		},
+		{
+			name:    "all clusters have zero weight",
+			route:   weighted(wrapperspb.UInt32(0), cluster("a", 0), cluster("b", 0)),
+			wantErr: true,
+		},
+		{
+			name:    "duplicate cluster",
+			route:   weighted(nil, cluster("a", 50), cluster("a", 50)),
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			routes, _, err := routesProtoToSlice([]*v3routepb.Route{test.route}, nil, &capturingLogger{}, false)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("routesProtoToSlice() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got := make(map[string]uint32)
+			for name, wc := range routes[0].WeightedClusters {
+				got[name] = wc.Weight
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("weights of WeightedClusters = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHeaderMatchersProtoToSlice(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name     string
+		hm       *v3routepb.HeaderMatcher
+		want     *HeaderMatcher
+		wantSkip bool
+		wantErr  bool
+	}{
+		{
+			name: "exact",
+			hm:   &v3routepb.HeaderMatcher{Name: "h", HeaderMatchSpecifier: &v3routepb.HeaderMatcher_ExactMatch{ExactMatch: "v"}},
+			want: &HeaderMatcher{Name: "h", InvertMatch: newBool(false), ExactMatch: str("v")},
+		},
+		{
+			name: "inverted prefix",
+			hm:   &v3routepb.HeaderMatcher{Name: "h", HeaderMatchSpecifier: &v3routepb.HeaderMatcher_PrefixMatch{PrefixMatch: "v"}, InvertMatch: true},
+			want: &HeaderMatcher{Name: "h", InvertMatch: newBool(true), PrefixMatch: str("v")},
+		},
+		{
+			name: "suffix",
+			hm:   &v3routepb.HeaderMatcher{Name: "h", HeaderMatchSpecifier: &v3routepb.HeaderMatcher_SuffixMatch{SuffixMatch: "v"}},
+			want: &HeaderMatcher{Name: "h", InvertMatch: newBool(false), SuffixMatch: str("v")},
+		},
+		{
+			name: "contains",
+			hm:   &v3routepb.HeaderMatcher{Name: "h", HeaderMatchSpecifier: &v3routepb.HeaderMatcher_ContainsMatch{ContainsMatch: "v"}},
+			want: &HeaderMatcher{Name: "h", InvertMatch: newBool(false), ContainsMatch: str("v")},
+		},
+		{
+			name: "present",
+			hm:   &v3routepb.HeaderMatcher{Name: "h", HeaderMatchSpecifier: &v3routepb.HeaderMatcher_PresentMatch{PresentMatch: true}},
+			want: &HeaderMatcher{Name: "h", InvertMatch: newBool(false), PresentMatch: newBool(true)},
+		},
+		{
+			name: "range",
+			hm:   &v3routepb.HeaderMatcher{Name: "h", HeaderMatchSpecifier: &v3routepb.HeaderMatcher_RangeMatch{RangeMatch: &v3typepb.Int64Range{Start: 1, End: 10}}},
+			want: &HeaderMatcher{Name: "h", InvertMatch: newBool(false), RangeMatch: &Int64Range{Start: 1, End: 10}},
+		},
+		{
+			name:     "invalid regex",
+			hm:       &v3routepb.HeaderMatcher{Name: "h", HeaderMatchSpecifier: &v3routepb.HeaderMatcher_SafeRegexMatch{SafeRegexMatch: &v3matcherpb.RegexMatcher{Regex: "("}}},
+			wantErr:  true,
+			wantSkip: true,
+		},
+		{
+			name:    "empty regex",
+			hm:      &v3routepb.HeaderMatcher{Name: "h", HeaderMatchSpecifier: &v3routepb.HeaderMatcher_SafeRegexMatch{SafeRegexMatch: &v3matcherpb.RegexMatcher{}}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := headerMatchersProtoToSlice([]*v3routepb.HeaderMatcher{test.hm})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("headerMatchersProtoToSlice() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				if _, skip := err.(skipRouteError); skip != test.wantSkip {
+					t.Errorf("headerMatchersProtoToSlice() returned err %v, want a skipRouteError: %v", err, test.wantSkip)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, []*HeaderMatcher{test.want}) {
+				t.Errorf("headerMatchersProtoToSlice() = %+v, want %+v", got[0], test.want)
+			}
+		})
+	}
+
+	t.Run("regex", func(t *testing.T) {
+		got, err := headerMatchersProtoToSlice([]*v3routepb.HeaderMatcher{{
+			Name:                 "h",
+			HeaderMatchSpecifier: &v3routepb.HeaderMatcher_SafeRegexMatch{SafeRegexMatch: &v3matcherpb.RegexMatcher{Regex: "^v[0-9]$"}},
+		}})
+		if err != nil {
+			t.Fatalf("headerMatchersProtoToSlice() failed: %v", err)
+		}
+		if re := got[0].RegexMatch; re == nil || !re.MatchString("v1") || re.MatchString("v10") {
+			t.Errorf("RegexMatch = %v, want a regex matching ^v[0-9]$", re)
+		}
+	})
+}
+
+func TestRoutesProtoToSliceMatch(t *testing.T) {
+	invalidHeader := clusterRoute("/svc")
+	invalidHeader.Match.Headers = []*v3routepb.HeaderMatcher{{
+		Name:                 "h",
+		HeaderMatchSpecifier: &v3routepb.HeaderMatcher_SafeRegexMatch{SafeRegexMatch: &v3matcherpb.RegexMatcher{Regex: "("}},
+	}}
+	insensitive := clusterRoute("/Svc")
+	insensitive.Match.CaseSensitive = wrapperspb.Bool(false)
+	sensitive := clusterRoute("/svc")
+	sensitive.Match.CaseSensitive = wrapperspb.Bool(true)
+
+	// The route with the invalid header regex is skipped, and the others are
+	// kept in order.
+	logger := &capturingLogger{}
+	routes, _, err := routesProtoToSlice([]*v3routepb.Route{invalidHeader, insensitive, sensitive, clusterRoute("/")}, nil, logger, false)
+	if err != nil {
+		t.Fatalf("routesProtoToSlice() failed: %v", err)
+	}
+	if len(routes) != 3 {
+		t.Fatalf("routesProtoToSlice() returned %d routes, want 3", len(routes))
+	}
+	if !logger.hasPrefix("route ") {
+		t.Errorf("logger got lines %q, want a warning for the skipped route", logger.lines)
+	}
+	for i, want := range []bool{true, false, false} {
+		if got := routes[i].CaseInsensitive; got != want {
+			t.Errorf("route %q: CaseInsensitive = %v, want %v", *routes[i].Prefix, got, want)
+		}
+	}
+}
+
+func TestRoutesProtoToSliceRewrite(t *testing.T) {
+	regexRewrite := func(regex, sub string) *v3matcherpb.RegexMatchAndSubstitute {
+		return &v3matcherpb.RegexMatchAndSubstitute{Pattern: &v3matcherpb.RegexMatcher{Regex: regex}, Substitution: sub}
+	}
+	tests := []struct {
+		name          string
+		prefixRewrite string
+		regexRewrite  *v3matcherpb.RegexMatchAndSubstitute
+		// path is rewritten by the regex rewrite into wantPath.
+		path, wantPath string
+		wantErr        bool
+	}{
+		{
+			name:          "prefix rewrite",
+			prefixRewrite: "/v2/",
+		},
+		{
+			name:         "regex rewrite",
+			regexRewrite: regexRewrite(`^/svc/([^/]+)/(.*)$`, `/\2/\1`),
+			path:         "/svc/foo/bar",
+			wantPath:     "/bar/foo",
+		},
+		{
+			name:         "regex rewrite with dollar",
+			regexRewrite: regexRewrite(`^/svc/(.*)$`, `/$\1`),
+			path:         "/svc/foo",
+			wantPath:     "/$foo",
+		},
+		{
+			name:         "invalid regex",
+			regexRewrite: regexRewrite("(", ""),
+			wantErr:      true,
+		},
+		{
+			name:          "prefix and regex rewrite",
+			prefixRewrite: "/v2/",
+			regexRewrite:  regexRewrite("^/svc", "/v2"),
+			wantErr:       true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := clusterRoute("/")
+			r.GetRoute().PrefixRewrite = test.prefixRewrite
+			r.GetRoute().RegexRewrite = test.regexRewrite
+			routes, _, err := routesProtoToSlice([]*v3routepb.Route{r}, nil, &capturingLogger{}, false)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("routesProtoToSlice() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := routes[0].PrefixRewrite; got != test.prefixRewrite {
+				t.Errorf("PrefixRewrite = %q, want %q", got, test.prefixRewrite)
+			}
+			rr := routes[0].RegexRewrite
+			if (rr != nil) != (test.regexRewrite != nil) {
+				t.Fatalf("RegexRewrite = %+v, want set: %v", rr, test.regexRewrite != nil)
+			}
+			if rr != nil {
+				if got := rr.Rewrite(test.path); got != test.wantPath {
+					t.Errorf("Rewrite(%q) = %q, want %q", test.path, got, test.wantPath)
+				}
+			}
+		})
+	}
+}
+
+func TestRouteConfigurationHeaderMutations(t *testing.T) {
+	header := func(key, value string, appendValue *wrapperspb.BoolValue) *v3corepb.HeaderValueOption {
+		return &v3corepb.HeaderValueOption{Header: &v3corepb.HeaderValue{Key: key, Value: value}, Append: appendValue}
+	}
+	route := clusterRoute("/")
+	route.RequestHeadersToAdd = []*v3corepb.HeaderValueOption{header("x-b", "route", wrapperspb.Bool(false))}
+	route.RequestHeadersToRemove = []string{"X-Remove", "x-route-remove"}
+	route.ResponseHeadersToAdd = []*v3corepb.HeaderValueOption{header("x-client", "%DOWNSTREAM_REMOTE_ADDRESS%", nil)}
+	rc := &v3routepb.RouteConfiguration{
+		Name:                   "route",
+		RequestHeadersToAdd:    []*v3corepb.HeaderValueOption{header("x-a", "rc", wrapperspb.Bool(false)), header("x-b", "rc", nil)},
+		RequestHeadersToRemove: []string{"x-remove"},
+		VirtualHosts: []*v3routepb.VirtualHost{{
+			Domains:             []string{"*"},
+			RequestHeadersToAdd: []*v3corepb.HeaderValueOption{header("x-a", "vh", wrapperspb.Bool(false))},
+			Routes:              []*v3routepb.Route{route},
+		}},
+	}
+	u, err := generateRDSUpdateFromRouteConfiguration(rc, &capturingLogger{}, false)
+	if err != nil {
+		t.Fatalf("generateRDSUpdateFromRouteConfiguration() failed: %v", err)
+	}
+
+	// The virtual host replaces the x-a of the route configuration, and the
+	// route the x-b of both.
+	wantVH := HeaderMutations{
+		RequestHeadersToAdd: []HeaderValueOption{
+			{Key: "x-b", Value: "rc", Append: true},
+			{Key: "x-a", Value: "vh"},
+		},
+		RequestHeadersToRemove: []string{"x-remove"},
+	}
+	if got := u.VirtualHosts[0].HeaderMutations; !reflect.DeepEqual(got, wantVH) {
+		t.Errorf("virtual host HeaderMutations = %+v, want %+v", got, wantVH)
+	}
+	wantRoute := HeaderMutations{
+		RequestHeadersToAdd: []HeaderValueOption{
+			{Key: "x-a", Value: "vh"},
+			{Key: "x-b", Value: "route"},
+		},
+		RequestHeadersToRemove: []string{"x-remove", "x-route-remove"},
+		ResponseHeadersToAdd:   []HeaderValueOption{{Key: "x-client", Value: "%DOWNSTREAM_REMOTE_ADDRESS%", Append: true}},
+	}
+	if got := u.VirtualHosts[0].Routes[0].HeaderMutations; !reflect.DeepEqual(got, wantRoute) {
+		t.Errorf("route HeaderMutations = %+v, want %+v", got, wantRoute)
+	}
+
+	rc.VirtualHosts[0].ResponseHeadersToRemove = []string{"bad header"}
+	if _, err := generateRDSUpdateFromRouteConfiguration(rc, &capturingLogger{}, false); err == nil {
+		t.Error("generateRDSUpdateFromRouteConfiguration() succeeded with an invalid header name, want an error")
+	}
+}
+
+func TestRouteConfigurationDomains(t *testing.T) {
+	vh := func(name string, domains ...string) *v3routepb.VirtualHost {
+		return &v3routepb.VirtualHost{Name: name, Domains: domains, Routes: []*v3routepb.Route{clusterRoute("/")}}
+	}
+	rc := &v3routepb.RouteConfiguration{
+		Name: "route",
+		VirtualHosts: []*v3routepb.VirtualHost{
+			vh("any", "*"),
+			vh("suffix", "*.example.com"),
+			vh("exact", "foo.example.com", "foo.example.com:8080"),
+			vh("prefix", "foo.*"),
+		},
+	}
+	u, err := generateRDSUpdateFromRouteConfiguration(rc, &capturingLogger{}, false)
+	if err != nil {
+		t.Fatalf("generateRDSUpdateFromRouteConfiguration() failed: %v", err)
+	}
+	for host, want := range map[string]int{
+		"foo.example.com":      2,
+		"foo.example.com:8080": 2,
+		"bar.example.com":      1,
+		"foo.test":             3,
+		"bar.test":             0,
+	} {
+		if got := u.FindBestMatchingVirtualHost(host); got != u.VirtualHosts[want] {
+			t.Errorf("FindBestMatchingVirtualHost(%q) = %v, want virtual host %q", host, got, rc.VirtualHosts[want].Name)
+		}
+	}
+
+	// Domains are case insensitive, so the same domain can't be in two
+	// virtual hosts in different cases.
+	rc.VirtualHosts = append(rc.VirtualHosts, vh("dup", "FOO.example.com"))
+	if _, err := generateRDSUpdateFromRouteConfiguration(rc, &capturingLogger{}, false); err == nil {
+		t.Error("generateRDSUpdateFromRouteConfiguration() succeeded with a domain in two virtual hosts, want an error")
+	}
+}
+
+func TestRouteConfigurationRetryPolicy(t *testing.T) {
+	own := clusterRoute("/own")
+	own.GetRoute().RetryPolicy = &v3routepb.RetryPolicy{RetryOn: "internal"}
+	redirect := &v3routepb.Route{
+		Match:  &v3routepb.RouteMatch{PathSpecifier: &v3routepb.RouteMatch_Prefix{Prefix: "/old"}},
+		Action: &v3routepb.Route_Redirect{Redirect: &v3routepb.RedirectAction{HostRedirect: "example.com"}},
+	}
+	rc := &v3routepb.RouteConfiguration{
+		Name: "route",
+		VirtualHosts: []*v3routepb.VirtualHost{{
+			Domains:                       []string{"*"},
+			Routes:                        []*v3routepb.Route{own, redirect, clusterRoute("/")},
+			RetryPolicy:                   &v3routepb.RetryPolicy{RetryOn: "unavailable"},
+			IncludeRequestAttemptCount:    true,
+			IncludeAttemptCountInResponse: true,
+		}},
+	}
+	u, err := generateRDSUpdateFromRouteConfiguration(rc, &capturingLogger{}, false)
+	if err != nil {
+		t.Fatalf("generateRDSUpdateFromRouteConfiguration() failed: %v", err)
+	}
+	vh := u.VirtualHosts[0]
+	if !vh.IncludeRequestAttemptCount || !vh.IncludeAttemptCountInResponse {
+		t.Errorf("IncludeRequestAttemptCount, IncludeAttemptCountInResponse = %v, %v, want true, true", vh.IncludeRequestAttemptCount, vh.IncludeAttemptCountInResponse)
+	}
+	if got := vh.Routes[0].RetryConfig.RetryOn; !reflect.DeepEqual(got, map[codes.Code]bool{codes.Internal: true}) {
+		t.Errorf("retry_on of the route with its own retry policy = %v, want internal", got)
+	}
+	if got := vh.Routes[1].RetryConfig; got != nil {
+		t.Errorf("RetryConfig of the redirect route = %+v, want nil", got)
+	}
+	if got := vh.Routes[2].RetryConfig; got != vh.RetryConfig || !got.RetryOn[codes.Unavailable] {
+		t.Errorf("RetryConfig of the route without a retry policy = %+v, want the virtual host's %+v", got, vh.RetryConfig)
+	}
+
+	rc.VirtualHosts[0].RetryPolicy.NumRetries = wrapperspb.UInt32(0)
+	if _, err := generateRDSUpdateFromRouteConfiguration(rc, &capturingLogger{}, false); err == nil {
+		t.Error("generateRDSUpdateFromRouteConfiguration() succeeded with a virtual host retry policy of 0 retries, want an error")
+	}
+}
+
+func TestGenerateRetryConfig(t *testing.T) {
+	defaultBackoff := RetryBackoff{BaseInterval: 25 * time.Millisecond, MaxInterval: 250 * time.Millisecond}
+	tests := []struct {
+		name    string
+		rp      *v3routepb.RetryPolicy
+		want    *RetryConfig
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			rp:   &v3routepb.RetryPolicy{RetryOn: "unavailable, deadline-exceeded,unknown"},
+			want: &RetryConfig{
+				RetryOn:      map[codes.Code]bool{codes.Unavailable: true, codes.DeadlineExceeded: true},
+				NumRetries:   1,
+				RetryBackoff: defaultBackoff,
+			},
+		},
+		{
+			name: "max interval defaults to ten times the base interval",
+			rp: &v3routepb.RetryPolicy{
+				RetryOn:      "unavailable",
+				NumRetries:   wrapperspb.UInt32(3),
+				RetryBackOff: &v3routepb.RetryPolicy_RetryBackOff{BaseInterval: durationpb.New(100 * time.Millisecond)},
+			},
+			want: &RetryConfig{
+				RetryOn:      map[codes.Code]bool{codes.Unavailable: true},
+				NumRetries:   3,
+				RetryBackoff: RetryBackoff{BaseInterval: 100 * time.Millisecond, MaxInterval: time.Second},
+			},
+		},
+		{
+			name: "base interval above max interval",
+			rp: &v3routepb.RetryPolicy{
+				RetryOn: "unavailable",
+				RetryBackOff: &v3routepb.RetryPolicy_RetryBackOff{
+					BaseInterval: durationpb.New(2 * time.Second),
+					MaxInterval:  durationpb.New(time.Second),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "zero retries",
+			rp:      &v3routepb.RetryPolicy{RetryOn: "unavailable", NumRetries: wrapperspb.UInt32(0)},
+			wantErr: true,
+		},
+		{
+			name: "retriable status codes",
+			rp: &v3routepb.RetryPolicy{
+				RetryOn:              "retriable-status-codes",
+				RetriableStatusCodes: []uint32{503, 99, 600, 404},
+			},
+			want: &RetryConfig{
+				RetryOn:              map[codes.Code]bool{},
+				NumRetries:           1,
+				RetryBackoff:         defaultBackoff,
+				RetriableStatusCodes: []uint32{503, 404},
+			},
+		},
+		{
+			name: "retriable status codes without retry_on token",
+			rp:   &v3routepb.RetryPolicy{RetriableStatusCodes: []uint32{503}},
+			want: &RetryConfig{},
+		},
+		{
+			name: "retriable headers",
+			rp: &v3routepb.RetryPolicy{
+				RetryOn: "retriable-headers",
+				RetriableHeaders: []*v3routepb.HeaderMatcher{{
+					Name:                 "x-retry",
+					HeaderMatchSpecifier: &v3routepb.HeaderMatcher_PresentMatch{PresentMatch: true},
+				}},
+			},
+			want: &RetryConfig{
+				RetryOn:          map[codes.Code]bool{},
+				NumRetries:       1,
+				RetryBackoff:     defaultBackoff,
+				RetriableHeaders: []*HeaderMatcher{{Name: "x-retry", InvertMatch: newBool(false), PresentMatch: newBool(true)}},
+			},
+		},
+		{
+			name: "invalid retriable request header",
+			rp: &v3routepb.RetryPolicy{
+				RetryOn: "unavailable",
+				RetriableRequestHeaders: []*v3routepb.HeaderMatcher{{
+					Name:                 "x-retry",
+					HeaderMatchSpecifier: &v3routepb.HeaderMatcher_SafeRegexMatch{SafeRegexMatch: &v3matcherpb.RegexMatcher{}},
+				}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := generateRetryConfig(test.rp, &capturingLogger{})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("generateRetryConfig() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("generateRetryConfig() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func newBool(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,84 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// ValidateListener runs the validation applied to the Listener resources of
+// a response on r, and returns the error which would NACK it, if any. It
+// needs no logger, and leaves r untouched. Unlike in a response, both v2 and
+// v3 resources are accepted.
+//
+// It is safe to call concurrently.
+func ValidateListener(r *anypb.Any) error {
+	opts := validateOptions()
+	return validateResource(ListenerResource, opts, r, func(r *anypb.Any) (string, interface{}, error) {
+		return unmarshalListenerResource(r, opts)
+	})
+}
+
+// ValidateRouteConfig is ValidateListener for RouteConfiguration resources.
+func ValidateRouteConfig(r *anypb.Any) error {
+	opts := validateOptions()
+	return validateResource(RouteConfigResource, opts, r, func(r *anypb.Any) (string, interface{}, error) {
+		return unmarshalRouteConfigResource(r, opts.Logger, opts.TransportAPI)
+	})
+}
+
+// ValidateCluster is ValidateListener for Cluster resources.
+func ValidateCluster(r *anypb.Any) error {
+	opts := validateOptions()
+	return validateResource(ClusterResource, opts, r, func(r *anypb.Any) (string, interface{}, error) {
+		return unmarshalClusterResource(r, nil, opts.Logger, opts.TransportAPI)
+	})
+}
+
+// ValidateEndpoints is ValidateListener for ClusterLoadAssignment resources.
+func ValidateEndpoints(r *anypb.Any) error {
+	opts := validateOptions()
+	return validateResource(EndpointsResource, opts, r, func(r *anypb.Any) (string, interface{}, error) {
+		return unmarshalEndpointsResource(r, opts.Logger, opts.TransportAPI)
+	})
+}
+
+// validateOptions returns the options resources are validated with. The
+// zero TransportAPI accepts both v2 and v3 resources.
+func validateOptions() *UnmarshalOptions {
+	return &UnmarshalOptions{Logger: discardLogger{}}
+}
+
+func validateResource(rType ResourceType, opts *UnmarshalOptions, r *anypb.Any, unmarshal func(*anypb.Any) (string, interface{}, error)) error {
+	_, _, err := withRecover(rType, withRegisteredTypes(rType, opts, unmarshal))(r)
+	return err
+}
+
+// discardLogger is a dubboLogger.Logger which discards everything.
+type discardLogger struct{}
+
+func (discardLogger) Info(args ...interface{})                  {}
+func (discardLogger) Warn(args ...interface{})                  {}
+func (discardLogger) Error(args ...interface{})                 {}
+func (discardLogger) Debug(args ...interface{})                 {}
+func (discardLogger) Fatal(args ...interface{})                 {}
+func (discardLogger) Infof(format string, args ...interface{})  {}
+func (discardLogger) Warnf(format string, args ...interface{})  {}
+func (discardLogger) Errorf(format string, args ...interface{}) {}
+func (discardLogger) Debugf(format string, args ...interface{}) {}
+func (discardLogger) Fatalf(format string, args ...interface{}) {}
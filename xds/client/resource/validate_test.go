@@ -0,0 +1,47 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resource
+
+import (
+	"testing"
+)
+
+import (
+	"google.golang.org/protobuf/proto"
+)
+
+func TestValidateListener(t *testing.T) {
+	good := clientListenerResource(t, "good-listener")
+	bad := clientListenerResource(t, "bad-listener")
+	bad.Value = bad.Value[:len(bad.Value)-1]
+
+	want := proto.Clone(good)
+	if err := ValidateListener(good); err != nil {
+		t.Errorf("ValidateListener() failed: %v", err)
+	}
+	if !proto.Equal(good, want) {
+		t.Errorf("ValidateListener() modified the resource to %v, want %v", good, want)
+	}
+	err := ValidateListener(bad)
+	if r := NACKReasonOf(err); r != ReasonUnmarshalFailed {
+		t.Errorf("NACKReasonOf(%v) = %v, want %v", err, r, ReasonUnmarshalFailed)
+	}
+	if err := ValidateCluster(good); err == nil {
+		t.Error("ValidateCluster() succeeded for a Listener, want an error")
+	}
+}
@@ -63,6 +63,8 @@ const (
 	V3ClusterURL              = googleapiPrefix + V3ClusterType
 	V3EndpointsURL            = googleapiPrefix + V3EndpointsType
 	V3HTTPConnManagerURL      = googleapiPrefix + "envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager"
+	V3TCPProxyURL             = googleapiPrefix + "envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy"
 	V3UpstreamTLSContextURL   = googleapiPrefix + "envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext"
 	V3DownstreamTLSContextURL = googleapiPrefix + "envoy.extensions.transport_sockets.tls.v3.DownstreamTlsContext"
+	V3FileAccessLogURL        = googleapiPrefix + "envoy.extensions.access_loggers.file.v3.FileAccessLog"
 )
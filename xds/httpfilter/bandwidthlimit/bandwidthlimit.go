@@ -0,0 +1,182 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bandwidthlimit recognizes the Envoy Bandwidth Limit HTTP filter on
+// the server side. The configuration is validated, but the limit is not
+// enforced: the server transport has no hook to throttle the traffic of the
+// RPCs, so the filter does nothing.
+package bandwidthlimit
+
+import (
+	"fmt"
+	"time"
+)
+
+import (
+	pb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/bandwidth_limit/v3"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+import (
+	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
+	iresolver "dubbo.apache.org/dubbo-go/v3/xds/utils/resolver"
+)
+
+// TypeURL is the message type for the Bandwidth Limit configuration.
+const TypeURL = "type.googleapis.com/envoy.extensions.filters.http.bandwidth_limit.v3.BandwidthLimit"
+
+const (
+	defaultFillInterval = 50 * time.Millisecond
+	minFillInterval     = 20 * time.Millisecond
+	maxFillInterval     = time.Second
+)
+
+func init() {
+	httpfilter.Register(builder{})
+}
+
+type builder struct {
+}
+
+// Mode is the traffic direction the limit applies to.
+type Mode int
+
+const (
+	// ModeDisabled doesn't limit any traffic.
+	ModeDisabled Mode = iota
+	// ModeRequest limits the request traffic.
+	ModeRequest
+	// ModeResponse limits the response traffic.
+	ModeResponse
+	// ModeRequestAndResponse limits the request and the response traffic,
+	// each on its own.
+	ModeRequestAndResponse
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeRequest:
+		return "Request"
+	case ModeResponse:
+		return "Response"
+	case ModeRequestAndResponse:
+		return "RequestAndResponse"
+	default:
+		return "Disabled"
+	}
+}
+
+// Config is the parsed configuration of the filter.
+type Config struct {
+	// Mode is the direction of the limited traffic.
+	Mode Mode
+	// LimitKbps is the limit in KiB per second.
+	LimitKbps uint64
+	// FillInterval is the interval at which the limit is replenished.
+	FillInterval time.Duration
+}
+
+type config struct {
+	httpfilter.FilterConfig
+	cfg Config
+}
+
+func (builder) TypeURLs() []string { return []string{TypeURL} }
+
+// Parsing is the same for the base config and the override config.
+func parseConfig(cfg proto.Message) (httpfilter.FilterConfig, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("bandwidthlimit: nil configuration message provided")
+	}
+	any, ok := cfg.(*anypb.Any)
+	if !ok {
+		return nil, fmt.Errorf("bandwidthlimit: error parsing config %v: unknown type %T", cfg, cfg)
+	}
+	msg := new(pb.BandwidthLimit)
+	if err := ptypes.UnmarshalAny(any, msg); err != nil {
+		return nil, fmt.Errorf("bandwidthlimit: error parsing config %v: %v", cfg, err)
+	}
+
+	c := Config{FillInterval: defaultFillInterval}
+	switch msg.GetEnableMode() {
+	case pb.BandwidthLimit_DISABLED:
+		c.Mode = ModeDisabled
+	case pb.BandwidthLimit_REQUEST:
+		c.Mode = ModeRequest
+	case pb.BandwidthLimit_RESPONSE:
+		c.Mode = ModeResponse
+	case pb.BandwidthLimit_REQUEST_AND_RESPONSE:
+		c.Mode = ModeRequestAndResponse
+	default:
+		return nil, fmt.Errorf("bandwidthlimit: unsupported enable_mode %v", msg.GetEnableMode())
+	}
+	if c.LimitKbps = msg.GetLimitKbps().GetValue(); c.LimitKbps == 0 {
+		return nil, fmt.Errorf("bandwidthlimit: limit_kbps must be > 0")
+	}
+	if fi := msg.GetFillInterval(); fi != nil {
+		if err := fi.CheckValid(); err != nil {
+			return nil, fmt.Errorf("bandwidthlimit: invalid fill_interval: %v", err)
+		}
+		c.FillInterval = fi.AsDuration()
+		if c.FillInterval < minFillInterval || c.FillInterval > maxFillInterval {
+			return nil, fmt.Errorf("bandwidthlimit: fill_interval = %v; must be in [%v, %v]", c.FillInterval, minFillInterval, maxFillInterval)
+		}
+	}
+	return config{cfg: c}, nil
+}
+
+func (builder) ParseFilterConfig(cfg proto.Message) (httpfilter.FilterConfig, error) {
+	return parseConfig(cfg)
+}
+
+// ParseFilterConfigOverride parses a per-route BandwidthLimit, which replaces
+// the listener configuration for that route.
+func (builder) ParseFilterConfigOverride(override proto.Message) (httpfilter.FilterConfig, error) {
+	return parseConfig(override)
+}
+
+func (builder) IsTerminal() bool {
+	return false
+}
+
+var _ httpfilter.ServerInterceptorBuilder = builder{}
+
+// BuildServerInterceptor is an optional interface builder implements in order
+// to signify it works server side. The configs are only validated; no
+// interceptor is returned as the limit is not enforced.
+func (builder) BuildServerInterceptor(cfg, override httpfilter.FilterConfig) (iresolver.ServerInterceptor, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("bandwidthlimit: nil config provided")
+	}
+
+	if _, ok := cfg.(config); !ok {
+		return nil, fmt.Errorf("bandwidthlimit: incorrect config type provided (%T): %v", cfg, cfg)
+	}
+
+	if override != nil {
+		// override completely replaces the listener configuration; but we
+		// still validate the listener config type.
+		if _, ok := override.(config); !ok {
+			return nil, fmt.Errorf("bandwidthlimit: incorrect override config type provided (%T): %v", override, override)
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,105 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bandwidthlimit
+
+import (
+	"testing"
+	"time"
+)
+
+import (
+	pb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/bandwidth_limit/v3"
+
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestParseFilterConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *pb.BandwidthLimit
+		want    Config
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			cfg:  &pb.BandwidthLimit{LimitKbps: wrapperspb.UInt64(1024)},
+			want: Config{Mode: ModeDisabled, LimitKbps: 1024, FillInterval: 50 * time.Millisecond},
+		},
+		{
+			name: "request and response",
+			cfg: &pb.BandwidthLimit{
+				EnableMode:   pb.BandwidthLimit_REQUEST_AND_RESPONSE,
+				LimitKbps:    wrapperspb.UInt64(10),
+				FillInterval: durationpb.New(time.Second),
+			},
+			want: Config{Mode: ModeRequestAndResponse, LimitKbps: 10, FillInterval: time.Second},
+		},
+		{
+			name:    "no limit",
+			cfg:     &pb.BandwidthLimit{EnableMode: pb.BandwidthLimit_REQUEST},
+			wantErr: true,
+		},
+		{
+			name:    "fill interval too short",
+			cfg:     &pb.BandwidthLimit{LimitKbps: wrapperspb.UInt64(10), FillInterval: durationpb.New(10 * time.Millisecond)},
+			wantErr: true,
+		},
+		{
+			name:    "fill interval too long",
+			cfg:     &pb.BandwidthLimit{LimitKbps: wrapperspb.UInt64(10), FillInterval: durationpb.New(2 * time.Second)},
+			wantErr: true,
+		},
+		{
+			name:    "invalid fill interval",
+			cfg:     &pb.BandwidthLimit{LimitKbps: wrapperspb.UInt64(10), FillInterval: &durationpb.Duration{Seconds: 1, Nanos: -1}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown mode",
+			cfg:     &pb.BandwidthLimit{EnableMode: 42, LimitKbps: wrapperspb.UInt64(10)},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := ptypes.MarshalAny(test.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := builder{}.ParseFilterConfig(a)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseFilterConfig() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if c := got.(config).cfg; c != test.want {
+				t.Errorf("ParseFilterConfig() = %+v, want %+v", c, test.want)
+			}
+
+			// The limit isn't enforced, so there is never an interceptor.
+			i, err := builder{}.BuildServerInterceptor(got, got)
+			if i != nil || err != nil {
+				t.Errorf("BuildServerInterceptor() = %v, %v, want nil, nil", i, err)
+			}
+		})
+	}
+}
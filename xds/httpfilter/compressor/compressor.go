@@ -0,0 +1,70 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package compressor recognizes the Envoy compressor and decompressor HTTP
+// filters, which some control planes inject into every filter chain. They have
+// no implementation: registering this package makes the filters be skipped
+// instead of rejected, even when they aren't optional.
+//
+// The package isn't registered by default. Users opt in by importing it for
+// its side effects:
+//
+//	import _ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/compressor"
+package compressor
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+import (
+	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
+)
+
+const (
+	// CompressorTypeURL is the message type for the compressor filter
+	// configuration.
+	CompressorTypeURL = "type.googleapis.com/envoy.extensions.filters.http.compressor.v3.Compressor"
+	// CompressorPerRouteTypeURL is the message type for the per-route
+	// override of the compressor filter.
+	CompressorPerRouteTypeURL = "type.googleapis.com/envoy.extensions.filters.http.compressor.v3.CompressorPerRoute"
+	// DecompressorTypeURL is the message type for the decompressor filter
+	// configuration.
+	DecompressorTypeURL = "type.googleapis.com/envoy.extensions.filters.http.decompressor.v3.Decompressor"
+)
+
+func init() {
+	httpfilter.Register(builder{})
+}
+
+type builder struct {
+}
+
+func (builder) TypeURLs() []string {
+	return []string{CompressorTypeURL, CompressorPerRouteTypeURL, DecompressorTypeURL}
+}
+
+func (builder) ParseFilterConfig(proto.Message) (httpfilter.FilterConfig, error) {
+	return nil, httpfilter.ErrSkipFilter
+}
+
+func (builder) ParseFilterConfigOverride(proto.Message) (httpfilter.FilterConfig, error) {
+	return nil, httpfilter.ErrSkipFilter
+}
+
+func (builder) IsTerminal() bool {
+	return false
+}
@@ -0,0 +1,118 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package cors implements the Envoy CORS HTTP filter.
+package cors
+
+import (
+	"fmt"
+)
+
+import (
+	v3routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	pb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/cors/v3"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+import (
+	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
+	iresolver "dubbo.apache.org/dubbo-go/v3/xds/utils/resolver"
+)
+
+const (
+	// TypeURL is the message type for the CORS filter configuration.
+	TypeURL = "type.googleapis.com/envoy.extensions.filters.http.cors.v3.Cors"
+	// PolicyTypeURL is the message type for the per-route CORS policy
+	// carried in typed_per_filter_config.
+	PolicyTypeURL = "type.googleapis.com/envoy.config.route.v3.CorsPolicy"
+)
+
+func init() {
+	httpfilter.Register(builder{})
+}
+
+type builder struct {
+}
+
+type config struct {
+	httpfilter.FilterConfig
+}
+
+func (builder) TypeURLs() []string { return []string{TypeURL, PolicyTypeURL} }
+
+func (builder) ParseFilterConfig(cfg proto.Message) (httpfilter.FilterConfig, error) {
+	// The CORS filter itself carries no configuration; the policies live on
+	// the virtual hosts and routes.  Verify type only.
+	if cfg == nil {
+		return nil, fmt.Errorf("cors: nil configuration message provided")
+	}
+	any, ok := cfg.(*anypb.Any)
+	if !ok {
+		return nil, fmt.Errorf("cors: error parsing config %v: unknown type %T", cfg, cfg)
+	}
+	msg := new(pb.Cors)
+	if err := ptypes.UnmarshalAny(any, msg); err != nil {
+		return nil, fmt.Errorf("cors: error parsing config %v: %v", cfg, err)
+	}
+	return config{}, nil
+}
+
+func (builder) ParseFilterConfigOverride(override proto.Message) (httpfilter.FilterConfig, error) {
+	if override == nil {
+		return nil, fmt.Errorf("cors: nil configuration message provided")
+	}
+	any, ok := override.(*anypb.Any)
+	if !ok {
+		return nil, fmt.Errorf("cors: error parsing override config %v: unknown type %T", override, override)
+	}
+	msg := new(v3routepb.CorsPolicy)
+	if err := ptypes.UnmarshalAny(any, msg); err != nil {
+		return nil, fmt.Errorf("cors: error parsing override config %v: %v", override, err)
+	}
+	return config{}, nil
+}
+
+func (builder) IsTerminal() bool {
+	return false
+}
+
+var (
+	_ httpfilter.ClientInterceptorBuilder = builder{}
+	_ httpfilter.ServerInterceptorBuilder = builder{}
+)
+
+func (builder) BuildClientInterceptor(cfg, override httpfilter.FilterConfig) (iresolver.ClientInterceptor, error) {
+	if _, ok := cfg.(config); !ok {
+		return nil, fmt.Errorf("cors: incorrect config type provided (%T): %v", cfg, cfg)
+	}
+	// CORS is a browser concern and is enforced by the gateway in front of
+	// the client, so there is nothing to intercept here.
+	return nil, nil
+}
+
+func (builder) BuildServerInterceptor(cfg, override httpfilter.FilterConfig) (iresolver.ServerInterceptor, error) {
+	if _, ok := cfg.(config); !ok {
+		return nil, fmt.Errorf("cors: incorrect config type provided (%T): %v", cfg, cfg)
+	}
+	// The policy is exposed on the parsed RouteConfigUpdate; the server does
+	// not act on it directly.
+	return nil, nil
+}
@@ -0,0 +1,272 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package extauthz implements the Envoy ext_authz HTTP filter on the server
+// side.
+package extauthz
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+import (
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	pb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_authz/v3"
+	v3matcherpb "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+import (
+	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
+	iresolver "dubbo.apache.org/dubbo-go/v3/xds/utils/resolver"
+)
+
+const (
+	// TypeURL is the message type for the ext_authz filter configuration.
+	TypeURL = "type.googleapis.com/envoy.extensions.filters.http.ext_authz.v3.ExtAuthz"
+	// PerRouteTypeURL is the message type for the per-route override of the
+	// ext_authz filter.
+	PerRouteTypeURL = "type.googleapis.com/envoy.extensions.filters.http.ext_authz.v3.ExtAuthzPerRoute"
+)
+
+func init() {
+	httpfilter.Register(builder{})
+}
+
+type builder struct {
+}
+
+// Config is the parsed configuration of the filter.
+type Config struct {
+	// Cluster is the cluster of the authorization service.
+	Cluster string
+	// HTTP reports whether the service is an HTTP service; it is a gRPC
+	// service otherwise.
+	HTTP bool
+	// URI is the URI of the HTTP service.
+	URI string
+	// Timeout is the timeout of the check; zero means no timeout.
+	Timeout time.Duration
+	// FailureModeAllow accepts RPCs when the service can't be reached.
+	FailureModeAllow bool
+	// AllowedHeaders are the request headers sent to the service. If empty,
+	// all headers are sent. ExtAuthz.disallowed_headers is newer than the
+	// go-control-plane version this module depends on, so it can't be parsed
+	// and disallowed headers aren't excluded.
+	AllowedHeaders []*v3matcherpb.StringMatcher
+}
+
+// CheckFunc calls the authorization service of cfg for the RPC of ctx. It
+// returns whether the RPC is allowed, or an error if the service couldn't
+// answer.
+type CheckFunc func(ctx context.Context, cfg *Config) (bool, error)
+
+var checkFunc CheckFunc
+
+// SetCheckFunc sets the function which calls the authorization service.
+// Without it every check fails.
+//
+// NOTE: this function must only be called during initialization time (i.e. in
+// an init() function), and is not thread-safe.
+func SetCheckFunc(f CheckFunc) {
+	checkFunc = f
+}
+
+type config struct {
+	httpfilter.FilterConfig
+	// cfg is nil for per-route overrides.
+	cfg *Config
+	// disabled is set by a per-route override disabling the filter.
+	disabled bool
+}
+
+func (builder) TypeURLs() []string { return []string{TypeURL, PerRouteTypeURL} }
+
+func parseTimeout(d *durationpb.Duration) (time.Duration, error) {
+	if d == nil {
+		return 0, nil
+	}
+	if err := d.CheckValid(); err != nil {
+		return 0, err
+	}
+	if d.AsDuration() < 0 {
+		return 0, fmt.Errorf("negative timeout %v", d.AsDuration())
+	}
+	return d.AsDuration(), nil
+}
+
+func parseGRPCService(s *v3corepb.GrpcService) (*Config, error) {
+	cluster := s.GetEnvoyGrpc().GetClusterName()
+	if cluster == "" {
+		return nil, fmt.Errorf("grpc_service %v doesn't name a cluster in envoy_grpc", s)
+	}
+	timeout, err := parseTimeout(s.GetTimeout())
+	if err != nil {
+		return nil, fmt.Errorf("grpc_service %v: %v", s, err)
+	}
+	return &Config{Cluster: cluster, Timeout: timeout}, nil
+}
+
+func parseHTTPService(s *pb.HttpService) (*Config, error) {
+	uri := s.GetServerUri()
+	if uri.GetCluster() == "" {
+		return nil, fmt.Errorf("http_service %v doesn't name a cluster", s)
+	}
+	timeout, err := parseTimeout(uri.GetTimeout())
+	if err != nil {
+		return nil, fmt.Errorf("http_service %v: %v", s, err)
+	}
+	return &Config{
+		Cluster:        uri.GetCluster(),
+		HTTP:           true,
+		URI:            uri.GetUri(),
+		Timeout:        timeout,
+		AllowedHeaders: s.GetAuthorizationRequest().GetAllowedHeaders().GetPatterns(),
+	}, nil
+}
+
+func parseConfig(msg *pb.ExtAuthz) (*Config, error) {
+	var (
+		c   *Config
+		err error
+	)
+	switch s := msg.GetServices().(type) {
+	case *pb.ExtAuthz_GrpcService:
+		c, err = parseGRPCService(s.GrpcService)
+	case *pb.ExtAuthz_HttpService:
+		c, err = parseHTTPService(s.HttpService)
+	default:
+		return nil, fmt.Errorf("neither grpc_service nor http_service is configured")
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.FailureModeAllow = msg.GetFailureModeAllow()
+	if allowed := msg.GetAllowedHeaders().GetPatterns(); len(allowed) != 0 {
+		c.AllowedHeaders = allowed
+	}
+	return c, nil
+}
+
+func (builder) ParseFilterConfig(cfg proto.Message) (httpfilter.FilterConfig, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("ext_authz: nil configuration message provided")
+	}
+	any, ok := cfg.(*anypb.Any)
+	if !ok {
+		return nil, fmt.Errorf("ext_authz: error parsing config %v: unknown type %T", cfg, cfg)
+	}
+	msg := new(pb.ExtAuthz)
+	if err := ptypes.UnmarshalAny(any, msg); err != nil {
+		return nil, fmt.Errorf("ext_authz: error parsing config %v: %v", cfg, err)
+	}
+	c, err := parseConfig(msg)
+	if err != nil {
+		return nil, fmt.Errorf("ext_authz: %v", err)
+	}
+	return config{cfg: c}, nil
+}
+
+func (builder) ParseFilterConfigOverride(override proto.Message) (httpfilter.FilterConfig, error) {
+	if override == nil {
+		return nil, fmt.Errorf("ext_authz: nil configuration message provided")
+	}
+	any, ok := override.(*anypb.Any)
+	if !ok {
+		return nil, fmt.Errorf("ext_authz: error parsing override config %v: unknown type %T", override, override)
+	}
+	msg := new(pb.ExtAuthzPerRoute)
+	if err := ptypes.UnmarshalAny(any, msg); err != nil {
+		return nil, fmt.Errorf("ext_authz: error parsing override config %v: %v", override, err)
+	}
+	switch msg.GetOverride().(type) {
+	case *pb.ExtAuthzPerRoute_Disabled:
+		return config{disabled: msg.GetDisabled()}, nil
+	case *pb.ExtAuthzPerRoute_CheckSettings:
+		// The check settings only add context extensions and body
+		// buffering options to the check request, which CheckFunc doesn't
+		// take, so the route keeps the filter's config.
+		return config{}, nil
+	default:
+		return nil, fmt.Errorf("ext_authz: override config %v sets neither disabled nor check_settings", override)
+	}
+}
+
+func (builder) IsTerminal() bool {
+	return false
+}
+
+var _ httpfilter.ServerInterceptorBuilder = builder{}
+
+func (builder) BuildServerInterceptor(cfg, override httpfilter.FilterConfig) (iresolver.ServerInterceptor, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("ext_authz: nil config provided")
+	}
+	c, ok := cfg.(config)
+	if !ok {
+		return nil, fmt.Errorf("ext_authz: incorrect config type provided (%T): %v", cfg, cfg)
+	}
+	if override != nil {
+		o, ok := override.(config)
+		if !ok {
+			return nil, fmt.Errorf("ext_authz: incorrect override config type provided (%T): %v", override, override)
+		}
+		if o.disabled {
+			return nil, nil
+		}
+	}
+	return &interceptor{cfg: c.cfg}, nil
+}
+
+type interceptor struct {
+	cfg *Config
+}
+
+func (i *interceptor) AllowRPC(ctx context.Context) error {
+	if checkFunc == nil {
+		if i.cfg.FailureModeAllow {
+			return nil
+		}
+		return status.Errorf(codes.Unavailable, "ext_authz: no authorization client configured")
+	}
+	if i.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.cfg.Timeout)
+		defer cancel()
+	}
+	allowed, err := checkFunc(ctx, i.cfg)
+	if err != nil {
+		if i.cfg.FailureModeAllow {
+			return nil
+		}
+		return status.Errorf(codes.Unavailable, "ext_authz: authorization check failed: %v", err)
+	}
+	if !allowed {
+		return status.Errorf(codes.PermissionDenied, "ext_authz: RPC denied by the authorization service")
+	}
+	return nil
+}
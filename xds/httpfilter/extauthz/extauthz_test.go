@@ -0,0 +1,209 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package extauthz
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+import (
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	pb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_authz/v3"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+import (
+	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
+)
+
+func marshalAny(t *testing.T, m proto.Message) proto.Message {
+	t.Helper()
+	a, err := ptypes.MarshalAny(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func grpcServiceConfig(t *testing.T, failureModeAllow bool) httpfilter.FilterConfig {
+	t.Helper()
+	cfg, err := builder{}.ParseFilterConfig(marshalAny(t, &pb.ExtAuthz{
+		Services: &pb.ExtAuthz_GrpcService{GrpcService: &v3corepb.GrpcService{
+			TargetSpecifier: &v3corepb.GrpcService_EnvoyGrpc_{EnvoyGrpc: &v3corepb.GrpcService_EnvoyGrpc{ClusterName: "authz"}},
+		}},
+		FailureModeAllow: failureModeAllow,
+	}))
+	if err != nil {
+		t.Fatalf("ParseFilterConfig() failed: %v", err)
+	}
+	return cfg
+}
+
+func TestParseFilterConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *pb.ExtAuthz
+		want    Config
+		wantErr bool
+	}{
+		{
+			name: "grpc service",
+			cfg: &pb.ExtAuthz{Services: &pb.ExtAuthz_GrpcService{GrpcService: &v3corepb.GrpcService{
+				TargetSpecifier: &v3corepb.GrpcService_EnvoyGrpc_{EnvoyGrpc: &v3corepb.GrpcService_EnvoyGrpc{ClusterName: "authz"}},
+			}}},
+			want: Config{Cluster: "authz"},
+		},
+		{
+			name: "http service",
+			cfg: &pb.ExtAuthz{Services: &pb.ExtAuthz_HttpService{HttpService: &pb.HttpService{
+				ServerUri: &v3corepb.HttpUri{
+					Uri:              "http://authz/check",
+					HttpUpstreamType: &v3corepb.HttpUri_Cluster{Cluster: "authz"},
+				},
+			}}},
+			want: Config{Cluster: "authz", HTTP: true, URI: "http://authz/check"},
+		},
+		{
+			name: "grpc service without cluster",
+			cfg: &pb.ExtAuthz{Services: &pb.ExtAuthz_GrpcService{GrpcService: &v3corepb.GrpcService{
+				TargetSpecifier: &v3corepb.GrpcService_EnvoyGrpc_{EnvoyGrpc: &v3corepb.GrpcService_EnvoyGrpc{}},
+			}}},
+			wantErr: true,
+		},
+		{
+			name:    "no service",
+			cfg:     &pb.ExtAuthz{},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := builder{}.ParseFilterConfig(marshalAny(t, test.cfg))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseFilterConfig() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			c := got.(config).cfg
+			if c.Cluster != test.want.Cluster || c.HTTP != test.want.HTTP || c.URI != test.want.URI {
+				t.Errorf("ParseFilterConfig() = %+v, want %+v", c, test.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterConfigOverride(t *testing.T) {
+	cfg := grpcServiceConfig(t, false)
+	tests := []struct {
+		name            string
+		override        *pb.ExtAuthzPerRoute
+		wantInterceptor bool
+		wantErr         bool
+	}{
+		{
+			name:     "disabled",
+			override: &pb.ExtAuthzPerRoute{Override: &pb.ExtAuthzPerRoute_Disabled{Disabled: true}},
+		},
+		{
+			name: "check settings",
+			override: &pb.ExtAuthzPerRoute{Override: &pb.ExtAuthzPerRoute_CheckSettings{CheckSettings: &pb.CheckSettings{
+				ContextExtensions: map[string]string{"key": "value"},
+			}}},
+			wantInterceptor: true,
+		},
+		{
+			name:     "no override",
+			override: &pb.ExtAuthzPerRoute{},
+			wantErr:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			override, err := builder{}.ParseFilterConfigOverride(marshalAny(t, test.override))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseFilterConfigOverride() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			i, err := builder{}.BuildServerInterceptor(cfg, override)
+			if err != nil {
+				t.Fatalf("BuildServerInterceptor() failed: %v", err)
+			}
+			if (i != nil) != test.wantInterceptor {
+				t.Errorf("BuildServerInterceptor() = %v, want an interceptor: %v", i, test.wantInterceptor)
+			}
+		})
+	}
+}
+
+func TestAllowRPC(t *testing.T) {
+	defer SetCheckFunc(nil)
+	tests := []struct {
+		name             string
+		check            CheckFunc
+		failureModeAllow bool
+		wantCode         codes.Code
+	}{
+		{
+			name:     "allowed",
+			check:    func(context.Context, *Config) (bool, error) { return true, nil },
+			wantCode: codes.OK,
+		},
+		{
+			name:     "denied",
+			check:    func(context.Context, *Config) (bool, error) { return false, nil },
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "check failed",
+			check:    func(context.Context, *Config) (bool, error) { return false, errors.New("unreachable") },
+			wantCode: codes.Unavailable,
+		},
+		{
+			name:             "check failed with failure mode allow",
+			check:            func(context.Context, *Config) (bool, error) { return false, errors.New("unreachable") },
+			failureModeAllow: true,
+			wantCode:         codes.OK,
+		},
+		{
+			name:     "no check function",
+			wantCode: codes.Unavailable,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SetCheckFunc(test.check)
+			i, err := builder{}.BuildServerInterceptor(grpcServiceConfig(t, test.failureModeAllow), nil)
+			if err != nil {
+				t.Fatalf("BuildServerInterceptor() failed: %v", err)
+			}
+			if got := status.Code(i.AllowRPC(context.Background())); got != test.wantCode {
+				t.Errorf("AllowRPC() returned code %v, want %v", got, test.wantCode)
+			}
+		})
+	}
+}
@@ -57,6 +57,9 @@ import (
 	iresolver "dubbo.apache.org/dubbo-go/v3/xds/utils/resolver"
 )
 
+// TypeURL is the message type for the Fault Injection configuration.
+const TypeURL = "type.googleapis.com/envoy.extensions.filters.http.fault.v3.HTTPFault"
+
 const headerAbortHTTPStatus = "x-envoy-fault-abort-request"
 const headerAbortGRPCStatus = "x-envoy-fault-abort-grpc-request"
 const headerAbortPercentage = "x-envoy-fault-abort-request-percentage"
@@ -88,7 +91,7 @@ type config struct {
 }
 
 func (builder) TypeURLs() []string {
-	return []string{"type.googleapis.com/envoy.extensions.filters.http.fault.v3.HTTPFault"}
+	return []string{TypeURL}
 }
 
 // Parsing is the same for the base config and the override config.
@@ -104,6 +107,9 @@ func parseConfig(cfg proto.Message) (httpfilter.FilterConfig, error) {
 	if err := ptypes.UnmarshalAny(any, msg); err != nil {
 		return nil, fmt.Errorf("fault: error parsing config %v: %v", cfg, err)
 	}
+	if d := msg.GetDelay().GetFixedDelay(); d != nil && d.AsDuration() < 0 {
+		return nil, fmt.Errorf("fault: delay.fixed_delay = %v; must be >= 0", d.AsDuration())
+	}
 	return config{config: msg}, nil
 }
 
@@ -115,6 +121,7 @@ func (builder) ParseFilterConfigOverride(override proto.Message) (httpfilter.Fil
 	return parseConfig(override)
 }
 
+// IsTerminal returns false: fault injection runs ahead of the router filter.
 func (builder) IsTerminal() bool {
 	return false
 }
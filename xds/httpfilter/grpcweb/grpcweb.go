@@ -0,0 +1,89 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package grpcweb recognizes the Envoy grpc_web HTTP filter. Browsers reach
+// dubbo-go through a gRPC-Web bridge in front of it, and dubbo-go's native
+// transport has nothing to translate, so the filter is accepted on the server
+// side and does nothing.
+package grpcweb
+
+import (
+	"fmt"
+)
+
+import (
+	pb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/grpc_web/v3"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+import (
+	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
+	iresolver "dubbo.apache.org/dubbo-go/v3/xds/utils/resolver"
+)
+
+// TypeURL is the message type for the grpc_web filter configuration.
+const TypeURL = "type.googleapis.com/envoy.extensions.filters.http.grpc_web.v3.GrpcWeb"
+
+func init() {
+	httpfilter.Register(builder{})
+}
+
+type builder struct {
+}
+
+type config struct {
+	httpfilter.FilterConfig
+}
+
+func (builder) TypeURLs() []string { return []string{TypeURL} }
+
+func (builder) ParseFilterConfig(cfg proto.Message) (httpfilter.FilterConfig, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("grpc_web: nil configuration message provided")
+	}
+	any, ok := cfg.(*anypb.Any)
+	if !ok {
+		return nil, fmt.Errorf("grpc_web: error parsing config %v: unknown type %T", cfg, cfg)
+	}
+	// The config has no fields, it is only unmarshaled to verify its type.
+	if err := ptypes.UnmarshalAny(any, new(pb.GrpcWeb)); err != nil {
+		return nil, fmt.Errorf("grpc_web: error parsing config %v: %v", cfg, err)
+	}
+	return config{}, nil
+}
+
+func (builder) ParseFilterConfigOverride(override proto.Message) (httpfilter.FilterConfig, error) {
+	return nil, fmt.Errorf("grpc_web: per-route overrides are not supported: %v", override)
+}
+
+// IsTerminal returns false, the filter must come before the terminal router.
+func (builder) IsTerminal() bool {
+	return false
+}
+
+var _ httpfilter.ServerInterceptorBuilder = builder{}
+
+func (builder) BuildServerInterceptor(cfg, override httpfilter.FilterConfig) (iresolver.ServerInterceptor, error) {
+	if _, ok := cfg.(config); !ok {
+		return nil, fmt.Errorf("grpc_web: incorrect config type provided (%T): %v", cfg, cfg)
+	}
+	return nil, nil
+}
@@ -0,0 +1,226 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package headertometadata implements the Envoy header_to_metadata HTTP
+// filter.
+package headertometadata
+
+import (
+	"fmt"
+)
+
+import (
+	pb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/header_to_metadata/v3"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+import (
+	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
+	iresolver "dubbo.apache.org/dubbo-go/v3/xds/utils/resolver"
+)
+
+// TypeURL is the message type for the header_to_metadata filter
+// configuration. The same message is used for per-route overrides.
+const TypeURL = "type.googleapis.com/envoy.extensions.filters.http.header_to_metadata.v3.Config"
+
+func init() {
+	httpfilter.Register(builder{})
+}
+
+type builder struct {
+}
+
+// ValueType is the type a header value is stored as in the metadata.
+type ValueType int
+
+const (
+	ValueTypeString ValueType = iota
+	ValueTypeNumber
+	ValueTypeProtobufValue
+)
+
+// KeyValuePair is the metadata entry a rule writes.
+type KeyValuePair struct {
+	// Namespace is the metadata namespace; empty means the filter's name.
+	Namespace string
+	Key       string
+	// Value is the value to write; empty means the value of the header.
+	Value string
+	Type  ValueType
+	// Base64 reports whether the header value is base64 encoded.
+	Base64 bool
+}
+
+// Rule maps a header, or a cookie, to metadata.
+type Rule struct {
+	Header string
+	Cookie string
+	// OnPresent is written if the header is present, OnMissing if it isn't.
+	// At least one of them is set.
+	OnPresent *KeyValuePair
+	OnMissing *KeyValuePair
+	// Remove reports whether the header is removed after it is read.
+	Remove bool
+}
+
+type config struct {
+	httpfilter.FilterConfig
+	requestRules  []Rule
+	responseRules []Rule
+}
+
+func (builder) TypeURLs() []string { return []string{TypeURL} }
+
+func parseKeyValuePair(kv *pb.Config_KeyValuePair) (*KeyValuePair, error) {
+	if kv == nil {
+		return nil, nil
+	}
+	if kv.GetKey() == "" {
+		return nil, fmt.Errorf("empty metadata key")
+	}
+	if kv.GetRegexValueRewrite() != nil {
+		return nil, fmt.Errorf("regex_value_rewrite is not supported")
+	}
+	ret := &KeyValuePair{
+		Namespace: kv.GetMetadataNamespace(),
+		Key:       kv.GetKey(),
+		Value:     kv.GetValue(),
+		Base64:    kv.GetEncode() == pb.Config_BASE64,
+	}
+	switch kv.GetType() {
+	case pb.Config_STRING:
+		ret.Type = ValueTypeString
+	case pb.Config_NUMBER:
+		ret.Type = ValueTypeNumber
+	case pb.Config_PROTOBUF_VALUE:
+		ret.Type = ValueTypeProtobufValue
+	default:
+		return nil, fmt.Errorf("unsupported value type %v", kv.GetType())
+	}
+	return ret, nil
+}
+
+func parseRules(rules []*pb.Config_Rule) ([]Rule, error) {
+	ret := make([]Rule, 0, len(rules))
+	for i, r := range rules {
+		if (r.GetHeader() == "") == (r.GetCookie() == "") {
+			return nil, fmt.Errorf("rule %d must set exactly one of header and cookie", i)
+		}
+		if r.GetOnHeaderPresent() == nil && r.GetOnHeaderMissing() == nil {
+			return nil, fmt.Errorf("rule %d sets neither on_header_present nor on_header_missing", i)
+		}
+		onPresent, err := parseKeyValuePair(r.GetOnHeaderPresent())
+		if err != nil {
+			return nil, fmt.Errorf("rule %d on_header_present: %v", i, err)
+		}
+		onMissing, err := parseKeyValuePair(r.GetOnHeaderMissing())
+		if err != nil {
+			return nil, fmt.Errorf("rule %d on_header_missing: %v", i, err)
+		}
+		if onMissing != nil && onMissing.Value == "" {
+			return nil, fmt.Errorf("rule %d on_header_missing has an empty value", i)
+		}
+		ret = append(ret, Rule{
+			Header:    r.GetHeader(),
+			Cookie:    r.GetCookie(),
+			OnPresent: onPresent,
+			OnMissing: onMissing,
+			Remove:    r.GetRemove(),
+		})
+	}
+	return ret, nil
+}
+
+func parseConfig(cfg proto.Message) (httpfilter.FilterConfig, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("header_to_metadata: nil configuration message provided")
+	}
+	any, ok := cfg.(*anypb.Any)
+	if !ok {
+		return nil, fmt.Errorf("header_to_metadata: error parsing config %v: unknown type %T", cfg, cfg)
+	}
+	msg := new(pb.Config)
+	if err := ptypes.UnmarshalAny(any, msg); err != nil {
+		return nil, fmt.Errorf("header_to_metadata: error parsing config %v: %v", cfg, err)
+	}
+	requestRules, err := parseRules(msg.GetRequestRules())
+	if err != nil {
+		return nil, fmt.Errorf("header_to_metadata: request_rules: %v", err)
+	}
+	responseRules, err := parseRules(msg.GetResponseRules())
+	if err != nil {
+		return nil, fmt.Errorf("header_to_metadata: response_rules: %v", err)
+	}
+	return config{requestRules: requestRules, responseRules: responseRules}, nil
+}
+
+func (builder) ParseFilterConfig(cfg proto.Message) (httpfilter.FilterConfig, error) {
+	return parseConfig(cfg)
+}
+
+func (builder) ParseFilterConfigOverride(override proto.Message) (httpfilter.FilterConfig, error) {
+	return parseConfig(override)
+}
+
+func (builder) IsTerminal() bool {
+	return false
+}
+
+// Rules returns the request and response rules of cfg, a config parsed by
+// this filter.
+func Rules(cfg httpfilter.FilterConfig) (request, response []Rule, ok bool) {
+	c, ok := cfg.(config)
+	if !ok {
+		return nil, nil, false
+	}
+	return c.requestRules, c.responseRules, true
+}
+
+var (
+	_ httpfilter.ClientInterceptorBuilder = builder{}
+	_ httpfilter.ServerInterceptorBuilder = builder{}
+)
+
+func (builder) BuildClientInterceptor(cfg, override httpfilter.FilterConfig) (iresolver.ClientInterceptor, error) {
+	if _, ok := cfg.(config); !ok {
+		return nil, fmt.Errorf("header_to_metadata: incorrect config type provided (%T): %v", cfg, cfg)
+	}
+	if override != nil {
+		if _, ok := override.(config); !ok {
+			return nil, fmt.Errorf("header_to_metadata: incorrect override config type provided (%T): %v", override, override)
+		}
+	}
+	// There is no dynamic metadata on the RPC path; the rules are exposed
+	// through Rules for the components which route on them.
+	return nil, nil
+}
+
+func (builder) BuildServerInterceptor(cfg, override httpfilter.FilterConfig) (iresolver.ServerInterceptor, error) {
+	if _, ok := cfg.(config); !ok {
+		return nil, fmt.Errorf("header_to_metadata: incorrect config type provided (%T): %v", cfg, cfg)
+	}
+	if override != nil {
+		if _, ok := override.(config); !ok {
+			return nil, fmt.Errorf("header_to_metadata: incorrect override config type provided (%T): %v", override, override)
+		}
+	}
+	return nil, nil
+}
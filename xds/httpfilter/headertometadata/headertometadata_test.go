@@ -0,0 +1,179 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package headertometadata
+
+import (
+	"reflect"
+	"testing"
+)
+
+import (
+	pb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/header_to_metadata/v3"
+	v3matcherpb "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+)
+
+import (
+	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
+)
+
+func TestParseFilterConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          *pb.Config
+		wantRequest  []Rule
+		wantResponse []Rule
+		wantErr      bool
+	}{
+		{
+			name: "no rules",
+			cfg:  &pb.Config{},
+		},
+		{
+			name: "header and cookie rules",
+			cfg: &pb.Config{
+				RequestRules: []*pb.Config_Rule{
+					{
+						Header: "x-version",
+						OnHeaderPresent: &pb.Config_KeyValuePair{
+							MetadataNamespace: "envoy.lb",
+							Key:               "version",
+							Type:              pb.Config_NUMBER,
+						},
+						Remove: true,
+					},
+					{
+						Cookie: "session",
+						OnHeaderMissing: &pb.Config_KeyValuePair{
+							Key:    "session",
+							Value:  "none",
+							Encode: pb.Config_BASE64,
+						},
+					},
+				},
+				ResponseRules: []*pb.Config_Rule{{
+					Header:          "x-backend",
+					OnHeaderPresent: &pb.Config_KeyValuePair{Key: "backend", Type: pb.Config_PROTOBUF_VALUE},
+				}},
+			},
+			wantRequest: []Rule{
+				{
+					Header:    "x-version",
+					OnPresent: &KeyValuePair{Namespace: "envoy.lb", Key: "version", Type: ValueTypeNumber},
+					Remove:    true,
+				},
+				{
+					Cookie:    "session",
+					OnMissing: &KeyValuePair{Key: "session", Value: "none", Base64: true},
+				},
+			},
+			wantResponse: []Rule{{
+				Header:    "x-backend",
+				OnPresent: &KeyValuePair{Key: "backend", Type: ValueTypeProtobufValue},
+			}},
+		},
+		{
+			name: "header and cookie",
+			cfg: &pb.Config{RequestRules: []*pb.Config_Rule{{
+				Header:          "x-version",
+				Cookie:          "version",
+				OnHeaderPresent: &pb.Config_KeyValuePair{Key: "version"},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "neither on present nor on missing",
+			cfg: &pb.Config{RequestRules: []*pb.Config_Rule{{
+				Header: "x-version",
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "empty key",
+			cfg: &pb.Config{RequestRules: []*pb.Config_Rule{{
+				Header:          "x-version",
+				OnHeaderPresent: &pb.Config_KeyValuePair{},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "on missing without value",
+			cfg: &pb.Config{RequestRules: []*pb.Config_Rule{{
+				Header:          "x-version",
+				OnHeaderMissing: &pb.Config_KeyValuePair{Key: "version"},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "regex value rewrite",
+			cfg: &pb.Config{RequestRules: []*pb.Config_Rule{{
+				Header: "x-version",
+				OnHeaderPresent: &pb.Config_KeyValuePair{
+					Key:               "version",
+					RegexValueRewrite: &v3matcherpb.RegexMatchAndSubstitute{Pattern: &v3matcherpb.RegexMatcher{Regex: "^v"}},
+				},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "invalid response rule",
+			cfg: &pb.Config{ResponseRules: []*pb.Config_Rule{{
+				OnHeaderPresent: &pb.Config_KeyValuePair{Key: "version"},
+			}}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := ptypes.MarshalAny(test.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Overrides are parsed the same way as the listener configs.
+			for _, parse := range []func(cfg proto.Message) (httpfilter.FilterConfig, error){builder{}.ParseFilterConfig, builder{}.ParseFilterConfigOverride} {
+				cfg, err := parse(a)
+				if (err != nil) != test.wantErr {
+					t.Fatalf("parsing %v returned err %v, wantErr %v", test.cfg, err, test.wantErr)
+				}
+				if err != nil {
+					continue
+				}
+				request, response, ok := Rules(cfg)
+				if !ok {
+					t.Fatalf("Rules(%v) failed", cfg)
+				}
+				if !equalRules(request, test.wantRequest) {
+					t.Errorf("request rules = %+v, want %+v", request, test.wantRequest)
+				}
+				if !equalRules(response, test.wantResponse) {
+					t.Errorf("response rules = %+v, want %+v", response, test.wantResponse)
+				}
+			}
+		})
+	}
+}
+
+// equalRules is reflect.DeepEqual, except that nil and empty are equal.
+func equalRules(a, b []Rule) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -26,44 +26,86 @@
 package httpfilter
 
 import (
+	"errors"
+)
+
+import (
+	v3cncftypepb "github.com/cncf/xds/go/xds/type/v3"
+
 	"github.com/golang/protobuf/proto"
+
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 import (
 	iresolver "dubbo.apache.org/dubbo-go/v3/xds/utils/resolver"
 )
 
+// ErrUnsupportedExtension is returned, possibly wrapped, by
+// ParseFilterConfig and ParseFilterConfigOverride when a config refers to an
+// extension the filter doesn't implement. A filter marked optional is then
+// ignored instead of rejected, as it is when the filter itself is unknown.
+var ErrUnsupportedExtension = errors.New("unsupported extension")
+
+// ErrSkipFilter is returned by ParseFilterConfig and
+// ParseFilterConfigOverride of filters which are recognized but have no
+// implementation. The filter is then dropped from the filter chain, whether it
+// is optional or not, instead of failing the resource.
+var ErrSkipFilter = errors.New("filter is recognized but not implemented")
+
 // FilterConfig represents an opaque data structure holding configuration for a
 // filter.  Embed this interface to implement it.
 type FilterConfig interface {
 	isFilterConfig()
 }
 
+// ConfigEqualer may be implemented by a FilterConfig to compare it with
+// another config of the same filter. Configs which don't implement it are
+// compared by their serialized form.
+type ConfigEqualer interface {
+	// Equal reports whether the config is equivalent to other.
+	Equal(other FilterConfig) bool
+}
+
 // Filter defines the parsing functionality of an HTTP filter.  A Filter may
 // optionally implement either ClientInterceptorBuilder or
 // ServerInterceptorBuilder or both, indicating it is capable of working on the
 // client side or server side or both, respectively.
+//
+// A config encoded in a TypedStruct, of either the xds.type.v3 or the old
+// udpa.type.v1 version, is always passed to the parse methods as an
+// xds.type.v3.TypedStruct, so filters need not handle the old version. Use
+// TypedStructValue to read it.
 type Filter interface {
 	// TypeURLs are the proto message types supported by this filter.  A filter
 	// will be registered by each of its supported message types.
 	TypeURLs() []string
 	// ParseFilterConfig parses the provided configuration proto.Message from
-	// the LDS configuration of this filter.  This may be an anypb.Any, a
-	// udpa.type.v1.TypedStruct, or an xds.type.v3.TypedStruct for filters that
-	// do not accept a custom type. The resulting FilterConfig will later be
-	// passed to Build.
+	// the LDS configuration of this filter.  This may be an anypb.Any, or an
+	// xds.type.v3.TypedStruct for filters that do not accept a custom type.
+	// The resulting FilterConfig will later be passed to Build.
 	ParseFilterConfig(proto.Message) (FilterConfig, error)
 	// ParseFilterConfigOverride parses the provided override configuration
 	// proto.Message from the RDS override configuration of this filter.  This
-	// may be an anypb.Any, a udpa.type.v1.TypedStruct, or an
-	// xds.type.v3.TypedStruct for filters that do not accept a custom type.
-	// The resulting FilterConfig will later be passed to Build.
+	// may be an anypb.Any, or an xds.type.v3.TypedStruct for filters that do
+	// not accept a custom type. The resulting FilterConfig will later be
+	// passed to Build.
 	ParseFilterConfigOverride(proto.Message) (FilterConfig, error)
 	// IsTerminal returns whether this Filter is terminal or not (i.e. it must
 	// be last filter in the filter chain).
 	IsTerminal() bool
 }
 
+// TypedStructValue returns the type URL and the fields of cfg if it is a
+// config encoded in a TypedStruct, as passed to the parse methods of Filter.
+func TypedStructValue(cfg proto.Message) (string, *structpb.Struct, bool) {
+	ts, ok := cfg.(*v3cncftypepb.TypedStruct)
+	if !ok {
+		return "", nil, false
+	}
+	return ts.GetTypeUrl(), ts.GetValue(), true
+}
+
 // ClientInterceptorBuilder constructs a Client Interceptor.  If this type is
 // implemented by a Filter, it is capable of working on a client.
 type ClientInterceptorBuilder interface {
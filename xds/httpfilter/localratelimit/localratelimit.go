@@ -0,0 +1,226 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package localratelimit implements the Envoy Local Rate Limit HTTP filter.
+package localratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+import (
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	pb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/local_ratelimit/v3"
+	v3typepb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+import (
+	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
+	"dubbo.apache.org/dubbo-go/v3/xds/utils/grpcrand"
+	iresolver "dubbo.apache.org/dubbo-go/v3/xds/utils/resolver"
+)
+
+// TypeURL is the message type for the Local Rate Limit configuration.
+const TypeURL = "type.googleapis.com/envoy.extensions.filters.http.local_ratelimit.v3.LocalRateLimit"
+
+func init() {
+	httpfilter.Register(builder{})
+}
+
+type builder struct {
+}
+
+// config is the parsed token bucket. A nil bucket means the filter applies
+// no limit. The bucket is created when the config is parsed and shared by the
+// interceptors built from it, so the limit holds across connections.
+type config struct {
+	httpfilter.FilterConfig
+	bucket *tokenBucket
+}
+
+type tokenBucket struct {
+	maxTokens     uint32
+	tokensPerFill uint32
+	fillInterval  time.Duration
+	// Percentages of requests the limit is evaluated for and enforced on,
+	// as numerator/denominator pairs.
+	enabledNum, enabledDen   int
+	enforcedNum, enforcedDen int
+
+	mu       sync.Mutex
+	tokens   uint32
+	lastFill time.Time
+}
+
+func (builder) TypeURLs() []string { return []string{TypeURL} }
+
+// Parsing is the same for the base config and the override config.
+func parseConfig(cfg proto.Message) (httpfilter.FilterConfig, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("localratelimit: nil configuration message provided")
+	}
+	any, ok := cfg.(*anypb.Any)
+	if !ok {
+		return nil, fmt.Errorf("localratelimit: error parsing config %v: unknown type %T", cfg, cfg)
+	}
+	msg := new(pb.LocalRateLimit)
+	if err := ptypes.UnmarshalAny(any, msg); err != nil {
+		return nil, fmt.Errorf("localratelimit: error parsing config %v: %v", cfg, err)
+	}
+
+	tb := msg.GetTokenBucket()
+	if tb == nil {
+		// Without a token bucket there is nothing to enforce.
+		return config{}, nil
+	}
+	bc := &tokenBucket{
+		maxTokens:     tb.GetMaxTokens(),
+		tokensPerFill: 1,
+	}
+	if bc.maxTokens == 0 {
+		return nil, fmt.Errorf("localratelimit: token_bucket.max_tokens must be > 0")
+	}
+	if tpf := tb.GetTokensPerFill(); tpf != nil {
+		bc.tokensPerFill = tpf.GetValue()
+		if bc.tokensPerFill == 0 {
+			return nil, fmt.Errorf("localratelimit: token_bucket.tokens_per_fill must be > 0")
+		}
+	}
+	bc.fillInterval = tb.GetFillInterval().AsDuration()
+	if bc.fillInterval <= 0 {
+		return nil, fmt.Errorf("localratelimit: token_bucket.fill_interval = %v; must be > 0", bc.fillInterval)
+	}
+	// "If not present, the filter will be disabled." - for both
+	// filter_enabled and filter_enforced.
+	bc.enabledNum, bc.enabledDen = runtimePct(msg.GetFilterEnabled())
+	bc.enforcedNum, bc.enforcedDen = runtimePct(msg.GetFilterEnforced())
+	bc.tokens, bc.lastFill = bc.maxTokens, timeNow()
+	return config{bucket: bc}, nil
+}
+
+func runtimePct(rfp *v3corepb.RuntimeFractionalPercent) (num int, den int) {
+	fp := rfp.GetDefaultValue()
+	if fp == nil {
+		return 0, 100
+	}
+	num = int(fp.GetNumerator())
+	switch fp.GetDenominator() {
+	case v3typepb.FractionalPercent_TEN_THOUSAND:
+		return num, 10 * 1000
+	case v3typepb.FractionalPercent_MILLION:
+		return num, 1000 * 1000
+	}
+	return num, 100
+}
+
+func (builder) ParseFilterConfig(cfg proto.Message) (httpfilter.FilterConfig, error) {
+	return parseConfig(cfg)
+}
+
+// ParseFilterConfigOverride parses a per-route LocalRateLimit, which replaces
+// the bucket parameters of the listener configuration for that route.
+func (builder) ParseFilterConfigOverride(override proto.Message) (httpfilter.FilterConfig, error) {
+	return parseConfig(override)
+}
+
+func (builder) IsTerminal() bool {
+	return false
+}
+
+var _ httpfilter.ServerInterceptorBuilder = builder{}
+
+// BuildServerInterceptor is an optional interface builder implements in order
+// to signify it works server side.
+func (builder) BuildServerInterceptor(cfg, override httpfilter.FilterConfig) (iresolver.ServerInterceptor, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("localratelimit: nil config provided")
+	}
+
+	c, ok := cfg.(config)
+	if !ok {
+		return nil, fmt.Errorf("localratelimit: incorrect config type provided (%T): %v", cfg, cfg)
+	}
+
+	if override != nil {
+		// override completely replaces the listener configuration; but we
+		// still validate the listener config type.
+		c, ok = override.(config)
+		if !ok {
+			return nil, fmt.Errorf("localratelimit: incorrect override config type provided (%T): %v", override, override)
+		}
+	}
+
+	if c.bucket == nil || c.bucket.enabledNum == 0 {
+		return nil, nil
+	}
+	return &interceptor{bucket: c.bucket}, nil
+}
+
+// For overriding in tests
+var randIntn = grpcrand.Intn
+var timeNow = time.Now
+
+type interceptor struct {
+	bucket *tokenBucket
+}
+
+func (i *interceptor) AllowRPC(ctx context.Context) error {
+	b := i.bucket
+	if randIntn(b.enabledDen) >= b.enabledNum {
+		return nil
+	}
+	if b.takeToken() {
+		return nil
+	}
+	if randIntn(b.enforcedDen) >= b.enforcedNum {
+		// Limited but not enforced; let the RPC through.
+		return nil
+	}
+	return status.Errorf(codes.Unavailable, "RPC rejected by local rate limit")
+}
+
+// takeToken refills the bucket for the fill intervals elapsed since the last
+// refill and consumes a token if one is available.
+func (b *tokenBucket) takeToken() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := timeNow()
+	if fills := now.Sub(b.lastFill) / b.fillInterval; fills > 0 {
+		tokens := uint64(b.tokens) + uint64(fills)*uint64(b.tokensPerFill)
+		if tokens > uint64(b.maxTokens) {
+			tokens = uint64(b.maxTokens)
+		}
+		b.tokens = uint32(tokens)
+		b.lastFill = b.lastFill.Add(fills * b.fillInterval)
+	}
+	if b.tokens == 0 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,77 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package localratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+import (
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	pb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/local_ratelimit/v3"
+	v3typepb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestLimitSharedAcrossInterceptors(t *testing.T) {
+	always := &v3corepb.RuntimeFractionalPercent{DefaultValue: &v3typepb.FractionalPercent{
+		Numerator:   100,
+		Denominator: v3typepb.FractionalPercent_HUNDRED,
+	}}
+	cfg, err := ptypes.MarshalAny(&pb.LocalRateLimit{
+		StatPrefix: "test",
+		TokenBucket: &v3typepb.TokenBucket{
+			MaxTokens:    1,
+			FillInterval: durationpb.New(time.Hour),
+		},
+		FilterEnabled:  always,
+		FilterEnforced: always,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := builder{}
+	fc, err := b.ParseFilterConfig(cfg)
+	if err != nil {
+		t.Fatalf("ParseFilterConfig() failed: %v", err)
+	}
+
+	// Each connection builds its own interceptors from the parsed config.
+	first, err := b.BuildServerInterceptor(fc, nil)
+	if err != nil {
+		t.Fatalf("BuildServerInterceptor() failed: %v", err)
+	}
+	second, err := b.BuildServerInterceptor(fc, nil)
+	if err != nil {
+		t.Fatalf("BuildServerInterceptor() failed: %v", err)
+	}
+	if err := first.AllowRPC(context.Background()); err != nil {
+		t.Fatalf("AllowRPC() on the first interceptor = %v, want nil", err)
+	}
+	if err := second.AllowRPC(context.Background()); status.Code(err) != codes.Unavailable {
+		t.Errorf("AllowRPC() on the second interceptor = %v, want code %v", err, codes.Unavailable)
+	}
+}
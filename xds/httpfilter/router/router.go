@@ -62,7 +62,9 @@ func (builder) TypeURLs() []string { return []string{TypeURL} }
 
 func (builder) ParseFilterConfig(cfg proto.Message) (httpfilter.FilterConfig, error) {
 	// The gRPC router filter does not currently use any fields from the
-	// config.  Verify type only.
+	// config. Fields which only affect stats, tracing or Envoy's own headers
+	// are ignored, but those which would change how requests are handled are
+	// rejected, as they can't be honored.
 	if cfg == nil {
 		return nil, fmt.Errorf("router: nil configuration message provided")
 	}
@@ -74,6 +76,16 @@ func (builder) ParseFilterConfig(cfg proto.Message) (httpfilter.FilterConfig, er
 	if err := ptypes.UnmarshalAny(any, msg); err != nil {
 		return nil, fmt.Errorf("router: error parsing config %v: %v", cfg, err)
 	}
+	if len(msg.GetUpstreamLog()) != 0 {
+		return nil, fmt.Errorf("router: unsupported field upstream_log is set in config %v", cfg)
+	}
+	if len(msg.GetStrictCheckHeaders()) != 0 {
+		return nil, fmt.Errorf("router: unsupported field strict_check_headers is set in config %v", cfg)
+	}
+	// Fields unknown to this version of the proto may be anything.
+	if len(msg.ProtoReflect().GetUnknown()) != 0 {
+		return nil, fmt.Errorf("router: config %v has unknown fields", cfg)
+	}
 	return config{}, nil
 }
 
@@ -0,0 +1,188 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package statefulsession implements the Envoy stateful_session HTTP filter
+// with cookie based session state.
+package statefulsession
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+import (
+	sspb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/stateful_session/v3"
+	cookiepb "github.com/envoyproxy/go-control-plane/envoy/extensions/http/stateful_session/cookie/v3"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+import (
+	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
+	iresolver "dubbo.apache.org/dubbo-go/v3/xds/utils/resolver"
+)
+
+const (
+	// TypeURL is the message type for the stateful session filter
+	// configuration.
+	TypeURL = "type.googleapis.com/envoy.extensions.filters.http.stateful_session.v3.StatefulSession"
+	// PerRouteTypeURL is the message type for the per-route override of the
+	// stateful session filter.
+	PerRouteTypeURL = "type.googleapis.com/envoy.extensions.filters.http.stateful_session.v3.StatefulSessionPerRoute"
+	// CookieSessionStateTypeURL is the message type for the cookie based
+	// session state, the only session state supported.
+	CookieSessionStateTypeURL = "type.googleapis.com/envoy.extensions.http.stateful_session.cookie.v3.CookieBasedSessionState"
+)
+
+func init() {
+	httpfilter.Register(builder{})
+}
+
+type builder struct {
+}
+
+// CookieConfig is the cookie which carries the session affinity of a request.
+type CookieConfig struct {
+	Name string
+	Path string
+	// TTL is the lifetime of the cookie; zero means a session cookie.
+	TTL time.Duration
+}
+
+type config struct {
+	httpfilter.FilterConfig
+	// cookie is nil if stateful sessions are disabled.
+	cookie *CookieConfig
+}
+
+func (builder) TypeURLs() []string { return []string{TypeURL, PerRouteTypeURL} }
+
+func parseConfig(msg *sspb.StatefulSession) (config, error) {
+	state := msg.GetSessionState()
+	if state == nil {
+		// No session state means the filter is a no-op.
+		return config{}, nil
+	}
+	if url := state.GetTypedConfig().GetTypeUrl(); url != CookieSessionStateTypeURL {
+		return config{}, fmt.Errorf("stateful_session: unsupported session state %q: %w", url, httpfilter.ErrUnsupportedExtension)
+	}
+	cookieState := new(cookiepb.CookieBasedSessionState)
+	if err := ptypes.UnmarshalAny(state.GetTypedConfig(), cookieState); err != nil {
+		return config{}, fmt.Errorf("stateful_session: error parsing session state %v: %v", state, err)
+	}
+	cookie := cookieState.GetCookie()
+	if cookie.GetName() == "" {
+		return config{}, fmt.Errorf("stateful_session: empty cookie name in session state %v", state)
+	}
+	c := &CookieConfig{Name: cookie.GetName(), Path: cookie.GetPath()}
+	if ttl := cookie.GetTtl(); ttl != nil {
+		if err := ttl.CheckValid(); err != nil {
+			return config{}, fmt.Errorf("stateful_session: invalid cookie ttl %v: %v", ttl, err)
+		}
+		c.TTL = ttl.AsDuration()
+	}
+	return config{cookie: c}, nil
+}
+
+func (builder) ParseFilterConfig(cfg proto.Message) (httpfilter.FilterConfig, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("stateful_session: nil configuration message provided")
+	}
+	any, ok := cfg.(*anypb.Any)
+	if !ok {
+		return nil, fmt.Errorf("stateful_session: error parsing config %v: unknown type %T", cfg, cfg)
+	}
+	msg := new(sspb.StatefulSession)
+	if err := ptypes.UnmarshalAny(any, msg); err != nil {
+		return nil, fmt.Errorf("stateful_session: error parsing config %v: %v", cfg, err)
+	}
+	return parseConfig(msg)
+}
+
+func (builder) ParseFilterConfigOverride(override proto.Message) (httpfilter.FilterConfig, error) {
+	if override == nil {
+		return nil, fmt.Errorf("stateful_session: nil configuration message provided")
+	}
+	any, ok := override.(*anypb.Any)
+	if !ok {
+		return nil, fmt.Errorf("stateful_session: error parsing override config %v: unknown type %T", override, override)
+	}
+	msg := new(sspb.StatefulSessionPerRoute)
+	if err := ptypes.UnmarshalAny(any, msg); err != nil {
+		return nil, fmt.Errorf("stateful_session: error parsing override config %v: %v", override, err)
+	}
+	switch o := msg.GetOverride().(type) {
+	case *sspb.StatefulSessionPerRoute_Disabled:
+		if !o.Disabled {
+			return nil, fmt.Errorf("stateful_session: override config %v sets disabled to false", override)
+		}
+		return config{}, nil
+	case *sspb.StatefulSessionPerRoute_StatefulSession:
+		return parseConfig(o.StatefulSession)
+	default:
+		return nil, fmt.Errorf("stateful_session: override config %v has no override", override)
+	}
+}
+
+func (builder) IsTerminal() bool {
+	return false
+}
+
+var _ httpfilter.ClientInterceptorBuilder = builder{}
+
+func (builder) BuildClientInterceptor(cfg, override httpfilter.FilterConfig) (iresolver.ClientInterceptor, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("stateful_session: nil config provided")
+	}
+	c, ok := cfg.(config)
+	if !ok {
+		return nil, fmt.Errorf("stateful_session: incorrect config type provided (%T): %v", cfg, cfg)
+	}
+	if override != nil {
+		// override completely replaces the listener configuration.
+		c, ok = override.(config)
+		if !ok {
+			return nil, fmt.Errorf("stateful_session: incorrect override config type provided (%T): %v", override, override)
+		}
+	}
+	if c.cookie == nil {
+		return nil, nil
+	}
+	return &interceptor{cookie: c.cookie}, nil
+}
+
+type cookieKey struct{}
+
+// CookieConfigFromContext returns the session cookie configured for the RPC
+// of ctx, for the load balancer to pin the RPC to the host named by the
+// cookie. ok is false if stateful sessions are not enabled for the RPC.
+func CookieConfigFromContext(ctx context.Context) (c *CookieConfig, ok bool) {
+	c, ok = ctx.Value(cookieKey{}).(*CookieConfig)
+	return c, ok
+}
+
+type interceptor struct {
+	cookie *CookieConfig
+}
+
+func (i *interceptor) NewStream(ctx context.Context, _ iresolver.RPCInfo, done func(), newStream func(ctx context.Context, done func()) (iresolver.ClientStream, error)) (iresolver.ClientStream, error) {
+	return newStream(context.WithValue(ctx, cookieKey{}, i.cookie), done)
+}
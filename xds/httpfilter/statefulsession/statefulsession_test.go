@@ -0,0 +1,205 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package statefulsession
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+import (
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	sspb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/stateful_session/v3"
+	cookiepb "github.com/envoyproxy/go-control-plane/envoy/extensions/http/stateful_session/cookie/v3"
+	v3httppb "github.com/envoyproxy/go-control-plane/envoy/type/http/v3"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+import (
+	"dubbo.apache.org/dubbo-go/v3/xds/httpfilter"
+	iresolver "dubbo.apache.org/dubbo-go/v3/xds/utils/resolver"
+)
+
+func marshalAny(t *testing.T, m proto.Message) *anypb.Any {
+	t.Helper()
+	a, err := ptypes.MarshalAny(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// cookieSession returns a stateful session config keeping the session state
+// in cookie.
+func cookieSession(t *testing.T, cookie *v3httppb.Cookie) *sspb.StatefulSession {
+	return &sspb.StatefulSession{SessionState: &v3corepb.TypedExtensionConfig{
+		Name:        "envoy.http.stateful_session.cookie",
+		TypedConfig: marshalAny(t, &cookiepb.CookieBasedSessionState{Cookie: cookie}),
+	}}
+}
+
+// cookieOf returns the session cookie the interceptor built from cfg and
+// override adds to the context of RPCs, or nil if there is no interceptor.
+func cookieOf(t *testing.T, cfg, override httpfilter.FilterConfig) *CookieConfig {
+	t.Helper()
+	i, err := builder{}.BuildClientInterceptor(cfg, override)
+	if err != nil {
+		t.Fatalf("BuildClientInterceptor() failed: %v", err)
+	}
+	if i == nil {
+		return nil
+	}
+	var cookie *CookieConfig
+	newStream := func(ctx context.Context, _ func()) (iresolver.ClientStream, error) {
+		c, ok := CookieConfigFromContext(ctx)
+		if !ok {
+			t.Error("CookieConfigFromContext() found no cookie in the context of the stream")
+		}
+		cookie = c
+		return nil, nil
+	}
+	if _, err := i.NewStream(context.Background(), iresolver.RPCInfo{}, func() {}, newStream); err != nil {
+		t.Fatalf("NewStream() failed: %v", err)
+	}
+	return cookie
+}
+
+func TestParseFilterConfig(t *testing.T) {
+	tests := []struct {
+		name            string
+		cfg             *sspb.StatefulSession
+		want            *CookieConfig
+		wantErr         bool
+		wantUnsupported bool
+	}{
+		{
+			name: "no session state",
+			cfg:  &sspb.StatefulSession{},
+		},
+		{
+			name: "cookie",
+			cfg:  cookieSession(t, &v3httppb.Cookie{Name: "session", Path: "/", Ttl: durationpb.New(time.Hour)}),
+			want: &CookieConfig{Name: "session", Path: "/", TTL: time.Hour},
+		},
+		{
+			name: "session cookie",
+			cfg:  cookieSession(t, &v3httppb.Cookie{Name: "session"}),
+			want: &CookieConfig{Name: "session"},
+		},
+		{
+			name:    "cookie without name",
+			cfg:     cookieSession(t, &v3httppb.Cookie{}),
+			wantErr: true,
+		},
+		{
+			name:    "invalid ttl",
+			cfg:     cookieSession(t, &v3httppb.Cookie{Name: "session", Ttl: &durationpb.Duration{Seconds: 1, Nanos: -1}}),
+			wantErr: true,
+		},
+		{
+			name: "header session state",
+			cfg: &sspb.StatefulSession{SessionState: &v3corepb.TypedExtensionConfig{
+				Name:        "envoy.http.stateful_session.header",
+				TypedConfig: &anypb.Any{TypeUrl: "type.googleapis.com/envoy.extensions.http.stateful_session.header.v3.HeaderBasedSessionState"},
+			}},
+			wantErr:         true,
+			wantUnsupported: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := builder{}.ParseFilterConfig(marshalAny(t, test.cfg))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseFilterConfig() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if errors.Is(err, httpfilter.ErrUnsupportedExtension) != test.wantUnsupported {
+				t.Errorf("ParseFilterConfig() returned err %v, want ErrUnsupportedExtension: %v", err, test.wantUnsupported)
+			}
+			if err != nil {
+				return
+			}
+			if got := cookieOf(t, cfg, nil); !equalCookies(got, test.want) {
+				t.Errorf("session cookie = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterConfigOverride(t *testing.T) {
+	cfg, err := builder{}.ParseFilterConfig(marshalAny(t, cookieSession(t, &v3httppb.Cookie{Name: "session"})))
+	if err != nil {
+		t.Fatalf("ParseFilterConfig() failed: %v", err)
+	}
+	tests := []struct {
+		name     string
+		override *sspb.StatefulSessionPerRoute
+		want     *CookieConfig
+		wantErr  bool
+	}{
+		{
+			name:     "disabled",
+			override: &sspb.StatefulSessionPerRoute{Override: &sspb.StatefulSessionPerRoute_Disabled{Disabled: true}},
+		},
+		{
+			name: "stateful session",
+			override: &sspb.StatefulSessionPerRoute{Override: &sspb.StatefulSessionPerRoute_StatefulSession{
+				StatefulSession: cookieSession(t, &v3httppb.Cookie{Name: "route-session", Path: "/svc"}),
+			}},
+			want: &CookieConfig{Name: "route-session", Path: "/svc"},
+		},
+		{
+			name:     "disabled set to false",
+			override: &sspb.StatefulSessionPerRoute{Override: &sspb.StatefulSessionPerRoute_Disabled{}},
+			wantErr:  true,
+		},
+		{
+			name:     "no override",
+			override: &sspb.StatefulSessionPerRoute{},
+			wantErr:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			override, err := builder{}.ParseFilterConfigOverride(marshalAny(t, test.override))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseFilterConfigOverride() returned err %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			// The override replaces the listener config.
+			if got := cookieOf(t, cfg, override); !equalCookies(got, test.want) {
+				t.Errorf("session cookie = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func equalCookies(a, b *CookieConfig) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -163,7 +163,7 @@ func (w *serviceUpdateWatcher) handleLDSResp(update resource.ListenerUpdate, err
 }
 
 func (w *serviceUpdateWatcher) applyRouteConfigUpdate(update resource.RouteConfigUpdate) {
-	matchVh := resource.FindBestMatchingVirtualHost(w.serviceName, update.VirtualHosts)
+	matchVh := update.FindBestMatchingVirtualHost(w.serviceName)
 	if matchVh == nil {
 		// No matching virtual host found.
 		w.serviceCb(serviceUpdate{}, fmt.Errorf("no matching virtual host found for %q", w.serviceName))
@@ -0,0 +1,81 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// clientHelloTimeout bounds how long Accept() waits for the TLS ClientHello
+// of a connection. Other connections aren't accepted meanwhile, so it is kept
+// short; a connection which sends nothing in time is matched as raw_buffer.
+const clientHelloTimeout = time.Second
+
+// errClientHelloRead aborts the handshake run by peekClientHello once the
+// ClientHello has been read.
+var errClientHelloRead = errors.New("client hello read")
+
+// peekClientHello reads the TLS ClientHello starting conn, if any. The
+// returned net.Conn replays the bytes read to its reader, so conn must not be
+// read from directly afterwards. The ClientHello is nil if conn doesn't start
+// with one.
+func peekClientHello(conn net.Conn, timeout time.Duration) (*tls.ClientHelloInfo, net.Conn) {
+	var (
+		buf   bytes.Buffer
+		hello *tls.ClientHelloInfo
+	)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	// A handshake is the only way to have crypto/tls parse a ClientHello. It
+	// reads through buf, and is aborted once the ClientHello is parsed.
+	tls.Server(&readOnlyConn{Conn: conn, r: io.TeeReader(conn, &buf)}, &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = &tls.ClientHelloInfo{
+				ServerName:      h.ServerName,
+				SupportedProtos: h.SupportedProtos,
+			}
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+	conn.SetReadDeadline(time.Time{})
+	return hello, &replayConn{Conn: conn, r: io.MultiReader(&buf, conn)}
+}
+
+// readOnlyConn is the net.Conn of the handshake run by peekClientHello. It
+// reads from r, and drops the alerts the handshake sends when aborted.
+type readOnlyConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readOnlyConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+func (c *readOnlyConn) Write(b []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+// replayConn is a net.Conn reading the bytes already read from it by
+// peekClientHello before those still to come.
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) { return c.r.Read(b) }
@@ -0,0 +1,70 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPeekClientHelloTLS(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go tls.Client(client, &tls.Config{
+		ServerName:         "foo.example.com",
+		NextProtos:         []string{"h2", "http/1.1"},
+		InsecureSkipVerify: true,
+	}).Handshake()
+
+	hello, _ := peekClientHello(server, time.Second)
+	if hello == nil {
+		t.Fatal("peekClientHello() returned no ClientHello")
+	}
+	if hello.ServerName != "foo.example.com" {
+		t.Errorf("ServerName = %q, want %q", hello.ServerName, "foo.example.com")
+	}
+	if want := []string{"h2", "http/1.1"}; !reflect.DeepEqual(hello.SupportedProtos, want) {
+		t.Errorf("SupportedProtos = %q, want %q", hello.SupportedProtos, want)
+	}
+}
+
+func TestPeekClientHelloRawBuffer(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	const preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+	go client.Write([]byte(preface))
+
+	hello, conn := peekClientHello(server, time.Second)
+	if hello != nil {
+		t.Fatalf("peekClientHello() returned ClientHello %+v, want none", hello)
+	}
+	// The bytes read while looking for a ClientHello are replayed.
+	got := make([]byte, len(preface))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("reading the connection failed: %v", err)
+	}
+	if string(got) != preface {
+		t.Errorf("read %q, want %q", got, preface)
+	}
+}
@@ -26,6 +26,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -191,6 +192,10 @@ type listenerWrapper struct {
 	mode connectivity.ServingMode
 	// Filter chains received as part of the last good update.
 	filterChains *resource.FilterChainManager
+	// tlsInspector is set if the last good update has the TLS inspector
+	// listener filter, in which case the TLS ClientHello of connections is
+	// read to match the filter chains.
+	tlsInspector bool
 
 	// rdsHandler is used for any dynamic RDS resources specified in a LDS
 	// update.
@@ -245,6 +250,29 @@ func (l *listenerWrapper) Accept() (net.Conn, error) {
 			// us to stop serving.
 			return nil, fmt.Errorf("received connection with non-TCP address (local: %T, remote %T)", conn.LocalAddr(), conn.RemoteAddr())
 		}
+		l.mu.RLock()
+		inspectTLS := l.tlsInspector
+		l.mu.RUnlock()
+		params := resource.FilterChainLookupParams{
+			IsUnspecifiedListener: l.isUnspecifiedAddr,
+			DestAddr:              destAddr.IP,
+			DestPort:              destAddr.Port,
+			SourceAddr:            srcAddr.IP,
+			SourcePort:            srcAddr.Port,
+			TransportProtocol:     "raw_buffer",
+		}
+		if inspectTLS {
+			// The server name, transport protocol and application protocols
+			// are taken from the TLS ClientHello, if the connection starts
+			// with one.
+			var hello *tls.ClientHelloInfo
+			hello, conn = peekClientHello(conn, clientHelloTimeout)
+			if hello != nil {
+				params.ServerName = hello.ServerName
+				params.TransportProtocol = "tls"
+				params.ApplicationProtocols = hello.SupportedProtos
+			}
+		}
 
 		l.mu.RLock()
 		if l.mode == connectivity.ServingModeNotServing {
@@ -258,12 +286,7 @@ func (l *listenerWrapper) Accept() (net.Conn, error) {
 			conn.Close()
 			continue
 		}
-		fc, err := l.filterChains.Lookup(resource.FilterChainLookupParams{
-			IsUnspecifiedListener: l.isUnspecifiedAddr,
-			DestAddr:              destAddr.IP,
-			SourceAddr:            srcAddr.IP,
-			SourcePort:            srcAddr.Port,
-		})
+		fc, err := l.filterChains.Lookup(params)
 		l.mu.RUnlock()
 		if err != nil {
 			// When a matching filter chain is not found, we close the
@@ -281,6 +304,13 @@ func (l *listenerWrapper) Accept() (net.Conn, error) {
 			conn.Close()
 			continue
 		}
+		if fc.TCPProxy != nil {
+			// The connections of tcp_proxy filter chains must be forwarded
+			// to their cluster, which the gRPC server can't do.
+			l.logger.Warnf("connection from %s to %s matched a tcp_proxy filter chain, which is not supported", conn.RemoteAddr().String(), conn.LocalAddr().String())
+			conn.Close()
+			continue
+		}
 		if !envconfig.XDSRBAC {
 			return &connWrapper{Conn: conn, filterChain: fc, parent: l}, nil
 		}
@@ -422,10 +452,19 @@ func (l *listenerWrapper) handleLDSUpdate(update ldsUpdateWithError) {
 	// Server's state to ServingModeNotServing. That prevents new connections
 	// from being accepted, whereas here we simply want the clients to reconnect
 	// to get the updated configuration.
-	if envconfig.XDSRBAC {
-		if l.drainCallback != nil {
-			l.drainCallback(l.Listener.Addr())
-		}
+	l.mu.Lock()
+	l.tlsInspector = ilc.TLSInspector
+	l.mu.Unlock()
+
+	if !envconfig.XDSRBAC {
+		// Accept() doesn't use the route configurations without RBAC, so
+		// they aren't watched nor waited for.
+		l.switchMode(ilc.FilterChains, connectivity.ServingModeServing, nil)
+		l.goodUpdate.Fire()
+		return
+	}
+	if l.drainCallback != nil {
+		l.drainCallback(l.Listener.Addr())
 	}
 	l.rdsHandler.updateRouteNamesToWatch(ilc.FilterChains.RouteConfigNames)
 	// If there are no dynamic RDS Configurations still needed to be received
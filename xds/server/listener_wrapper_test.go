@@ -0,0 +1,142 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+import (
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	v3routerpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	v3httppb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+
+	"github.com/golang/protobuf/ptypes"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+import (
+	dubboLogger "dubbo.apache.org/dubbo-go/v3/common/logger"
+	"dubbo.apache.org/dubbo-go/v3/xds/client/bootstrap"
+	"dubbo.apache.org/dubbo-go/v3/xds/client/resource"
+	_ "dubbo.apache.org/dubbo-go/v3/xds/httpfilter/router"
+	"dubbo.apache.org/dubbo-go/v3/xds/utils/envconfig"
+	"dubbo.apache.org/dubbo-go/v3/xds/utils/grpcsync"
+)
+
+// fakeXDSClient is an XDSClient recording the route configurations watched.
+type fakeXDSClient struct {
+	routeWatches []string
+}
+
+func (c *fakeXDSClient) WatchListener(string, func(resource.ListenerUpdate, error)) func() {
+	return func() {}
+}
+
+func (c *fakeXDSClient) WatchRouteConfig(name string, _ func(resource.RouteConfigUpdate, error)) func() {
+	c.routeWatches = append(c.routeWatches, name)
+	return func() {}
+}
+
+func (c *fakeXDSClient) BootstrapConfig() *bootstrap.Config { return &bootstrap.Config{} }
+
+// rdsFilterChainManager returns a FilterChainManager whose single filter chain
+// uses the route configuration "route" from RDS.
+func rdsFilterChainManager(t *testing.T) *resource.FilterChainManager {
+	t.Helper()
+	routerCfg, err := ptypes.MarshalAny(&v3routerpb.Router{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hcm, err := ptypes.MarshalAny(&v3httppb.HttpConnectionManager{
+		RouteSpecifier: &v3httppb.HttpConnectionManager_Rds{
+			Rds: &v3httppb.Rds{
+				ConfigSource: &v3corepb.ConfigSource{
+					ConfigSourceSpecifier: &v3corepb.ConfigSource_Ads{Ads: &v3corepb.AggregatedConfigSource{}},
+				},
+				RouteConfigName: "route",
+			},
+		},
+		HttpFilters: []*v3httppb.HttpFilter{{
+			Name:       "router",
+			ConfigType: &v3httppb.HttpFilter_TypedConfig{TypedConfig: routerCfg},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fcm, err := resource.NewFilterChainManager(&v3listenerpb.Listener{
+		FilterChains: []*v3listenerpb.FilterChain{{
+			Name: "filter-chain",
+			Filters: []*v3listenerpb.Filter{{
+				Name:       "hcm",
+				ConfigType: &v3listenerpb.Filter_TypedConfig{TypedConfig: hcm},
+			}},
+		}},
+	}, dubboLogger.GetLogger())
+	if err != nil {
+		t.Fatalf("NewFilterChainManager() failed: %v", err)
+	}
+	return fcm
+}
+
+func TestHandleLDSUpdateWithoutRBAC(t *testing.T) {
+	oldRBAC := envconfig.XDSRBAC
+	envconfig.XDSRBAC = false
+	defer func() { envconfig.XDSRBAC = oldRBAC }()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	xdsC := &fakeXDSClient{}
+	l := &listenerWrapper{
+		Listener:    lis,
+		logger:      dubboLogger.GetLogger(),
+		xdsC:        xdsC,
+		closed:      grpcsync.NewEvent(),
+		goodUpdate:  grpcsync.NewEvent(),
+		rdsUpdateCh: make(chan rdsHandlerUpdate, 1),
+	}
+	l.addr, l.port, _ = net.SplitHostPort(lis.Addr().String())
+	l.rdsHandler = newRDSHandler(xdsC, l.rdsUpdateCh)
+
+	l.handleLDSUpdate(ldsUpdateWithError{update: resource.ListenerUpdate{
+		InboundListenerCfg: &resource.InboundListenerConfig{
+			Address:      l.addr,
+			Port:         l.port,
+			FilterChains: rdsFilterChainManager(t),
+		},
+	}})
+
+	// The listener serves without waiting for the route configuration, which
+	// Accept() doesn't use.
+	if !l.goodUpdate.HasFired() {
+		t.Error("handleLDSUpdate() didn't signal a good update")
+	}
+	if l.mode != connectivity.ServingModeServing {
+		t.Errorf("mode = %v, want %v", l.mode, connectivity.ServingModeServing)
+	}
+	if len(xdsC.routeWatches) != 0 {
+		t.Errorf("watched route configurations %q, want none", xdsC.routeWatches)
+	}
+}
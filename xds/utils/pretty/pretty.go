@@ -28,6 +28,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"sync/atomic"
 )
 
 import (
@@ -78,6 +79,37 @@ func ToJSON(e interface{}) string {
 	}
 }
 
+// DefaultTruncateLimit is the default maximum length, in bytes, of the
+// renderings produced by Lazy.
+const DefaultTruncateLimit = 64 * 1024
+
+var truncateLimit int64 = DefaultTruncateLimit // accessed atomically
+
+// SetTruncateLimit sets the maximum length of the renderings produced by Lazy.
+// A limit <= 0 disables truncation.
+func SetTruncateLimit(n int) {
+	atomic.StoreInt64(&truncateLimit, int64(n))
+}
+
+// Lazy returns a fmt.Stringer which renders e with ToJSON only when it is
+// formatted, so that nothing is computed for log lines which are discarded.
+// Renderings longer than the truncate limit are clipped.
+func Lazy(e interface{}) fmt.Stringer {
+	return lazyJSON{e: e}
+}
+
+type lazyJSON struct {
+	e interface{}
+}
+
+func (l lazyJSON) String() string {
+	s := ToJSON(l.e)
+	if limit := int(atomic.LoadInt64(&truncateLimit)); limit > 0 && len(s) > limit {
+		return fmt.Sprintf("%s...(truncated %d bytes)", s[:limit], len(s)-limit)
+	}
+	return s
+}
+
 // FormatJSON formats the input json bytes with indentation.
 //
 // If Indent fails, it returns the unchanged input as string.
@@ -194,9 +194,11 @@ func matchersFromPrincipals(principals []*v3rbacpb.Principal) ([]matcher, error)
 			}
 			matchers = append(matchers, &notMatcher{matcherToNot: mList[0]})
 		case *v3rbacpb.Principal_SourceIp:
-			// The source ip principal identifier is deprecated. Thus, a
-			// principal typed as a source ip in the identifier will be a no-op.
-			// The config should use DirectRemoteIp instead.
+			// The source ip principal identifier is deprecated, the config
+			// should use DirectRemoteIp instead. It is rejected rather than
+			// ignored, as ignoring a principal could change which requests
+			// the policy allows.
+			return nil, fmt.Errorf("unsupported principal type %T", principal.GetIdentifier())
 		case *v3rbacpb.Principal_RemoteIp:
 			// RBAC in gRPC treats direct_remote_ip and remote_ip as logically
 			// equivalent, as per A41.
@@ -205,9 +207,10 @@ func matchersFromPrincipals(principals []*v3rbacpb.Principal) ([]matcher, error)
 				return nil, err
 			}
 			matchers = append(matchers, m)
-		case *v3rbacpb.Principal_Metadata:
-			// Not supported in gRPC RBAC currently - a principal typed as
-			// Metadata in the initial config will be a no-op.
+		default:
+			// Metadata principals, and any identifier added to the proto
+			// after this was written, are not supported.
+			return nil, fmt.Errorf("unsupported principal type %T", principal.GetIdentifier())
 		}
 	}
 	return matchers, nil